@@ -0,0 +1,99 @@
+/*
+Copyright © 2021 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/terashin777/assembler/modules"
+)
+
+var (
+	fmtCheck bool
+	fmtWrite bool
+	fmtNames bool
+)
+
+// asmfmtCmd represents the asmfmt command
+var asmfmtCmd = &cobra.Command{
+	Use:   "asmfmt [files]",
+	Short: "format your assembly",
+	Long: `format your assembly.
+Instructions are indented under labels, trailing comments are aligned and comp is spelled the canonical way.
+The formatted source is printed unless --write or --check is given. Use - to read stdin.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		unformatted := false
+		for _, path := range args {
+			ok, err := asmfmt(path)
+			if err != nil {
+				fmt.Printf("asmfmt is failed because: %s\n", err)
+				os.Exit(1)
+			}
+			if !ok && fmtCheck {
+				fmt.Println(path)
+				unformatted = true
+			}
+		}
+		if unformatted {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(asmfmtCmd)
+
+	asmfmtCmd.Flags().BoolVar(&fmtCheck, "check", false, "print the files not formatted and exit with 1 if there are any")
+	asmfmtCmd.Flags().BoolVarP(&fmtWrite, "write", "w", false, "write the result to the file instead of stdout")
+	asmfmtCmd.Flags().BoolVarP(&fmtNames, "names", "n", false, "rewrite numeric addresses like @13 or @16384 to R13 or SCREEN")
+}
+
+// asmfmt formats the file at path and tells whether it was formatted already.
+func asmfmt(path string) (bool, error) {
+	r, err := openSource(path)
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+
+	src := &bytes.Buffer{}
+	b := &bytes.Buffer{}
+	if err = modules.FormatSource(io.TeeReader(r, src), b, modules.FormatOptions{Names: fmtNames}); err != nil {
+		return false, err
+	}
+	if err = r.Close(); err != nil {
+		return false, err
+	}
+	ok := bytes.Equal(src.Bytes(), b.Bytes())
+
+	switch {
+	case fmtCheck:
+		return ok, nil
+	case fmtWrite && path != stdio:
+		if ok {
+			return true, nil
+		}
+		return ok, os.WriteFile(path, b.Bytes(), 0644)
+	default:
+		_, err = b.WriteTo(os.Stdout)
+		return ok, err
+	}
+}
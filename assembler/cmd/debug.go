@@ -0,0 +1,73 @@
+/*
+Copyright © 2021 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/terashin777/assembler/debug"
+	"github.com/terashin777/assembler/modules"
+)
+
+var (
+	debugWindow int
+	debugCycles uint64
+)
+
+// debugCmd represents the debug command
+var debugCmd = &cobra.Command{
+	Use:   "debug [file]",
+	Short: "debug your assembly or hack on the hack cpu",
+	Long: `debug your assembly or hack on the hack cpu.
+Breakpoints are set by label, RAM is watched by variable or address and each stop shows the registers,
+the source line of PC and RAM around SP, LCL and ARG. Type help for the commands.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := debugProgram(args[0])
+		var errs modules.ErrorList
+		if errors.As(err, &errs) {
+			for _, e := range errs {
+				fmt.Fprintln(os.Stderr, e)
+			}
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Printf("debug is failed because: %s\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(debugCmd)
+
+	debugCmd.Flags().IntVarP(&debugWindow, "window", "w", 2, "number of RAM words shown on each side of SP, LCL and ARG")
+	debugCmd.Flags().Uint64VarP(&debugCycles, "cycles", "c", 1000000, "max number of instructions continue executes (0 is no limit)")
+}
+
+func debugProgram(path string) error {
+	d, err := debug.NewDebugger(path)
+	if err != nil {
+		return err
+	}
+	d.SetWindow(debugWindow)
+	d.SetLimit(debugCycles)
+
+	return d.Run(os.Stdin, os.Stdout)
+}
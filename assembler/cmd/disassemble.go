@@ -0,0 +1,113 @@
+/*
+Copyright © 2021 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/terashin777/assembler/modules"
+)
+
+var (
+	disassembleDest string
+	useSymbols      bool
+)
+
+// disassembleCmd represents the disassemble command
+var disassembleCmd = &cobra.Command{
+	Use:   "disassemble [file]",
+	Short: "disassemble your hack to assembly",
+	Long: `disassemble your hack to assembly.
+Jump targets get L<address> labels, so assembling the output reproduces the same hack.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := disassemble(args[0], disassembleDest)
+		var errs modules.ErrorList
+		if errors.As(err, &errs) {
+			for _, e := range errs {
+				fmt.Fprintln(os.Stderr, e)
+			}
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Printf("disassemble is failed because: %s", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(disassembleCmd)
+
+	disassembleCmd.Flags().StringVarP(&disassembleDest, "dest", "d", "", "destination for disassembled file (default is stdout)")
+	disassembleCmd.Flags().BoolVarP(&useSymbols, "symbols", "s", false, "use predefined symbols like SP or SCREEN for memory addresses")
+}
+
+func disassemble(path, dest string) error {
+	r, err := openSource(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	d, err := modules.NewDisassembler(path, r)
+	if err != nil {
+		return err
+	}
+	d.UseSymbols(useSymbols)
+
+	var fw io.Writer = os.Stdout
+	if dest != "" {
+		f, err := createDestFile(dest)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fw = f
+	}
+
+	w := bufio.NewWriter(fw)
+	errs := modules.ErrorList{}
+	for {
+		s, err := d.Read()
+		if err == io.EOF {
+			break
+		}
+		var e *modules.Error
+		if errors.As(err, &e) {
+			errs = append(errs, e)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = w.WriteString(s + "\n")
+		if err != nil {
+			return err
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return w.Flush()
+}
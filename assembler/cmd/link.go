@@ -0,0 +1,145 @@
+/*
+Copyright © 2021 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/terashin777/assembler/modules"
+	"github.com/terashin777/assembler/table"
+)
+
+var (
+	linkDest      string
+	linkSymbolMap string
+	linkFormat    string
+	linkISA       string
+	linkOptimize  bool
+)
+
+// linkCmd represents the link command
+var linkCmd = &cobra.Command{
+	Use:   "link [files]",
+	Short: "link your objects to hack",
+	Long: `link your objects (.hobj) to hack.
+Objects are placed in ROM in the given order. Assembly files (.asm) are assembled to objects on the fly.
+Labels are local to their file unless declared by ".global NAME", which lets other files jump to them.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := link(args, linkDest)
+		var errs modules.ErrorList
+		if errors.As(err, &errs) {
+			for _, e := range errs {
+				fmt.Fprintln(os.Stderr, e)
+			}
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Printf("link is failed because: %s\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(linkCmd)
+
+	linkCmd.Flags().StringVarP(&linkDest, "dest", "d", defaultDestDir, "destination for linked file")
+	linkCmd.Flags().StringVarP(&linkFormat, "format", "f", modules.DefaultFormat, fmt.Sprintf("format of the linked file (%s)", strings.Join(modules.Formats(), ", ")))
+	linkCmd.Flags().StringVar(&linkISA, "isa", table.ISA_HACK, fmt.Sprintf("instruction set of assembly files (%s)", strings.Join(table.ISAs, ", ")))
+	linkCmd.Flags().BoolVarP(&linkOptimize, "optimize", "O", false, "run the peephole optimizer over assembly files")
+	linkCmd.Flags().StringVar(&linkSymbolMap, "symbol-map", "", "write labels and variables to this file (json if it ends with .json)")
+}
+
+func link(paths []string, dest string) error {
+	objs := []*modules.Object{}
+	for _, path := range paths {
+		o, err := readObject(path)
+		if err != nil {
+			return err
+		}
+		objs = append(objs, o)
+	}
+
+	words, syms, err := modules.Link(objs)
+	if err != nil {
+		return err
+	}
+
+	ext, err := modules.FormatExt(linkFormat)
+	if err != nil {
+		return err
+	}
+	if dest == defaultDestDir {
+		dest = filepath.Join(filepath.Dir(paths[0]), makeSameFileName(paths[0], ext))
+	}
+	f, err := createDestFile(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, err := modules.NewWordWriter(linkFormat, f)
+	if err != nil {
+		return err
+	}
+	for _, v := range words {
+		if err = w.WriteWord(v); err != nil {
+			return err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+
+	if linkSymbolMap != "" {
+		return writeSymbolMap(linkSymbolMap, syms)
+	}
+	return nil
+}
+
+func readObject(path string) (*modules.Object, error) {
+	r, err := openSource(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	if filepath.Ext(path) == modules.ObjectExt {
+		return modules.ReadObject(r)
+	}
+
+	opts := []modules.ParserOption{}
+	if linkOptimize {
+		opts = append(opts, modules.WithOptimizer())
+	}
+	p, err := modules.NewParser(path, r, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if linkOptimize {
+		fmt.Fprintf(os.Stderr, "%s: optimizer saved %d words\n", path, p.Saved())
+	}
+	if err = p.SetISA(linkISA); err != nil {
+		return nil, err
+	}
+	return modules.NewObject(p)
+}
@@ -16,7 +16,8 @@ limitations under the License.
 package cmd
 
 import (
-	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -25,6 +26,7 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/terashin777/assembler/modules"
+	"github.com/terashin777/assembler/table"
 
 	"github.com/spf13/viper"
 )
@@ -32,7 +34,12 @@ import (
 var (
 	cfgFile        string
 	dest           string
-	assembledExt   = ".hack"
+	symbolMap      string
+	listing        string
+	object         bool
+	outFormat      string
+	isa            string
+	optimize       bool
 	defaultDestDir = "same dir as source file"
 )
 
@@ -40,10 +47,18 @@ var (
 var rootCmd = &cobra.Command{
 	Use:   "assemble [file]",
 	Short: "assemble your assembly to hack",
-	Long:  `assemble your assembly to hack.`,
-	Args:  cobra.ExactArgs(2),
+	Long: `assemble your assembly to hack.
+Use - as the file to read stdin and as the destination to write stdout.`,
+	Args: cobra.ExactArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
 		err := assemble(args[1], dest)
+		var errs modules.ErrorList
+		if errors.As(err, &errs) {
+			for _, e := range errs {
+				fmt.Fprintln(os.Stderr, e)
+			}
+			os.Exit(1)
+		}
 		if err != nil {
 			fmt.Printf("assemble is failed because: %s", err)
 			os.Exit(1)
@@ -69,7 +84,13 @@ func init() {
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
-	rootCmd.Flags().StringVarP(&dest, "dest", "d", defaultDestDir, "destination for assembled file")
+	rootCmd.Flags().StringVarP(&dest, "dest", "d", defaultDestDir, "destination for assembled file (- is stdout)")
+	rootCmd.Flags().StringVar(&symbolMap, "symbol-map", "", "write labels and variables to this file (json if it ends with .json)")
+	rootCmd.Flags().StringVar(&listing, "listing", "", "write a listing of addresses, words and source lines to this file")
+	rootCmd.Flags().BoolVar(&object, "object", false, "write a relocatable object (.hobj) to link later instead of hack")
+	rootCmd.Flags().StringVar(&isa, "isa", table.ISA_HACK, fmt.Sprintf("instruction set to accept (%s)", strings.Join(table.ISAs, ", ")))
+	rootCmd.Flags().BoolVarP(&optimize, "optimize", "O", false, "remove redundant instructions with the peephole optimizer")
+	rootCmd.Flags().StringVarP(&outFormat, "format", "f", modules.DefaultFormat, fmt.Sprintf("format of the assembled file (%s)", strings.Join(modules.Formats(), ", ")))
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -97,63 +118,168 @@ func initConfig() {
 }
 
 func assemble(path, dest string) error {
-	r, err := os.Open(path)
+	r, err := openSource(path)
 	if err != nil {
 		return err
 	}
 	defer r.Close()
 
+	if object {
+		if dest == defaultDestDir {
+			dest = sameDest(path, modules.ObjectExt)
+		}
+		return assembleObject(r, path, dest)
+	}
+	ext, err := modules.FormatExt(outFormat)
+	if err != nil {
+		return err
+	}
 	if dest == defaultDestDir {
-		dest = filepath.Join(filepath.Dir(path), makeSameFileName(path))
+		dest = sameDest(path, ext)
+	}
+
+	b := &bytes.Buffer{}
+	res, err := modules.Assemble(r, b, modules.Options{
+		File:     path,
+		Format:   outFormat,
+		ISA:      isa,
+		Optimize: optimize,
+	})
+	if res != nil {
+		for _, d := range res.Diagnostics {
+			if d.Severity == modules.SEVERITY_WARNING {
+				fmt.Fprintln(os.Stderr, d)
+			}
+		}
 	}
+	if err != nil {
+		return err
+	}
+	if optimize {
+		fmt.Fprintf(os.Stderr, "optimizer saved %d words\n", res.Saved)
+	}
+
 	fw, err := createDestFile(dest)
 	if err != nil {
 		return err
 	}
 	defer fw.Close()
 
-	i, err := fw.Stat()
+	if _, err = b.WriteTo(fw); err != nil {
+		return err
+	}
+	if err = fw.Close(); err != nil {
+		return err
+	}
+
+	if symbolMap != "" {
+		if err = writeSymbolMap(symbolMap, res.Symbols); err != nil {
+			return err
+		}
+	}
+	if listing != "" {
+		if err = writeListing(listing, res.Listing); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func assembleObject(r io.Reader, path, dest string) error {
+	if symbolMap != "" || listing != "" {
+		return fmt.Errorf("--symbol-map and --listing can not be used with --object")
+	}
+
+	opts := []modules.ParserOption{}
+	if optimize {
+		opts = append(opts, modules.WithOptimizer())
+	}
+	p, err := modules.NewParser(path, r, opts...)
 	if err != nil {
 		return err
 	}
-	size := i.Size()
+	if err = p.SetISA(isa); err != nil {
+		return err
+	}
 
-	p, err := modules.NewParser(r, size)
+	o, err := modules.NewObject(p)
 	if err != nil {
 		return err
 	}
-	err = r.Close()
+
+	f, err := createDestFile(dest)
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 
-	w := bufio.NewWriter(fw)
-	for {
-		s, err := p.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
-		if s == "" {
-			continue
-		}
+	if err = modules.WriteObject(f, o); err != nil {
+		return err
+	}
 
-		_, err = w.WriteString(s + "\n")
-		if err != nil {
-			return err
-		}
+	return f.Close()
+}
+
+func writeSymbolMap(path string, syms []*modules.Symbol) error {
+	f, err := createDestFile(path)
+	if err != nil {
+		return err
 	}
-	if err = w.Flush(); err != nil {
+	defer f.Close()
+
+	if filepath.Ext(path) == ".json" {
+		err = modules.WriteSymbolsJSON(f, syms)
+	} else {
+		err = modules.WriteSymbols(f, syms)
+	}
+	if err != nil {
 		return err
 	}
 
+	return f.Close()
+}
+
+func writeListing(path string, l *modules.Listing) error {
+	f, err := createDestFile(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = l.WriteTo(f); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// stdio is the path meaning stdin as a source and stdout as a destination.
+const stdio = "-"
+
+// stdout is stdout as a destination file. Closing it leaves stdout open for
+// other outputs.
+type stdout struct {
+	io.Writer
+}
+
+func (stdout) Close() error {
 	return nil
 }
 
-func createDestFile(dest string) (*os.File, error) {
-	var w *os.File
+func openSource(path string) (io.ReadCloser, error) {
+	if path == stdio {
+		return io.NopCloser(os.Stdin), nil
+	}
+
+	return os.Open(path)
+}
+
+func createDestFile(dest string) (io.WriteCloser, error) {
+	if dest == stdio {
+		return stdout{os.Stdout}, nil
+	}
+
 	w, err := os.Create(dest)
 	if err != nil {
 		return nil, err
@@ -162,7 +288,17 @@ func createDestFile(dest string) (*os.File, error) {
 	return w, nil
 }
 
-func makeSameFileName(path string) string {
+// sameDest returns the file next to path named after it with ext. Sources
+// read from stdin go to stdout.
+func sameDest(path, ext string) string {
+	if path == stdio {
+		return stdio
+	}
+
+	return filepath.Join(filepath.Dir(path), makeSameFileName(path, ext))
+}
+
+func makeSameFileName(path, ext string) string {
 	fn := filepath.Base(path)
-	return fmt.Sprintf("%s%s", strings.Split(fn, ".")[0], assembledExt)
+	return fmt.Sprintf("%s%s", strings.Split(fn, ".")[0], ext)
 }
@@ -0,0 +1,228 @@
+/*
+Copyright © 2021 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/terashin777/assembler/cpu"
+)
+
+var (
+	cycles     uint64
+	pokes      []string
+	peeks      []string
+	keys       string
+	snapshots  []uint
+	snapFormat string
+	snapDir    string
+	goldenDir  string
+)
+
+// runCmd represents the run command
+var runCmd = &cobra.Command{
+	Use:   "run [file]",
+	Short: "run your assembly or hack on the hack cpu",
+	Long: `run your assembly or hack on the hack cpu.
+It runs until the program reaches its end loop or the cycle limit, then prints the registers and RAM.
+With --keys or --snapshot it presses keys from the key script and saves the screen at the given cycles,
+named like Fill.1000.png, and runs until the last snapshot.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := run(args[0])
+		if err != nil {
+			fmt.Printf("run is failed because: %s\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+
+	runCmd.Flags().Uint64VarP(&cycles, "cycles", "c", 1000000, "max number of instructions to execute (0 is no limit)")
+	runCmd.Flags().StringSliceVarP(&pokes, "poke", "p", nil, "set RAM before running, as addr=value")
+	runCmd.Flags().StringSliceVarP(&peeks, "peek", "r", []string{"0-15"}, "RAM to print after running, as addr or from-to")
+	runCmd.Flags().StringVarP(&keys, "keys", "k", "", "key script with a key and the cycle to press it at on each line")
+	runCmd.Flags().UintSliceVarP(&snapshots, "snapshot", "s", nil, "cycles to save the screen at, the program runs until the last one")
+	runCmd.Flags().StringVar(&snapFormat, "snapshot-format", cpu.FORMAT_PNG, fmt.Sprintf("image format of snapshots (%s, %s)", cpu.FORMAT_PNG, cpu.FORMAT_PPM))
+	runCmd.Flags().StringVar(&snapDir, "snapshot-dir", defaultDestDir, "directory to save snapshots to")
+	runCmd.Flags().StringVar(&goldenDir, "golden", "", "directory of golden images to compare the snapshots with")
+}
+
+func run(path string) error {
+	c := cpu.NewCPU()
+	err := c.LoadFile(path)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range pokes {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid poke %q", p)
+		}
+		a, err := parseAddress(kv[0])
+		if err != nil {
+			return err
+		}
+		v, err := strconv.ParseInt(kv[1], 10, 17)
+		if err != nil {
+			return fmt.Errorf("invalid poke %q", p)
+		}
+		c.Poke(a, uint16(v))
+	}
+
+	if keys != "" || len(snapshots) > 0 {
+		err = runScreen(c, path)
+	} else {
+		err = c.Run(cycles)
+	}
+	if err != nil && err != cpu.ErrCycleLimit {
+		return err
+	}
+	if err == cpu.ErrCycleLimit {
+		fmt.Fprintf(os.Stderr, "stopped after %d cycles: %s\n", c.Cycles, err)
+	}
+
+	fmt.Printf("PC=%d A=%d D=%d cycles=%d\n", c.PC, int16(c.A), int16(c.D), c.Cycles)
+	for _, p := range peeks {
+		from, to, err := parseAddressRange(p)
+		if err != nil {
+			return err
+		}
+		for a := from; a <= to; a++ {
+			fmt.Printf("RAM[%d]=%d\n", a, int16(c.Peek(a)))
+		}
+	}
+
+	return nil
+}
+
+// runScreen runs the program with the key script and saves the screen at
+// the snapshot cycles. Without snapshots it runs until the program halts or
+// for the cycle limit, as without keys.
+func runScreen(c *cpu.CPU, path string) error {
+	es := []cpu.KeyEvent{}
+	if keys != "" {
+		f, err := os.Open(keys)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if es, err = cpu.ReadKeys(f); err != nil {
+			return fmt.Errorf("%s: %w", keys, err)
+		}
+	}
+
+	at := []uint64{}
+	for _, s := range snapshots {
+		at = append(at, uint64(s))
+	}
+	if len(at) == 0 {
+		return c.RunWithKeys(es, cycles)
+	}
+
+	dir := snapDir
+	if dir == defaultDestDir {
+		dir = filepath.Dir(path)
+	}
+	diffs := 0
+	err := c.RunKeys(es, at, func(cycle uint64) error {
+		name := fmt.Sprintf("%s.%d.%s", strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), cycle, snapFormat)
+		img := c.Screen()
+		if err := writeImage(filepath.Join(dir, name), img); err != nil {
+			return err
+		}
+		if goldenDir == "" {
+			return nil
+		}
+
+		golden, err := readImage(filepath.Join(goldenDir, name))
+		if err != nil {
+			return err
+		}
+		if n := cpu.DiffImages(golden, img); n > 0 {
+			fmt.Fprintf(os.Stderr, "%s: %d pixels differ from the golden image\n", name, n)
+			diffs++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if diffs > 0 {
+		return fmt.Errorf("%d snapshots differ from the golden images", diffs)
+	}
+
+	return nil
+}
+
+func writeImage(path string, img image.Image) error {
+	f, err := createDestFile(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err = cpu.EncodeImage(f, img, snapFormat); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+func readImage(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return cpu.DecodeImage(f, snapFormat)
+}
+
+func parseAddress(s string) (uint16, error) {
+	a, err := strconv.ParseUint(strings.TrimSpace(s), 10, 16)
+	if err != nil || a >= cpu.RAMSize {
+		return 0, fmt.Errorf("invalid address %q", s)
+	}
+
+	return uint16(a), nil
+}
+
+func parseAddressRange(s string) (uint16, uint16, error) {
+	ft := strings.SplitN(s, "-", 2)
+	from, err := parseAddress(ft[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(ft) == 1 {
+		return from, from, nil
+	}
+
+	to, err := parseAddress(ft[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	return from, to, nil
+}
@@ -0,0 +1,56 @@
+/*
+Copyright © 2021 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/terashin777/assembler/tst"
+)
+
+// testCmd represents the test command
+var testCmd = &cobra.Command{
+	Use:   "test [file]",
+	Short: "run a cpu test script and compare its output",
+	Long: `run a cpu test script (.tst) and compare its output.
+The output file is written like the CPU emulator does and compared line by line with the compare-to file.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		res, err := tst.Run(args[0])
+		var ce *tst.CompareError
+		if errors.As(err, &ce) {
+			fmt.Fprintf(os.Stderr, "%s:%d: comparison failure\nexpected: %s\nactual:   %s\n", ce.File, ce.Line, ce.Expected, ce.Actual)
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Printf("test is failed because: %s\n", err)
+			os.Exit(1)
+		}
+
+		if res.Compared {
+			fmt.Println("End of script - Comparison ended successfully")
+			return
+		}
+		fmt.Println("End of script")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(testCmd)
+}
@@ -0,0 +1,323 @@
+package cpu
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/terashin777/assembler/modules"
+	"github.com/terashin777/assembler/table"
+)
+
+const (
+	ROMSize = 32768
+	RAMSize = 32768
+)
+
+var (
+	ScreenAddr = table.DefinedSymbolTable["SCREEN"]
+	KBDAddr    = table.DefinedSymbolTable["KBD"]
+
+	ErrCycleLimit = errors.New("cycle limit exceeded")
+)
+
+// CPU is the Hack computer: a CPU with its instruction and data memory.
+type CPU struct {
+	A      uint16
+	D      uint16
+	PC     uint16
+	Cycles uint64
+
+	rom  [ROMSize]uint16
+	ram  [RAMSize]uint16
+	size int
+
+	// loop is the PC, A and D of the last unconditional jump taken and dirty
+	// tells whether RAM changed after it. Taking the same jump again to the
+	// same A with the same D and clean RAM means the program spins forever.
+	loop   [3]uint16
+	dirty  bool
+	halted bool
+}
+
+func NewCPU() *CPU {
+	return &CPU{}
+}
+
+// Load puts the program into ROM and resets the CPU. RAM is kept as is.
+func (c *CPU) Load(words []uint16) error {
+	if len(words) > ROMSize {
+		return fmt.Errorf("program has %d words but ROM has only %d", len(words), ROMSize)
+	}
+
+	c.rom = [ROMSize]uint16{}
+	copy(c.rom[:], words)
+	c.size = len(words)
+	c.Reset()
+	return nil
+}
+
+// LoadHack loads a program written in the .hack format.
+func (c *CPU) LoadHack(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	ws := []uint16{}
+	no := 0
+	for s.Scan() {
+		no++
+		t := strings.TrimSpace(s.Text())
+		if t == "" {
+			continue
+		}
+
+		w, err := strconv.ParseUint(t, 2, 16)
+		if err != nil || len(t) != 16 {
+			return fmt.Errorf("line %d: invalid word %q", no, t)
+		}
+		ws = append(ws, uint16(w))
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	return c.Load(ws)
+}
+
+// LoadFile loads a .hack file as is and assembles an .asm file.
+func (c *CPU) LoadFile(path string) error {
+	ext := filepath.Ext(path)
+	if ext != ".hack" && ext != ".asm" {
+		return fmt.Errorf("%s is not a program", path)
+	}
+
+	r, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if ext == ".hack" {
+		return c.LoadHack(r)
+	}
+
+	p, err := modules.NewParser(path, r)
+	if err != nil {
+		return err
+	}
+	return c.LoadParser(p)
+}
+
+// LoadParser assembles the program with p and loads it.
+func (c *CPU) LoadParser(p *modules.Parser) error {
+	ws := []uint16{}
+	errs := modules.ErrorList{}
+	for {
+		s, err := p.Read()
+		if err == io.EOF {
+			break
+		}
+		var e *modules.Error
+		if errors.As(err, &e) {
+			errs = append(errs, e)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		w, err := strconv.ParseUint(s, 2, 16)
+		if err != nil {
+			return err
+		}
+		ws = append(ws, uint16(w))
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return c.Load(ws)
+}
+
+// Reset sets the registers to zero as the reset bit of the Hack CPU does.
+func (c *CPU) Reset() {
+	c.A = 0
+	c.D = 0
+	c.PC = 0
+	c.Cycles = 0
+	c.loop = [3]uint16{}
+	c.dirty = true
+	c.halted = false
+}
+
+// Size returns the number of words of the loaded program.
+func (c *CPU) Size() int {
+	return c.size
+}
+
+func (c *CPU) ROM(addr uint16) uint16 {
+	return c.rom[addr%ROMSize]
+}
+
+func (c *CPU) Peek(addr uint16) uint16 {
+	return c.ram[addr%RAMSize]
+}
+
+func (c *CPU) Poke(addr, v uint16) {
+	if c.ram[addr%RAMSize] != v {
+		c.dirty = true
+	}
+	c.ram[addr%RAMSize] = v
+}
+
+// SetKey sets the key code the keyboard register shows. 0 means no key. A
+// program waiting for a key in a loop is no longer halted when it changes.
+func (c *CPU) SetKey(k uint16) {
+	if c.Peek(KBDAddr) != k {
+		c.halted = false
+	}
+	c.Poke(KBDAddr, k)
+}
+
+// Step executes one instruction.
+func (c *CPU) Step() {
+	w := c.rom[c.PC%ROMSize]
+	c.Cycles++
+	if w&(1<<15) == 0 {
+		c.A = w
+		c.PC++
+		return
+	}
+
+	y := c.A
+	if w&(1<<12) != 0 {
+		y = c.Peek(c.A)
+	}
+	var out uint16
+	if w>>13 == 0b101 {
+		out = shift(c.D, y, byte(w>>6&0b111111))
+	} else {
+		out = alu(c.D, y, byte(w>>6&0b111111))
+	}
+
+	addr := c.A
+	if w&(1<<5) != 0 {
+		c.A = out
+	}
+	if w&(1<<4) != 0 {
+		c.D = out
+	}
+	if w&(1<<3) != 0 && addr != KBDAddr {
+		c.Poke(addr, out)
+	}
+
+	if jump(out, byte(w&0b111)) {
+		if w&0b111111 == 0b000111 {
+			c.detectLoop()
+		}
+		c.PC = addr
+		return
+	}
+	c.PC++
+}
+
+func (c *CPU) detectLoop() {
+	l := [3]uint16{c.PC, c.A, c.D}
+	if !c.dirty && l == c.loop {
+		c.halted = true
+		return
+	}
+
+	c.loop = l
+	c.dirty = false
+}
+
+// alu computes the Hack ALU with the control bits zx nx zy ny f no.
+func alu(x, y uint16, ctl byte) uint16 {
+	if ctl&0b100000 != 0 {
+		x = 0
+	}
+	if ctl&0b010000 != 0 {
+		x = ^x
+	}
+	if ctl&0b001000 != 0 {
+		y = 0
+	}
+	if ctl&0b000100 != 0 {
+		y = ^y
+	}
+
+	var out uint16
+	if ctl&0b000010 != 0 {
+		out = x + y
+	} else {
+		out = x & y
+	}
+	if ctl&0b000001 != 0 {
+		out = ^out
+	}
+
+	return out
+}
+
+// shift computes the shift instructions of the extended ALU, which start
+// with 101. The first control bit selects a left shift and the second one
+// shifts D instead of A or M. Right shifts keep the sign.
+func shift(x, y uint16, ctl byte) uint16 {
+	if ctl&0b010000 != 0 {
+		y = x
+	}
+	if ctl&0b100000 != 0 {
+		return y << 1
+	}
+
+	return uint16(int16(y) >> 1)
+}
+
+func jump(out uint16, j byte) bool {
+	v := int16(out)
+	return j&0b100 != 0 && v < 0 ||
+		j&0b010 != 0 && v == 0 ||
+		j&0b001 != 0 && v > 0
+}
+
+// NextWrite returns the RAM address the next instruction writes to. ok is
+// false when it writes nothing.
+func (c *CPU) NextWrite() (addr uint16, ok bool) {
+	w := c.ROM(c.PC)
+	if w&(1<<15) == 0 || w&(1<<3) == 0 || c.A == KBDAddr {
+		return 0, false
+	}
+
+	return c.A, true
+}
+
+// Halted reports whether the CPU is past the end of the program or spins in
+// an end loop like "(END) @END 0;JMP" that can no longer change anything.
+func (c *CPU) Halted() bool {
+	return int(c.PC) >= c.size || c.halted
+}
+
+// RunUntil steps until done returns true or the program halts. It returns
+// ErrCycleLimit when limit instructions are executed before that. A limit of
+// 0 means no limit.
+func (c *CPU) RunUntil(done func(c *CPU) bool, limit uint64) error {
+	for n := uint64(0); ; n++ {
+		if done != nil && done(c) || c.Halted() {
+			return nil
+		}
+		if limit != 0 && n >= limit {
+			return ErrCycleLimit
+		}
+
+		c.Step()
+	}
+}
+
+// Run steps until the program halts.
+func (c *CPU) Run(limit uint64) error {
+	return c.RunUntil(nil, limit)
+}
@@ -0,0 +1,223 @@
+package cpu
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/terashin777/assembler/modules"
+	"github.com/terashin777/assembler/table"
+)
+
+func TestMax(t *testing.T) {
+	tests := []struct{ a, b, want int16 }{
+		{3, 5, 5},
+		{7, -2, 7},
+		{-4, -9, -4},
+		{0, 0, 0},
+	}
+	for _, tt := range tests {
+		c := NewCPU()
+		if err := c.LoadFile("../../projects/06/max/Max.asm"); err != nil {
+			t.Fatal(err)
+		}
+		c.Poke(0, uint16(tt.a))
+		c.Poke(1, uint16(tt.b))
+		if err := c.Run(1000); err != nil {
+			t.Fatalf("max(%d, %d): %v", tt.a, tt.b, err)
+		}
+		if !c.Halted() {
+			t.Errorf("max(%d, %d) does not halt", tt.a, tt.b)
+		}
+		if got := int16(c.Peek(2)); got != tt.want {
+			t.Errorf("max(%d, %d) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestALU(t *testing.T) {
+	comps := map[string]func(x, y uint16) uint16{
+		"0":   func(x, y uint16) uint16 { return 0 },
+		"1":   func(x, y uint16) uint16 { return 1 },
+		"-1":  func(x, y uint16) uint16 { return 0xFFFF },
+		"D":   func(x, y uint16) uint16 { return x },
+		"A":   func(x, y uint16) uint16 { return y },
+		"!D":  func(x, y uint16) uint16 { return ^x },
+		"!A":  func(x, y uint16) uint16 { return ^y },
+		"-D":  func(x, y uint16) uint16 { return -x },
+		"-A":  func(x, y uint16) uint16 { return -y },
+		"D+1": func(x, y uint16) uint16 { return x + 1 },
+		"A+1": func(x, y uint16) uint16 { return y + 1 },
+		"D-1": func(x, y uint16) uint16 { return x - 1 },
+		"A-1": func(x, y uint16) uint16 { return y - 1 },
+		"D+A": func(x, y uint16) uint16 { return x + y },
+		"D-A": func(x, y uint16) uint16 { return x - y },
+		"A-D": func(x, y uint16) uint16 { return y - x },
+		"D&A": func(x, y uint16) uint16 { return x & y },
+		"D|A": func(x, y uint16) uint16 { return x | y },
+	}
+	const d, a, m = 0x9234, 0x70F3, 0x8F0F
+	for comp, f := range comps {
+		for _, useM := range []bool{false, true} {
+			src, want := "D="+comp, f(d, a)
+			if useM {
+				if !strings.Contains(comp, "A") {
+					continue
+				}
+				src, want = "D="+strings.ReplaceAll(comp, "A", "M"), f(d, m)
+			}
+			c := load(t, src)
+			c.D, c.A = d, a
+			c.Poke(a, m)
+			c.Step()
+			if c.D != want {
+				t.Errorf("%s: D = %#04x, want %#04x", src, c.D, want)
+			}
+		}
+	}
+}
+
+func TestJumps(t *testing.T) {
+	jumps := map[string][3]bool{
+		"JGT": {false, false, true},
+		"JEQ": {false, true, false},
+		"JGE": {false, true, true},
+		"JLT": {true, false, false},
+		"JNE": {true, false, true},
+		"JLE": {true, true, false},
+		"JMP": {true, true, true},
+	}
+	for j, want := range jumps {
+		for i, d := range []int16{-3, 0, 5} {
+			c := load(t, "@100\nD;"+j)
+			c.D = uint16(d)
+			c.Step()
+			c.Step()
+			if got := c.PC == 100; got != want[i] {
+				t.Errorf("D=%d D;%s jumps %v, want %v", d, j, got, want[i])
+			}
+		}
+	}
+}
+
+func TestHalt(t *testing.T) {
+	c := load(t, "@i\nM=1\n(END)\n@END\n0;JMP\n")
+	if err := c.Run(100); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Halted() || c.Cycles > 10 {
+		t.Errorf("end loop: halted=%v after %d cycles", c.Halted(), c.Cycles)
+	}
+
+	c = load(t, "(LOOP)\n@i\nM=M+1\n@LOOP\n0;JMP\n")
+	if err := c.Run(100); err != ErrCycleLimit {
+		t.Errorf("a loop changing RAM: err = %v, want %v", err, ErrCycleLimit)
+	}
+
+	// The jump at J is taken twice with the same D and clean RAM, but to
+	// another A the second time.
+	c = load(t, `@FIRST
+D=A
+(J)
+A=D
+D=0
+0;JMP
+(FIRST)
+@SECOND
+D=A
+@J
+D;JNE
+(SECOND)
+@42
+D=A
+@R5
+M=D
+(END)
+@END
+0;JMP
+`)
+	if err := c.Run(100); err != nil || c.Peek(5) != 42 {
+		t.Errorf("a computed jump: err=%v RAM[5]=%d, want 42", err, c.Peek(5))
+	}
+
+	c = load(t, "@1\nD=A\n")
+	if err := c.Run(0); err != nil || !c.Halted() || c.PC != 2 {
+		t.Errorf("running off the end: err=%v halted=%v PC=%d", err, c.Halted(), c.PC)
+	}
+}
+
+func TestKeyboardIsReadOnly(t *testing.T) {
+	c := load(t, "@KBD\nM=1\nD=M\n")
+	c.SetKey('K')
+	if err := c.Run(0); err != nil {
+		t.Fatal(err)
+	}
+	if c.D != 'K' {
+		t.Errorf("D = %d, want the key %d", c.D, 'K')
+	}
+}
+
+func TestLoadHack(t *testing.T) {
+	c := NewCPU()
+	if err := c.LoadHack(strings.NewReader("0000000000000111\n\n1110110000010000\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Run(0); err != nil || c.D != 7 || c.Size() != 2 {
+		t.Errorf("err=%v D=%d size=%d", err, c.D, c.Size())
+	}
+
+	for _, src := range []string{"0101\n", "000000000000000x\n"} {
+		if err := NewCPU().LoadHack(strings.NewReader(src)); err == nil {
+			t.Errorf("%q is loaded", src)
+		}
+	}
+	if err := NewCPU().LoadFile("Prog.txt"); err == nil {
+		t.Error("a file that is not a program is loaded")
+	}
+}
+
+func TestReset(t *testing.T) {
+	c := load(t, "@5\nD=A\n@R0\nM=D\n")
+	if err := c.Run(0); err != nil {
+		t.Fatal(err)
+	}
+	c.Reset()
+	if c.PC != 0 || c.A != 0 || c.D != 0 || c.Cycles != 0 || c.Halted() {
+		t.Errorf("PC=%d A=%d D=%d cycles=%d halted=%v after reset", c.PC, c.A, c.D, c.Cycles, c.Halted())
+	}
+	if c.Peek(0) != 5 {
+		t.Errorf("RAM[0] = %d, reset must keep RAM", c.Peek(0))
+	}
+}
+
+func TestShifts(t *testing.T) {
+	tests := []struct {
+		src  string
+		want uint16
+	}{
+		{"D=D<<", 0x2468},
+		{"D=D>>", 0xC91A},
+		{"D=A<<", 0x0006},
+		{"D=A>>", 0x0001},
+		{"D=M<<", 0xFFFE},
+		{"D=M>>", 0xFFFF},
+	}
+	for _, tt := range tests {
+		p, err := modules.NewParser("t.asm", strings.NewReader(tt.src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.SetISA(table.ISA_EXTENDED); err != nil {
+			t.Fatal(err)
+		}
+		c := NewCPU()
+		if err := c.LoadParser(p); err != nil {
+			t.Fatal(err)
+		}
+		c.D, c.A = 0x9234, 3
+		c.Poke(3, 0xFFFF)
+		c.Step()
+		if c.D != tt.want {
+			t.Errorf("%s: D = %#04x, want %#04x", tt.src, c.D, tt.want)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package cpu
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The screen is a 512x256 black and white framebuffer mapped to RAM from
+// ScreenAddr. Each row is 32 words and the least significant bit of a word
+// is its leftmost pixel. A set bit is black.
+const (
+	ScreenWidth  = 512
+	ScreenHeight = 256
+)
+
+// Image formats of screen snapshots.
+const (
+	FORMAT_PNG = "png"
+	FORMAT_PPM = "ppm"
+)
+
+// keyNames are the names of the special keys in key scripts with their Hack
+// key codes.
+var keyNames = map[string]uint16{
+	"none":      0,
+	"space":     32,
+	"newline":   128,
+	"backspace": 129,
+	"left":      130,
+	"up":        131,
+	"right":     132,
+	"down":      133,
+	"home":      134,
+	"end":       135,
+	"pageup":    136,
+	"pagedown":  137,
+	"insert":    138,
+	"delete":    139,
+	"esc":       140,
+}
+
+func init() {
+	for i := 1; i <= 12; i++ {
+		keyNames[fmt.Sprintf("f%d", i)] = uint16(140 + i)
+	}
+}
+
+// KeyEvent sets the key the keyboard shows once Cycle instructions are
+// executed. Key 0 releases the key.
+type KeyEvent struct {
+	Key   uint16
+	Cycle uint64
+}
+
+// Screen returns the framebuffer as an image.
+func (c *CPU) Screen() *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, ScreenWidth, ScreenHeight))
+	for y := 0; y < ScreenHeight; y++ {
+		for x := 0; x < ScreenWidth; x++ {
+			w := c.Peek(ScreenAddr + uint16(y*ScreenWidth/16+x/16))
+			v := uint8(255)
+			if w&(1<<(x%16)) != 0 {
+				v = 0
+			}
+			img.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+
+	return img
+}
+
+// RunKeys runs the program pressing the keys of events on time and calls
+// snap after each cycle in at. It runs until the last cycle in at, so
+// programs polling the keyboard forever stop there.
+func (c *CPU) RunKeys(events []KeyEvent, at []uint64, snap func(cycle uint64) error) error {
+	events = sortKeys(events)
+	at = append([]uint64{}, at...)
+	sort.Slice(at, func(i, j int) bool { return at[i] < at[j] })
+
+	for _, a := range at {
+		for c.Cycles < a {
+			if len(events) > 0 && events[0].Cycle <= c.Cycles {
+				c.SetKey(events[0].Key)
+				events = events[1:]
+				continue
+			}
+			c.Step()
+		}
+		if err := snap(a); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RunWithKeys runs the program pressing the keys of events on time until it
+// halts. It returns ErrCycleLimit as Run does and a limit of 0 means no
+// limit.
+func (c *CPU) RunWithKeys(events []KeyEvent, limit uint64) error {
+	events = sortKeys(events)
+	return c.RunUntil(func(c *CPU) bool {
+		for len(events) > 0 && events[0].Cycle <= c.Cycles {
+			c.SetKey(events[0].Key)
+			events = events[1:]
+		}
+		return false
+	}, limit)
+}
+
+func sortKeys(events []KeyEvent) []KeyEvent {
+	events = append([]KeyEvent{}, events...)
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Cycle < events[j].Cycle })
+
+	return events
+}
+
+// ReadKeys reads a key script. Each line is a key and the cycle to press it
+// at. Keys are characters like a or 5, names like left, space or f1, or
+// codes like 130. Text after # is a comment.
+func ReadKeys(r io.Reader) ([]KeyEvent, error) {
+	es := []KeyEvent{}
+	s := bufio.NewScanner(r)
+	no := 0
+	for s.Scan() {
+		no++
+		l := s.Text()
+		if i := strings.IndexByte(l, '#'); i != -1 {
+			l = l[:i]
+		}
+		fs := strings.Fields(l)
+		if len(fs) == 0 {
+			continue
+		}
+		if len(fs) != 2 {
+			return nil, fmt.Errorf("line %d: usage is KEY CYCLE", no)
+		}
+
+		k, err := parseKey(fs[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", no, err)
+		}
+		cy, err := strconv.ParseUint(fs[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid cycle %s", no, fs[1])
+		}
+		es = append(es, KeyEvent{Key: k, Cycle: cy})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return es, nil
+}
+
+func parseKey(s string) (uint16, error) {
+	if k, ok := keyNames[strings.ToLower(s)]; ok {
+		return k, nil
+	}
+	if len(s) == 1 {
+		return uint16(strings.ToUpper(s)[0]), nil
+	}
+	if k, err := strconv.ParseUint(s, 10, 16); err == nil {
+		return uint16(k), nil
+	}
+
+	return 0, fmt.Errorf("invalid key %s", s)
+}
+
+// EncodeImage writes img in format, one of FORMAT_PNG and FORMAT_PPM.
+func EncodeImage(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case FORMAT_PNG:
+		return png.Encode(w, img)
+	case FORMAT_PPM:
+		b := img.Bounds()
+		bw := bufio.NewWriter(w)
+		fmt.Fprintf(bw, "P6\n%d %d\n255\n", b.Dx(), b.Dy())
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				v := color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+				bw.Write([]byte{v, v, v})
+			}
+		}
+		return bw.Flush()
+	default:
+		return fmt.Errorf("unknown image format %s", format)
+	}
+}
+
+// DecodeImage reads an image written by EncodeImage in format.
+func DecodeImage(r io.Reader, format string) (image.Image, error) {
+	switch format {
+	case FORMAT_PNG:
+		return png.Decode(r)
+	case FORMAT_PPM:
+		br := bufio.NewReader(r)
+		var w, h, maxval int
+		if _, err := fmt.Fscanf(br, "P6\n%d %d\n%d\n", &w, &h, &maxval); err != nil {
+			return nil, fmt.Errorf("invalid ppm: %w", err)
+		}
+		img := image.NewRGBA(image.Rect(0, 0, w, h))
+		px := make([]byte, 3)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				if _, err := io.ReadFull(br, px); err != nil {
+					return nil, fmt.Errorf("invalid ppm: %w", err)
+				}
+				img.Set(x, y, color.RGBA{R: px[0], G: px[1], B: px[2], A: 255})
+			}
+		}
+		return img, nil
+	default:
+		return nil, fmt.Errorf("unknown image format %s", format)
+	}
+}
+
+// DiffImages returns the number of pixels that differ between a and b
+// compared in gray. Images of different sizes differ everywhere.
+func DiffImages(a, b image.Image) int {
+	ab, bb := a.Bounds(), b.Bounds()
+	if ab.Size() != bb.Size() {
+		return ab.Dx() * ab.Dy()
+	}
+
+	n := 0
+	for y := 0; y < ab.Dy(); y++ {
+		for x := 0; x < ab.Dx(); x++ {
+			ag := color.GrayModel.Convert(a.At(ab.Min.X+x, ab.Min.Y+y)).(color.Gray)
+			bg := color.GrayModel.Convert(b.At(bb.Min.X+x, bb.Min.Y+y)).(color.Gray)
+			if ag != bg {
+				n++
+			}
+		}
+	}
+
+	return n
+}
@@ -0,0 +1,129 @@
+package cpu
+
+import (
+	"bytes"
+	"image"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/terashin777/assembler/modules"
+)
+
+func load(t *testing.T, src string) *CPU {
+	t.Helper()
+	p, err := modules.NewParser("t.asm", strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCPU()
+	if err := c.LoadParser(p); err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+// waitKey stores the first key pressed in R0 and halts.
+const waitKey = "(L)\n@KBD\nD=M\n@L\nD;JEQ\n@R0\nM=D\n"
+
+func TestRunWithKeysWithoutLimit(t *testing.T) {
+	c := load(t, waitKey)
+	if err := c.RunWithKeys([]KeyEvent{{Key: 'a', Cycle: 5000}}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Halted() || c.Peek(0) != 'a' || c.Cycles < 5000 {
+		t.Errorf("halted=%v R0=%d cycles=%d", c.Halted(), c.Peek(0), c.Cycles)
+	}
+}
+
+func TestRunWithKeysStopsAtLimit(t *testing.T) {
+	c := load(t, waitKey)
+	if err := c.RunWithKeys([]KeyEvent{{Key: 'a', Cycle: 5000}}, 100); err != ErrCycleLimit {
+		t.Fatalf("err = %v, want %v", err, ErrCycleLimit)
+	}
+	if c.Cycles != 100 || c.Peek(0) != 0 {
+		t.Errorf("R0=%d cycles=%d", c.Peek(0), c.Cycles)
+	}
+}
+
+func TestRunKeysSnapsAtCycles(t *testing.T) {
+	c := load(t, "(L)\n@KBD\nD=M\n@SCREEN\nM=D\n@L\n0;JMP\n")
+	es := []KeyEvent{{Key: 0, Cycle: 100}, {Key: 'x', Cycle: 10}}
+	got := []uint16{}
+	err := c.RunKeys(es, []uint64{150, 50}, func(cycle uint64) error {
+		if cycle != c.Cycles {
+			t.Errorf("snap at %d after %d cycles", cycle, c.Cycles)
+		}
+		got = append(got, c.Peek(ScreenAddr))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint16{'x', 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("screen at snapshots %v, want %v", got, want)
+	}
+}
+
+func TestReadKeys(t *testing.T) {
+	es, err := ReadKeys(strings.NewReader("# keys\na 10\nleft 20 # arrow\n\nf1 30\n130 40\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []KeyEvent{{'A', 10}, {130, 20}, {141, 30}, {130, 40}}
+	if !reflect.DeepEqual(es, want) {
+		t.Errorf("got %v, want %v", es, want)
+	}
+	if _, err := ReadKeys(strings.NewReader("a\n")); err == nil {
+		t.Error("a key without a cycle is accepted")
+	}
+}
+
+func TestScreen(t *testing.T) {
+	c := load(t, "@SCREEN\nM=1\n@SCREEN\nD=A\n@33\nA=D+A\nM=-1\n@KBD\nA=A-1\nM=1\n")
+	if err := c.Run(0); err != nil {
+		t.Fatal(err)
+	}
+	img := c.Screen()
+	black := map[[2]int]bool{{0, 0}: true, {511, 255}: false}
+	for x := 16; x < 32; x++ {
+		black[[2]int{x, 1}] = true
+	}
+	black[[2]int{496, 255}] = true
+	for p, want := range black {
+		if got := img.GrayAt(p[0], p[1]).Y == 0; got != want {
+			t.Errorf("pixel %v black %v, want %v", p, got, want)
+		}
+	}
+	if n := DiffImages(img, NewCPU().Screen()); n != 18 {
+		t.Errorf("%d pixels differ from a blank screen, want 18", n)
+	}
+}
+
+func TestEncodeImage(t *testing.T) {
+	c := load(t, "@SCREEN\nM=-1\n")
+	if err := c.Run(0); err != nil {
+		t.Fatal(err)
+	}
+	img := c.Screen()
+	for _, f := range []string{FORMAT_PNG, FORMAT_PPM} {
+		b := &bytes.Buffer{}
+		if err := EncodeImage(b, img, f); err != nil {
+			t.Fatal(err)
+		}
+		got, err := DecodeImage(b, f)
+		if err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+		if n := DiffImages(img, got); n != 0 {
+			t.Errorf("%s: %d pixels changed", f, n)
+		}
+	}
+	if err := EncodeImage(&bytes.Buffer{}, img, "gif"); err == nil {
+		t.Error("an unknown format is written")
+	}
+	if n := DiffImages(img, image.NewGray(image.Rect(0, 0, 10, 10))); n != ScreenWidth*ScreenHeight {
+		t.Errorf("images of different sizes differ in %d pixels", n)
+	}
+}
@@ -0,0 +1,402 @@
+package debug
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/terashin777/assembler/cpu"
+	"github.com/terashin777/assembler/modules"
+	"github.com/terashin777/assembler/table"
+)
+
+// Prompt is printed before each command.
+const Prompt = "(hdb) "
+
+// pointers are the registers whose RAM windows are shown at each stop.
+var pointers = []string{"SP", "LCL", "ARG"}
+
+// Debugger runs a program on the Hack CPU under the control of commands read
+// from a terminal. Programs assembled from .asm know their labels, variables
+// and source lines.
+type Debugger struct {
+	c       *cpu.CPU
+	syms    map[string]*modules.Symbol
+	labels  map[uint16]string
+	src     []modules.SourcePos
+	bps     map[uint16]struct{}
+	watches map[uint16]struct{}
+	window  int
+	limit   uint64
+	w       io.Writer
+}
+
+// NewDebugger loads the .asm or .hack file at path.
+func NewDebugger(path string) (*Debugger, error) {
+	d := &Debugger{
+		c:       cpu.NewCPU(),
+		syms:    map[string]*modules.Symbol{},
+		labels:  map[uint16]string{},
+		src:     []modules.SourcePos{},
+		bps:     map[uint16]struct{}{},
+		watches: map[uint16]struct{}{},
+		window:  2,
+		limit:   1000000,
+		w:       io.Discard,
+	}
+
+	r, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	switch filepath.Ext(path) {
+	case ".hack":
+		return d, d.c.LoadHack(r)
+	case ".asm":
+		res, err := modules.Assemble(r, nil, modules.Options{File: path})
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range res.Symbols {
+			d.syms[s.Name] = s
+			if s.Kind == modules.LABEL {
+				d.labels[s.Address] = s.Name
+			}
+		}
+		d.src = res.Sources
+		return d, d.c.Load(res.Words)
+	default:
+		return nil, fmt.Errorf("%s is not a program", path)
+	}
+}
+
+// SetWindow sets how many words are shown on each side of SP, LCL and ARG.
+func (d *Debugger) SetWindow(n int) {
+	d.window = n
+}
+
+// SetLimit sets how many instructions continue executes at most. 0 means no
+// limit.
+func (d *Debugger) SetLimit(n uint64) {
+	d.limit = n
+}
+
+// Run reads commands from r until quit or the end of r and writes what they
+// show to w. An empty line repeats the last command.
+func (d *Debugger) Run(r io.Reader, w io.Writer) error {
+	d.w = w
+	d.show()
+
+	s := bufio.NewScanner(r)
+	last := ""
+	for {
+		fmt.Fprint(w, Prompt)
+		if !s.Scan() {
+			fmt.Fprintln(w)
+			return s.Err()
+		}
+
+		l := strings.TrimSpace(s.Text())
+		if l == "" {
+			l = last
+		}
+		last = l
+		fs := strings.Fields(l)
+		if len(fs) == 0 {
+			continue
+		}
+		if fs[0] == "quit" || fs[0] == "q" {
+			return nil
+		}
+		if err := d.exec(fs[0], fs[1:]); err != nil {
+			fmt.Fprintf(w, "error: %s\n", err)
+		}
+	}
+}
+
+func (d *Debugger) exec(name string, args []string) error {
+	switch name {
+	case "break", "b":
+		if len(args) != 1 {
+			return fmt.Errorf("usage is break LABEL|ADDRESS")
+		}
+		a, err := d.romAddress(args[0])
+		if err != nil {
+			return err
+		}
+		d.bps[a] = struct{}{}
+		fmt.Fprintf(d.w, "breakpoint at %s\n", d.romName(a))
+	case "delete", "d":
+		if len(args) == 0 {
+			d.bps = map[uint16]struct{}{}
+			return nil
+		}
+		a, err := d.romAddress(args[0])
+		if err != nil {
+			return err
+		}
+		delete(d.bps, a)
+	case "watch", "w":
+		if len(args) != 1 {
+			return fmt.Errorf("usage is watch SYMBOL|ADDRESS")
+		}
+		a, err := d.ramAddress(args[0])
+		if err != nil {
+			return err
+		}
+		d.watches[a] = struct{}{}
+		fmt.Fprintf(d.w, "watching RAM[%d]\n", a)
+	case "unwatch":
+		if len(args) == 0 {
+			d.watches = map[uint16]struct{}{}
+			return nil
+		}
+		a, err := d.ramAddress(args[0])
+		if err != nil {
+			return err
+		}
+		delete(d.watches, a)
+	case "step", "s":
+		n := uint64(1)
+		if len(args) > 0 {
+			v, err := strconv.ParseUint(args[0], 10, 64)
+			if err != nil || v == 0 {
+				return fmt.Errorf("invalid count %s, it must be 1 or more", args[0])
+			}
+			n = v
+		}
+		d.resume(n, false)
+	case "continue", "c":
+		d.resume(d.limit, true)
+	case "print", "p":
+		d.show()
+	case "x":
+		if len(args) != 1 {
+			return fmt.Errorf("usage is x SYMBOL|ADDRESS|FROM-TO")
+		}
+		return d.examine(args[0])
+	case "set":
+		kv := []string{}
+		if len(args) == 1 {
+			kv = strings.SplitN(args[0], "=", 2)
+		}
+		if len(kv) != 2 {
+			return fmt.Errorf("usage is set SYMBOL|ADDRESS=VALUE")
+		}
+		a, err := d.ramAddress(kv[0])
+		if err != nil {
+			return err
+		}
+		v, err := strconv.ParseInt(kv[1], 10, 17)
+		if err != nil {
+			return fmt.Errorf("invalid value %s", kv[1])
+		}
+		d.c.Poke(a, uint16(v))
+	case "list", "l":
+		d.list()
+	case "info", "i":
+		d.info()
+	case "reset":
+		d.c.Reset()
+		d.show()
+	case "help", "h":
+		fmt.Fprint(d.w, help)
+	default:
+		return fmt.Errorf("unknown command %s, try help", name)
+	}
+
+	return nil
+}
+
+const help = `break LABEL|ADDRESS    stop before the instruction at the label or ROM address
+delete [LABEL|ADDRESS] remove a breakpoint, or all of them
+watch SYMBOL|ADDRESS   stop after an instruction writes the RAM address
+unwatch [SYMBOL|ADDRESS]
+step [N]               execute N instructions
+continue               execute until a breakpoint, a watch or the end
+print                  show the registers, the source line and RAM around SP, LCL and ARG
+x SYMBOL|ADDRESS|FROM-TO
+                       show RAM
+set SYMBOL|ADDRESS=VALUE
+                       change RAM
+list                   show the instructions around PC
+info                   show breakpoints and watches
+reset                  start the program again, keeping RAM
+quit
+`
+
+// resume executes at most n instructions and stops at breakpoints, writes to
+// watched addresses and the end of the program. A breakpoint at PC does not
+// stop the first instruction, so continue gets past it. n of 0 means no
+// limit. limited tells to say so when n is reached.
+func (d *Debugger) resume(n uint64, limited bool) {
+	defer d.show()
+
+	for i := uint64(0); n == 0 || i < n; i++ {
+		if d.c.Halted() {
+			fmt.Fprintln(d.w, "program halted")
+			return
+		}
+		if _, ok := d.bps[d.c.PC]; ok && i > 0 {
+			fmt.Fprintf(d.w, "breakpoint at %s\n", d.romName(d.c.PC))
+			return
+		}
+
+		a, writes := d.c.NextWrite()
+		old := d.c.Peek(a)
+		d.c.Step()
+		if _, ok := d.watches[a]; ok && writes {
+			fmt.Fprintf(d.w, "RAM[%d] %d -> %d\n", a, int16(old), int16(d.c.Peek(a)))
+			return
+		}
+	}
+	if limited {
+		fmt.Fprintf(d.w, "stopped after %d instructions\n", n)
+	}
+}
+
+func (d *Debugger) show() {
+	c := d.c
+	fmt.Fprintf(d.w, "PC=%d A=%d D=%d cycles=%d\n", c.PC, int16(c.A), int16(c.D), c.Cycles)
+	if int(c.PC) < len(d.src) {
+		fmt.Fprintf(d.w, "  %s\n", d.sourceLine(c.PC))
+	}
+	for _, n := range pointers {
+		p := c.Peek(table.DefinedSymbolTable[n])
+		fmt.Fprintf(d.w, "%-3s = %-5d", n, p)
+		for a := int(p) - d.window; a <= int(p)+d.window; a++ {
+			if a < 0 || a >= cpu.RAMSize {
+				continue
+			}
+			mark := " "
+			if a == int(p) {
+				mark = ">"
+			}
+			fmt.Fprintf(d.w, " %s[%d]=%d", mark, a, int16(c.Peek(uint16(a))))
+		}
+		fmt.Fprintln(d.w)
+	}
+}
+
+func (d *Debugger) sourceLine(a uint16) string {
+	s := d.src[a]
+	l := fmt.Sprintf("%s:%d: %s", s.File, s.Line, s.Text)
+	if n, ok := d.labels[a]; ok {
+		l = fmt.Sprintf("(%s) %s", n, l)
+	}
+
+	return l
+}
+
+func (d *Debugger) list() {
+	from := int(d.c.PC) - 3
+	if from < 0 {
+		from = 0
+	}
+	for a := from; a <= int(d.c.PC)+3 && a < d.c.Size(); a++ {
+		mark := " "
+		if a == int(d.c.PC) {
+			mark = ">"
+		}
+		if _, ok := d.bps[uint16(a)]; ok {
+			mark = "*" + mark
+		} else {
+			mark = " " + mark
+		}
+		text := fmt.Sprintf("%016b", d.c.ROM(uint16(a)))
+		if a < len(d.src) {
+			text = d.sourceLine(uint16(a))
+		}
+		fmt.Fprintf(d.w, "%s%5d  %s\n", mark, a, text)
+	}
+}
+
+func (d *Debugger) info() {
+	fmt.Fprintln(d.w, "breakpoints:")
+	for _, a := range sortedAddresses(d.bps) {
+		fmt.Fprintf(d.w, "  %s\n", d.romName(a))
+	}
+	fmt.Fprintln(d.w, "watches:")
+	for _, a := range sortedAddresses(d.watches) {
+		fmt.Fprintf(d.w, "  RAM[%d]=%d\n", a, int16(d.c.Peek(a)))
+	}
+}
+
+func (d *Debugger) examine(s string) error {
+	from, to := uint16(0), uint16(0)
+	if ft := strings.SplitN(s, "-", 2); len(ft) == 2 {
+		f, err := d.ramAddress(ft[0])
+		if err != nil {
+			return err
+		}
+		t, err := d.ramAddress(ft[1])
+		if err != nil {
+			return err
+		}
+		from, to = f, t
+	} else {
+		a, err := d.ramAddress(s)
+		if err != nil {
+			return err
+		}
+		from, to = a, a
+	}
+
+	for a := int(from); a <= int(to); a++ {
+		fmt.Fprintf(d.w, "RAM[%d]=%d\n", a, int16(d.c.Peek(uint16(a))))
+	}
+	return nil
+}
+
+// romAddress resolves a label or a number to a ROM address.
+func (d *Debugger) romAddress(s string) (uint16, error) {
+	if v, err := strconv.ParseUint(s, 10, 16); err == nil && v < cpu.ROMSize {
+		return uint16(v), nil
+	}
+	if sym, ok := d.syms[s]; ok && sym.Kind == modules.LABEL {
+		return sym.Address, nil
+	}
+
+	return 0, fmt.Errorf("unknown label %s", s)
+}
+
+// ramAddress resolves a variable, a constant, a predefined symbol or a
+// number to a RAM address.
+func (d *Debugger) ramAddress(s string) (uint16, error) {
+	if v, err := strconv.ParseUint(s, 10, 16); err == nil && v < cpu.RAMSize {
+		return uint16(v), nil
+	}
+	if sym, ok := d.syms[s]; ok && sym.Kind != modules.LABEL {
+		return sym.Address, nil
+	}
+	if v, ok := table.DefinedSymbolTable[s]; ok {
+		return v, nil
+	}
+
+	return 0, fmt.Errorf("unknown address %s", s)
+}
+
+func (d *Debugger) romName(a uint16) string {
+	if n, ok := d.labels[a]; ok {
+		return fmt.Sprintf("%s (%d)", n, a)
+	}
+
+	return strconv.Itoa(int(a))
+}
+
+func sortedAddresses(m map[uint16]struct{}) []uint16 {
+	as := []uint16{}
+	for a := range m {
+		as = append(as, a)
+	}
+	sort.Slice(as, func(i, j int) bool { return as[i] < as[j] })
+
+	return as
+}
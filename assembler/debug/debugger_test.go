@@ -0,0 +1,96 @@
+package debug
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const countdown = `@3
+D=A
+@i
+M=D
+(LOOP)
+@i
+MD=M-1
+@LOOP
+D;JGT
+(END)
+@END
+0;JMP
+`
+
+func newDebugger(t *testing.T) *Debugger {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "Count.asm")
+	if err := os.WriteFile(path, []byte(countdown), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDebugger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+func session(t *testing.T, d *Debugger, cmds string) string {
+	t.Helper()
+	b := &bytes.Buffer{}
+	if err := d.Run(strings.NewReader(cmds), b); err != nil {
+		t.Fatal(err)
+	}
+
+	return b.String()
+}
+
+func TestDebuggerStep(t *testing.T) {
+	d := newDebugger(t)
+	out := session(t, d, "step 2\nstep\n\n")
+	if d.c.PC != 4 || d.c.Cycles != 4 {
+		t.Errorf("PC=%d cycles=%d, want 4 and 4:\n%s", d.c.PC, d.c.Cycles, out)
+	}
+	if !strings.Contains(out, "PC=4 A=16 D=3 cycles=4\n  (LOOP) ") {
+		t.Errorf("the stop at LOOP is not shown:\n%s", out)
+	}
+}
+
+func TestDebuggerRejectsBadStepCounts(t *testing.T) {
+	for _, n := range []string{"0", "-1", "x"} {
+		d := newDebugger(t)
+		out := session(t, d, "step "+n+"\n")
+		if d.c.Cycles != 0 || !strings.Contains(out, "error: invalid count "+n) {
+			t.Errorf("step %s ran %d cycles:\n%s", n, d.c.Cycles, out)
+		}
+	}
+}
+
+func TestDebuggerBreakAndWatch(t *testing.T) {
+	d := newDebugger(t)
+	out := session(t, d, "break END\nwatch i\ncontinue\nx i\nunwatch\ncontinue\nx i\n")
+	for _, want := range []string{
+		"breakpoint at END (8)",
+		"watching RAM[16]",
+		"RAM[16] 0 -> 3",
+		"RAM[16]=3",
+		"RAM[16]=0",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("no %q in:\n%s", want, out)
+		}
+	}
+	if d.c.PC != 8 {
+		t.Errorf("PC=%d, want the breakpoint at 8", d.c.PC)
+	}
+}
+
+func TestDebuggerLimit(t *testing.T) {
+	d := newDebugger(t)
+	d.SetLimit(5)
+	out := session(t, d, "continue\n")
+	if d.c.Cycles != 5 || !strings.Contains(out, "stopped after 5 instructions") {
+		t.Errorf("cycles=%d:\n%s", d.c.Cycles, out)
+	}
+}
@@ -0,0 +1,209 @@
+package modules
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/terashin777/assembler/table"
+)
+
+// indent is put before instructions. Labels and directives start at column 1.
+const indent = "    "
+
+// FormatOptions changes how FormatSource lays out assembly.
+type FormatOptions struct {
+	// Names rewrites numeric addresses of predefined symbols into their
+	// names, @16384 to @SCREEN always and @13 to @R13 when the next
+	// instruction uses M.
+	Names bool
+}
+
+// fmtLine is a source line split into its command and its trailing comment.
+type fmtLine struct {
+	code    string
+	comment string
+	blank   bool
+}
+
+// FormatSource writes the assembly read from r to w in canonical layout.
+// Instructions are indented under labels, trailing comments of consecutive
+// lines are aligned and C-instructions are spelled the way the tables know
+// them. Runs of blank lines become one and line endings follow the first
+// line.
+func FormatSource(r io.Reader, w io.Writer, opts FormatOptions) error {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	raw := strings.Split(string(src), "\n")
+	eol := "\n"
+	if strings.HasSuffix(raw[0], "\r") {
+		eol = "\r\n"
+	}
+	macros := map[string]bool{}
+	for _, t := range raw {
+		if fs := strings.Fields(stripComment(t)); len(fs) > 1 && fs[0] == ".macro" {
+			macros[fs[1]] = true
+		}
+	}
+	ls := []*fmtLine{}
+	for _, t := range raw {
+		ls = append(ls, formatLine(strings.TrimRight(t, "\r"), macros))
+	}
+
+	if opts.Names {
+		nameAddresses(ls)
+	}
+
+	bw := bufio.NewWriter(w)
+	prevBlank := true
+	for i := 0; i < len(ls); {
+		if ls[i].blank {
+			if !prevBlank && !isTrailingBlanks(ls[i:]) {
+				bw.WriteString(eol)
+			}
+			prevBlank = true
+			i++
+			continue
+		}
+
+		j := i
+		width := 0
+		for ; j < len(ls) && !ls[j].blank; j++ {
+			if ls[j].code != "" && ls[j].comment != "" && len(ls[j].code) > width {
+				width = len(ls[j].code)
+			}
+		}
+		for _, l := range ls[i:j] {
+			bw.WriteString(alignComment(l, width) + eol)
+		}
+		prevBlank = false
+		i = j
+	}
+
+	return bw.Flush()
+}
+
+// formatLine lays out a line. macros are the macros the source defines.
+func formatLine(raw string, macros map[string]bool) *fmtLine {
+	code := strings.TrimSpace(stripComment(raw))
+	comment := strings.TrimSpace(raw[len(stripComment(raw)):])
+	if code == "" && comment == "" {
+		return &fmtLine{blank: true}
+	}
+	if code == "" {
+		// Comments on their own line keep whether they were indented.
+		if strings.TrimLeft(raw, " \t") != raw {
+			comment = indent + comment
+		}
+		return &fmtLine{comment: comment}
+	}
+
+	p := &Parser{cur: &line{text: code}}
+	switch {
+	case p.isDirective():
+	case isMacroCall(code, macros):
+		// Arguments keep their spacing, as it separates them.
+		name := strings.Fields(code)[0]
+		args := strings.TrimSpace(code[len(name):])
+		code = indent + name
+		if args != "" {
+			code += " " + args
+		}
+	case p.commandType() == L_COMMAND:
+		code = "(" + strings.TrimSpace(p.symbol()) + ")"
+	case p.commandType() == A_COMMAND:
+		code = indent + "@" + strings.TrimSpace(p.symbol())
+	default:
+		code = indent + canonical(code)
+	}
+
+	return &fmtLine{code: code, comment: comment}
+}
+
+// isMacroCall tells whether code calls a macro. Macros defined elsewhere,
+// such as in included files, are told apart from C-instructions by their
+// name being followed by arguments rather than by an operator.
+func isMacroCall(code string, macros map[string]bool) bool {
+	fs := strings.Fields(code)
+	if !isSymbol(fs[0]) {
+		return false
+	}
+	if macros[fs[0]] {
+		return true
+	}
+	rest := strings.TrimSpace(code[len(fs[0]):])
+
+	return rest != "" && !strings.ContainsRune("=;+-&|!", rune(rest[0]))
+}
+
+// nameAddresses rewrites numeric A-instructions into predefined symbols.
+// Small numbers are only addresses of registers when M is used next.
+func nameAddresses(ls []*fmtLine) {
+	for i, l := range ls {
+		if !strings.HasPrefix(l.code, indent+"@") {
+			continue
+		}
+		v, err := strconv.ParseUint(strings.TrimPrefix(l.code, indent+"@"), 10, 16)
+		if err != nil {
+			continue
+		}
+
+		n := ""
+		switch {
+		case v < 16 && usesMemoryNext(ls[i+1:]):
+			n = fmt.Sprintf("R%d", v)
+		case v >= 16:
+			if s, ok := table.DefinedSymbolName(uint16(v)); ok {
+				n = s
+			}
+		}
+		if n != "" {
+			l.code = indent + "@" + n
+		}
+	}
+}
+
+// usesMemoryNext tells whether the next command in ls is a C-instruction
+// reading or writing M.
+func usesMemoryNext(ls []*fmtLine) bool {
+	for _, l := range ls {
+		if l.code == "" {
+			continue
+		}
+		c := strings.TrimSpace(l.code)
+		p := &Parser{cur: &line{text: c}}
+		return !p.isDirective() && p.commandType() == C_COMMAND && strings.Contains(stripJump(c), "M")
+	}
+
+	return false
+}
+
+func stripJump(c string) string {
+	if i := strings.IndexByte(c, ';'); i != -1 {
+		return c[:i]
+	}
+
+	return c
+}
+
+func isTrailingBlanks(ls []*fmtLine) bool {
+	for _, l := range ls {
+		if !l.blank {
+			return false
+		}
+	}
+
+	return true
+}
+
+func alignComment(l *fmtLine, width int) string {
+	if l.code == "" || l.comment == "" {
+		return l.code + l.comment
+	}
+
+	return l.code + strings.Repeat(" ", width-len(l.code)+1) + l.comment
+}
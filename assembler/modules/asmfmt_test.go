@@ -0,0 +1,138 @@
+package modules
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func formatSource(t *testing.T, src string) string {
+	t.Helper()
+	b := &bytes.Buffer{}
+	if err := FormatSource(strings.NewReader(src), b, FormatOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	return b.String()
+}
+
+func assembleFile(t *testing.T, path string) []uint16 {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	res, err := Assemble(f, nil, Options{File: path})
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+
+	return res.Words
+}
+
+func TestFormatSourceLayout(t *testing.T) {
+	src := "// counts down\n(LOOP)\n@ i\nM = M - 1  // next\nD=M\n\n\n@LOOP\nD ; JGT\n"
+	want := "// counts down\n(LOOP)\n    @i\n    M=M-1 // next\n    D=M\n\n    @LOOP\n    D;JGT\n"
+	if got := formatSource(t, src); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFormatSourceKeepsMacros(t *testing.T) {
+	dir := t.TempDir()
+	inc := ".macro ADDTO A, B\n@\\A\nD=M\n@\\B\nM=D+M\n.endm\n"
+	if err := os.WriteFile(filepath.Join(dir, "lib.asm"), []byte(inc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := `.include "lib.asm"
+.macro LOOPN N
+@\N
+D=A
+(L)
+D=D-1
+@L
+D;JGT
+.endm
+LOOPN 3
+  LOOPN   5 // five times
+ADDTO R0,  R1
+(END)
+@END
+0;JMP
+`
+	got := formatSource(t, src)
+	for _, l := range []string{`.include "lib.asm"`, ".macro LOOPN N", ".endm", "    LOOPN 3\n", "    LOOPN 5 // five times", "    ADDTO R0,  R1"} {
+		if !strings.Contains(got, l) {
+			t.Errorf("formatted source has no %q:\n%s", l, got)
+		}
+	}
+	if again := formatSource(t, got); again != got {
+		t.Errorf("formatting is not stable:\n%s\nthen\n%s", got, again)
+	}
+
+	orig := filepath.Join(dir, "orig.asm")
+	formatted := filepath.Join(dir, "formatted.asm")
+	if err := os.WriteFile(orig, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(formatted, []byte(got), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if a, b := assembleFile(t, orig), assembleFile(t, formatted); !reflect.DeepEqual(a, b) {
+		t.Errorf("formatting changed the program:\n%v\n%v", a, b)
+	}
+}
+
+func TestIsMacroCall(t *testing.T) {
+	tests := []struct {
+		code string
+		want bool
+	}{
+		{"LOOPN 3", true},
+		{"PUSH R1, R2", true},
+		{"PUSHD", false},
+		{"D = M", false},
+		{"D ; JGT", false},
+		{"D + 1", false},
+		{"D | M", false},
+		{"0;JMP", false},
+	}
+	for _, tt := range tests {
+		if got := isMacroCall(tt.code, map[string]bool{}); got != tt.want {
+			t.Errorf("isMacroCall(%q) = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+	if !isMacroCall("PUSHD", map[string]bool{"PUSHD": true}) {
+		t.Error("a macro defined in the source without arguments is not a call")
+	}
+}
+
+func TestFormatSourceAlignsComments(t *testing.T) {
+	src := "@i // the counter\nM=M+1 // next\n\nD=M // alone\r\n"
+	want := "    @i    // the counter\n    M=M+1 // next\n\n    D=M // alone\n"
+	if got := formatSource(t, src); got != want {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+
+	crlf := "@i\r\nM=1\r\n"
+	if got, want := formatSource(t, crlf), "    @i\r\n    M=1\r\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFormatSourceNames(t *testing.T) {
+	src := "@16384\nD=A\n@13\nM=D\n@13\nD=A\n@24576\nD=M\n"
+	b := &bytes.Buffer{}
+	if err := FormatSource(strings.NewReader(src), b, FormatOptions{Names: true}); err != nil {
+		t.Fatal(err)
+	}
+	want := "    @SCREEN\n    D=A\n    @R13\n    M=D\n    @13\n    D=A\n    @KBD\n    D=M\n"
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b, want)
+	}
+}
@@ -0,0 +1,119 @@
+package modules
+
+import (
+	"errors"
+	"io"
+	"strconv"
+)
+
+// Options changes how Assemble works. The zero value assembles the book's
+// Hack to the hack text format.
+type Options struct {
+	// File is the name reported in diagnostics. Includes are relative to it.
+	File string
+	// Format is one of Formats. Empty means DefaultFormat.
+	Format string
+	// ISA is one of table.ISAs. Empty means the book's Hack.
+	ISA string
+	// Optimize runs the peephole optimizer.
+	Optimize bool
+}
+
+// Result is what Assemble made of a source.
+type Result struct {
+	Words   []uint16
+	Symbols []*Symbol
+	Listing *Listing
+	// Sources tells where each word comes from, indexed by ROM address.
+	Sources []SourcePos
+	// Diagnostics are the errors and the warnings in source order of each
+	// kind, errors first.
+	Diagnostics ErrorList
+	// Saved is how many words the optimizer removed.
+	Saved int
+}
+
+// Assemble assembles the source read from r and writes it to w in the format
+// of opts. w may be nil to get the words only. When the source has errors,
+// nothing is written and the returned error is an ErrorList; the result still
+// tells the diagnostics.
+func Assemble(r io.Reader, w io.Writer, opts Options) (*Result, error) {
+	if opts.File == "" {
+		opts.File = "-"
+	}
+	if opts.Format == "" {
+		opts.Format = DefaultFormat
+	}
+
+	var ww WordWriter
+	if w != nil {
+		var err error
+		if ww, err = NewWordWriter(opts.Format, w); err != nil {
+			return nil, err
+		}
+	}
+
+	popts := []ParserOption{}
+	if opts.Optimize {
+		popts = append(popts, WithOptimizer())
+	}
+	p, err := NewParser(opts.File, r, popts...)
+	if err != nil {
+		return nil, err
+	}
+	if opts.ISA != "" {
+		if err = p.SetISA(opts.ISA); err != nil {
+			return nil, err
+		}
+	}
+
+	res := &Result{
+		Words:   []uint16{},
+		Listing: NewListing(p.SourceLines()),
+		Sources: []SourcePos{},
+	}
+	errs := ErrorList{}
+	for {
+		s, err := p.Read()
+		if err == io.EOF {
+			break
+		}
+		var e *Error
+		if errors.As(err, &e) {
+			errs = append(errs, e)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := strconv.ParseUint(s, 2, 16)
+		if err != nil {
+			return nil, err
+		}
+		res.Words = append(res.Words, uint16(v))
+		res.Sources = append(res.Sources, p.Source())
+		no, addr := p.Pos()
+		res.Listing.Add(no, addr, s)
+	}
+	res.Symbols = p.Symbols()
+	res.Saved = p.Saved()
+	res.Diagnostics = append(errs, p.Warnings()...)
+	if len(errs) > 0 {
+		return res, errs
+	}
+
+	if ww == nil {
+		return res, nil
+	}
+	for _, v := range res.Words {
+		if err = ww.WriteWord(v); err != nil {
+			return nil, err
+		}
+	}
+	if err = ww.Flush(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
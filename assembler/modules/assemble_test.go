@@ -0,0 +1,80 @@
+package modules
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAssembleWrites(t *testing.T) {
+	b := &bytes.Buffer{}
+	res, err := Assemble(strings.NewReader("@2\nD=A\n"), b, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), "0000000000000010\n1110110000010000\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if want := []uint16{2, 0xEC10}; !reflect.DeepEqual(res.Words, want) {
+		t.Errorf("words %v, want %v", res.Words, want)
+	}
+	if s := res.Sources[1]; s.File != "-" || s.Line != 2 || s.Text != "D=A" {
+		t.Errorf("source of word 1 is %+v", s)
+	}
+
+	b.Reset()
+	if _, err := Assemble(strings.NewReader("@2\nD=A\n"), b, Options{Format: "readmemh"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), "0002\nec10\n"; got != want {
+		t.Errorf("readmemh: got %q, want %q", got, want)
+	}
+}
+
+func TestAssembleWritesNothingOnErrors(t *testing.T) {
+	b := &bytes.Buffer{}
+	res, err := Assemble(strings.NewReader("@2\nD=X\n@3\nD=Y\n"), b, Options{File: "bad.asm"})
+	var errs ErrorList
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("err = %v, want 2 errors", err)
+	}
+	if b.Len() != 0 {
+		t.Errorf("wrote %q", b)
+	}
+	if res == nil || len(res.Diagnostics) != 2 || res.Diagnostics[0].File != "bad.asm" {
+		t.Errorf("result %+v does not tell the diagnostics", res)
+	}
+}
+
+func TestAssembleOptions(t *testing.T) {
+	if _, err := Assemble(strings.NewReader("@2\n"), &bytes.Buffer{}, Options{Format: "srec"}); err == nil {
+		t.Error("an unknown format is accepted")
+	}
+
+	src := "@SP\nAM=M+1\nA=A-1\nM=D\n@SP\nAM=M-1\nD=M\n"
+	plain, err := Assemble(strings.NewReader(src), nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt, err := Assemble(strings.NewReader(src), nil, Options{Optimize: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plain.Words)-len(opt.Words) != opt.Saved || opt.Saved == 0 || plain.Saved != 0 {
+		t.Errorf("optimizer saved %d words, from %d to %d", opt.Saved, len(plain.Words), len(opt.Words))
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestAssembleReportsWriteErrors(t *testing.T) {
+	if _, err := Assemble(strings.NewReader("@2\n"), failingWriter{}, Options{}); err == nil || err.Error() != "disk full" {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -4,16 +4,41 @@ import (
 	"github.com/terashin777/assembler/table"
 )
 
-type Code struct{}
+type Code struct {
+	isa string
+}
 
 func (c *Code) Dest(s string) (byte, error) {
 	return table.Dest.ToBinary(s)
 }
 
 func (c *Code) Comp(s string) (byte, error) {
-	return table.Comp.ToBinary(s)
+	b, err := table.Comp.ToBinary(s)
+	if err != nil && c.isShift(s) {
+		return table.Shift.ToBinary(s)
+	}
+
+	return b, err
 }
 
 func (c *Code) Jump(s string) (byte, error) {
 	return table.Jump.ToBinary(s)
 }
+
+// Prefix returns the top 3 bits of the C-instruction computing s.
+func (c *Code) Prefix(s string) uint16 {
+	if c.isShift(s) {
+		return 0b101
+	}
+
+	return 0b111
+}
+
+func (c *Code) isShift(s string) bool {
+	if c.isa != table.ISA_EXTENDED {
+		return false
+	}
+
+	_, err := table.Shift.ToBinary(s)
+	return err == nil
+}
@@ -0,0 +1,43 @@
+package modules
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/terashin777/assembler/table"
+)
+
+func TestCommutativeSpellings(t *testing.T) {
+	a, err := assembleString(t, "D=D+A\nM=D|M\nDM=M+1\nAMD=D&A;JMP\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := assembleString(t, "D=A+D\nM=M|D\nMD=1+M\nDMA=A&D;JMP\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("got %04x, want %04x", b, a)
+	}
+}
+
+func TestExtendedISA(t *testing.T) {
+	src := "D=D<<\nM=M>>\nAD=A<<;JGT\n"
+	if _, err := assembleString(t, src); err == nil {
+		t.Error("shifts are accepted by the book's Hack")
+	}
+
+	res, err := Assemble(strings.NewReader(src), nil, Options{File: "t.asm", ISA: table.ISA_EXTENDED})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint16{0xAC10, 0xB008, 0xA831}
+	if !reflect.DeepEqual(res.Words, want) {
+		t.Errorf("got %04x, want %04x", res.Words, want)
+	}
+
+	if _, err := Assemble(strings.NewReader(src), nil, Options{File: "t.asm", ISA: "z80"}); err == nil {
+		t.Error("an unknown ISA is accepted")
+	}
+}
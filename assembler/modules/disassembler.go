@@ -0,0 +1,192 @@
+package modules
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/terashin777/assembler/table"
+)
+
+const (
+	cPrefix = 0b111 << 13
+	// shiftPrefix starts the shift instructions of the extended ISA.
+	shiftPrefix = 0b101 << 13
+	aBit        = 1 << 12
+)
+
+type Disassembler struct {
+	fn      string
+	words   []uint16
+	nos     []int
+	labels  map[uint16]struct{}
+	symbols bool
+	i       int
+	labeled bool
+}
+
+// NewDisassembler reads the whole .hack source from r. fn is the file name
+// reported in errors.
+func NewDisassembler(fn string, r io.Reader) (*Disassembler, error) {
+	d := &Disassembler{
+		fn:     fn,
+		labels: map[uint16]struct{}{},
+	}
+
+	err := d.prepare(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// UseSymbols makes A-instructions addressing memory print predefined symbols
+// like SP or SCREEN instead of numbers.
+func (d *Disassembler) UseSymbols(b bool) {
+	d.symbols = b
+}
+
+func (d *Disassembler) prepare(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	errs := ErrorList{}
+	no := 0
+	for s.Scan() {
+		no++
+		l := s.Text()
+		t := strings.TrimSpace(l)
+		if t == "" {
+			continue
+		}
+
+		w, err := strconv.ParseUint(t, 2, 16)
+		if err != nil || len(t) != 16 {
+			errs = append(errs, &Error{
+				File: d.fn,
+				Line: no,
+				Col:  strings.Index(l, t) + 1,
+				Text: t,
+				Err:  fmt.Errorf("invalid word"),
+			})
+			continue
+		}
+		d.words = append(d.words, uint16(w))
+		d.nos = append(d.nos, no)
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	for i := 1; i < len(d.words); i++ {
+		if d.isJump(i) && d.isACommand(i-1) && int(d.words[i-1]) <= len(d.words) {
+			d.labels[d.words[i-1]] = struct{}{}
+		}
+	}
+
+	return nil
+}
+
+// Read returns the next line of assembly. Labels for jump targets are
+// returned as lines of their own before the instruction they point to.
+func (d *Disassembler) Read() (string, error) {
+	if _, ok := d.labels[uint16(d.i)]; ok && !d.labeled {
+		d.labeled = true
+		return fmt.Sprintf("(%s)", d.label(uint16(d.i))), nil
+	}
+	if d.i >= len(d.words) {
+		return "", io.EOF
+	}
+
+	i := d.i
+	d.i++
+	d.labeled = false
+	if d.isACommand(i) {
+		return d.disassembleACommand(i), nil
+	}
+
+	return d.disassembleCCommand(i)
+}
+
+func (d *Disassembler) label(v uint16) string {
+	return fmt.Sprintf("L%d", v)
+}
+
+func (d *Disassembler) disassembleACommand(i int) string {
+	v := d.words[i]
+	if i+1 < len(d.words) && d.isJump(i+1) {
+		if _, ok := d.labels[v]; ok {
+			return "@" + d.label(v)
+		}
+	}
+	if d.symbols && i+1 < len(d.words) && d.usesMemory(i+1) {
+		if n, ok := table.DefinedSymbolName(v); ok {
+			return "@" + n
+		}
+	}
+
+	return fmt.Sprintf("@%d", v)
+}
+
+func (d *Disassembler) disassembleCCommand(i int) (string, error) {
+	w := d.words[i]
+	var c string
+	var err error
+	switch {
+	case w&cPrefix == cPrefix:
+		c, err = table.Comp.ToMnemonic(byte(w >> 6 & 0b1111111))
+	case w&cPrefix == shiftPrefix:
+		c, err = table.Shift.ToMnemonic(byte(w >> 6 & 0b1111111))
+	default:
+		return "", d.errorOf(i, fmt.Errorf("invalid word"))
+	}
+	if err != nil {
+		return "", d.errorOf(i, err)
+	}
+	dst, err := table.Dest.ToMnemonic(byte(w >> 3 & 0b111))
+	if err != nil {
+		return "", d.errorOf(i, err)
+	}
+	j, err := table.Jump.ToMnemonic(byte(w & 0b111))
+	if err != nil {
+		return "", d.errorOf(i, err)
+	}
+
+	com := c
+	if dst != "" {
+		com = dst + "=" + com
+	}
+	if j != "" {
+		com = com + ";" + j
+	}
+
+	return com, nil
+}
+
+func (d *Disassembler) errorOf(i int, err error) *Error {
+	return &Error{
+		File: d.fn,
+		Line: d.nos[i],
+		Col:  1,
+		Text: fmt.Sprintf("%016b", d.words[i]),
+		Err:  err,
+	}
+}
+
+func (d *Disassembler) isACommand(i int) bool {
+	return d.words[i]&(1<<15) == 0
+}
+
+func (d *Disassembler) isJump(i int) bool {
+	return !d.isACommand(i) && d.words[i]&0b111 != 0
+}
+
+// usesMemory reports whether the instruction reads or writes M.
+func (d *Disassembler) usesMemory(i int) bool {
+	w := d.words[i]
+	return !d.isACommand(i) && (w&aBit != 0 || w&(1<<3) != 0)
+}
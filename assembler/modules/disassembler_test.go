@@ -0,0 +1,79 @@
+package modules
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func disassemble(t *testing.T, words []uint16, symbols bool) string {
+	t.Helper()
+	b := &bytes.Buffer{}
+	for _, w := range words {
+		fmt.Fprintf(b, "%016b\n", w)
+	}
+	d, err := NewDisassembler("t.hack", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.UseSymbols(symbols)
+
+	out := &strings.Builder{}
+	for {
+		s, err := d.Read()
+		if err == io.EOF {
+			return out.String()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		out.WriteString(s + "\n")
+	}
+}
+
+func TestDisassembleRoundTrip(t *testing.T) {
+	for _, fn := range []string{"add/Add.asm", "max/Max.asm", "rect/Rect.asm", "pong/Pong.asm"} {
+		words := assembleFile(t, "../../projects/06/"+fn)
+		for _, symbols := range []bool{false, true} {
+			src := disassemble(t, words, symbols)
+			got, err := assembleString(t, src)
+			if err != nil {
+				t.Fatalf("%s: symbols=%v: %v", fn, symbols, err)
+			}
+			if !reflect.DeepEqual(got, words) {
+				t.Errorf("%s: symbols=%v: disassembling and assembling changed the program", fn, symbols)
+			}
+		}
+	}
+}
+
+func TestDisassembleLabelsJumpTargets(t *testing.T) {
+	words, err := assembleString(t, "@i\nM=1\n(LOOP)\n@SCREEN\nM=-1\n@LOOP\n0;JMP\n(END)\n@END\nD;JEQ\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "@16\nM=1\n(L2)\n@SCREEN\nM=-1\n@L2\n0;JMP\n(L6)\n@L6\nD;JEQ\n"
+	if got := disassemble(t, words, true); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDisassembleErrors(t *testing.T) {
+	_, err := NewDisassembler("t.hack", strings.NewReader("0000000000000001\n\n101\nxyz0000000000000\n"))
+	var errs ErrorList
+	if !errors.As(err, &errs) || len(errs) != 2 || errs[0].Line != 3 || errs[1].Line != 4 {
+		t.Errorf("err = %v, want invalid words at lines 3 and 4", err)
+	}
+
+	d, err := NewDisassembler("t.hack", strings.NewReader("1000000000000000\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Read(); err == nil {
+		t.Error("a word that is neither an A- nor a C-instruction is disassembled")
+	}
+}
@@ -0,0 +1,65 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type (
+	// Error is an assembly error tied to the source position that caused it.
+	// Warnings are reported the same way but do not stop assembling.
+	Error struct {
+		File     string
+		Line     int
+		Col      int
+		Text     string
+		Severity Severity
+		Err      error
+	}
+
+	ErrorList []*Error
+
+	Severity int
+)
+
+const (
+	SEVERITY_ERROR Severity = iota
+	SEVERITY_WARNING
+)
+
+// Diagnostics found by validating the source. Errors of the parser wrap them,
+// so callers can tell them apart with errors.Is.
+var (
+	ErrDuplicateLabel  = errors.New("duplicate label")
+	ErrPredefinedLabel = errors.New("label shadows predefined symbol")
+	ErrInvalidSymbol   = errors.New("invalid symbol")
+	ErrOutOfRange      = errors.New("value is out of range")
+	ErrUnusedLabel     = errors.New("unused label")
+	ErrROMOverflow     = errors.New("program exceeds ROM")
+)
+
+func (s Severity) String() string {
+	if s == SEVERITY_WARNING {
+		return "warning"
+	}
+
+	return "error"
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s: %q", e.File, e.Line, e.Col, e.Severity, e.Err, e.Text)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (l ErrorList) Error() string {
+	ss := make([]string, 0, len(l))
+	for _, e := range l {
+		ss = append(ss, e.Error())
+	}
+
+	return strings.Join(ss, "\n")
+}
@@ -0,0 +1,208 @@
+package modules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const maxAddress = 32767
+
+type (
+	// exprParser evaluates constant expressions like SCREEN+32*ROW. It knows
+	// + - * / unary minus and parentheses over numbers and symbols.
+	exprParser struct {
+		s      string
+		i      int
+		lookup func(sym string) (int, error)
+	}
+
+	// exprError is an error at the offset off of the expression.
+	exprError struct {
+		off int
+		err error
+	}
+)
+
+func (e *exprError) Error() string {
+	return e.err.Error()
+}
+
+// isExpression tells whether an A-instruction operand needs evaluating rather
+// than being a plain number or symbol.
+func isExpression(s string) bool {
+	return strings.ContainsAny(s, "+-*/() \t")
+}
+
+func evalExpr(s string, lookup func(sym string) (int, error)) (int, error) {
+	e := &exprParser{s: s, lookup: lookup}
+	v, err := e.expr()
+	if err != nil {
+		return 0, err
+	}
+	if e.skipSpace(); e.i < len(e.s) {
+		return 0, e.errorf("unexpected %q", e.s[e.i:])
+	}
+
+	return v, nil
+}
+
+// checkAddress rejects values A-instructions cannot hold.
+func checkAddress(v int) error {
+	if v < 0 || maxAddress < v {
+		return fmt.Errorf("%w: %d is not in 0..%d", ErrOutOfRange, v, maxAddress)
+	}
+
+	return nil
+}
+
+func (e *exprParser) errorf(format string, a ...interface{}) error {
+	return &exprError{off: e.i, err: fmt.Errorf(format, a...)}
+}
+
+func (e *exprParser) skipSpace() {
+	for e.i < len(e.s) && (e.s[e.i] == ' ' || e.s[e.i] == '\t') {
+		e.i++
+	}
+}
+
+func (e *exprParser) peek() byte {
+	e.skipSpace()
+	if e.i >= len(e.s) {
+		return 0
+	}
+
+	return e.s[e.i]
+}
+
+func (e *exprParser) expr() (int, error) {
+	v, err := e.term()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		op := e.peek()
+		if op != '+' && op != '-' {
+			return v, nil
+		}
+		e.i++
+
+		w, err := e.term()
+		if err != nil {
+			return 0, err
+		}
+		if op == '+' {
+			v += w
+		} else {
+			v -= w
+		}
+	}
+}
+
+func (e *exprParser) term() (int, error) {
+	v, err := e.unary()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		op := e.peek()
+		if op != '*' && op != '/' {
+			return v, nil
+		}
+		e.i++
+
+		at := e.i
+		w, err := e.unary()
+		if err != nil {
+			return 0, err
+		}
+		if op == '*' {
+			v *= w
+			continue
+		}
+		if w == 0 {
+			e.i = at
+			return 0, e.errorf("division by zero")
+		}
+		v /= w
+	}
+}
+
+func (e *exprParser) unary() (int, error) {
+	if e.peek() != '-' {
+		return e.primary()
+	}
+	e.i++
+
+	v, err := e.unary()
+	return -v, err
+}
+
+func (e *exprParser) primary() (int, error) {
+	c := e.peek()
+	switch {
+	case c == '(':
+		e.i++
+		v, err := e.expr()
+		if err != nil {
+			return 0, err
+		}
+		if e.peek() != ')' {
+			return 0, e.errorf("missing )")
+		}
+		e.i++
+		return v, nil
+	case '0' <= c && c <= '9':
+		at := e.i
+		t := e.token()
+		v, err := strconv.ParseInt(t, 10, 32)
+		if err != nil {
+			e.i = at
+			return 0, e.errorf("invalid number %q", t)
+		}
+		return int(v), nil
+	case isSymbolChar(rune(c)):
+		at := e.i
+		t := e.token()
+		v, err := e.lookup(t)
+		if err != nil {
+			e.i = at
+			return 0, e.errorf("%s", err)
+		}
+		return v, nil
+	case c == 0:
+		return 0, e.errorf("missing operand")
+	}
+
+	return 0, e.errorf("unexpected %q", c)
+}
+
+func (e *exprParser) token() string {
+	at := e.i
+	for e.i < len(e.s) && isSymbolChar(rune(e.s[e.i])) {
+		e.i++
+	}
+
+	return e.s[at:e.i]
+}
+
+// isSymbol tells whether s is a valid symbol, which does not start with a digit.
+func isSymbol(s string) bool {
+	if s == "" || unicode.IsDigit(rune(s[0])) {
+		return false
+	}
+	for _, r := range s {
+		if !isSymbolChar(r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isSymbolChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_.$:", r)
+}
@@ -0,0 +1,74 @@
+package modules
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func assembleString(t *testing.T, src string) ([]uint16, error) {
+	t.Helper()
+	res, err := Assemble(strings.NewReader(src), nil, Options{File: "t.asm"})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Words, nil
+}
+
+func TestExpressionOperands(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []uint16
+	}{
+		{".equ W 3\n@W", []uint16{3}},
+		{".equ W 3\n@W+1", []uint16{4}},
+		{".equ W 3\n@2*(W+1)", []uint16{8}},
+		{".equ W 3\n@(W)", []uint16{3}},
+		{".equ W 3\n@((W))", []uint16{3}},
+		{".equ W 3\n@(W+1)*(W-1)", []uint16{8}},
+		{".equ W 3\n.equ H (W*2)\n@H", []uint16{6}},
+		{"@SCREEN+32*2", []uint16{16448}},
+		{"@-(-5)", []uint16{5}},
+		{"@7/2", []uint16{3}},
+		{"(LOOP)\n@LOOP+1", []uint16{1}},
+		// Numbers are decimal, with leading zeros or not, as plain operands.
+		{"@010", []uint16{10}},
+		{"@010+0", []uint16{10}},
+		{".equ X 010\n@X", []uint16{10}},
+		{".equ X 010\n@X*2", []uint16{20}},
+	}
+	for _, tt := range tests {
+		got, err := assembleString(t, tt.src)
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestExpressionErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"@(1", "missing )"},
+		{"@2*(1+1", "missing )"},
+		{"@1/0", "division by zero"},
+		{"@32767+1", "not in 0..32767"},
+		{".equ A B\n.equ B A\n@A", "refers to itself"},
+		{"@0x10+0", `invalid number "0x10"`},
+		{".equ X 0b1\n@X", `invalid number "0b1"`},
+	}
+	for _, tt := range tests {
+		_, err := assembleString(t, tt.src)
+		var errs ErrorList
+		if !errors.As(err, &errs) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: err = %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package modules
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+type (
+	// WordWriter writes a stream of assembled words in a ROM image format.
+	// Flush must be called after the last word.
+	WordWriter interface {
+		WriteWord(v uint16) error
+		Flush() error
+	}
+
+	format struct {
+		ext string
+		new func(w *bufio.Writer) WordWriter
+	}
+
+	// textWriter writes a word per line formatted by f.
+	textWriter struct {
+		w *bufio.Writer
+		f string
+	}
+
+	binaryWriter struct {
+		w *bufio.Writer
+		o binary.ByteOrder
+	}
+
+	// ihexWriter writes Intel HEX records of 8 words. Addresses count words
+	// and each word is 2 bytes in big endian, as memory 16 bits wide expects.
+	ihexWriter struct {
+		w    *bufio.Writer
+		addr int
+		buf  []uint16
+	}
+
+	// logisimWriter writes the "v2.0 raw" image Logisim memories load.
+	logisimWriter struct {
+		w *bufio.Writer
+		n int
+	}
+
+	// mifWriter writes an Altera Memory Initialization File filling the
+	// rest of the ROM with 0.
+	mifWriter struct {
+		w *bufio.Writer
+		n int
+	}
+)
+
+const DefaultFormat = "hack"
+
+var formats = map[string]format{
+	"hack": {ext: ".hack", new: func(w *bufio.Writer) WordWriter {
+		return &textWriter{w: w, f: "%016b\n"}
+	}},
+	"bin-le": {ext: ".bin", new: func(w *bufio.Writer) WordWriter {
+		return &binaryWriter{w: w, o: binary.LittleEndian}
+	}},
+	"bin-be": {ext: ".bin", new: func(w *bufio.Writer) WordWriter {
+		return &binaryWriter{w: w, o: binary.BigEndian}
+	}},
+	"ihex": {ext: ".hex", new: func(w *bufio.Writer) WordWriter {
+		return &ihexWriter{w: w}
+	}},
+	"logisim": {ext: ".img", new: func(w *bufio.Writer) WordWriter {
+		return &logisimWriter{w: w}
+	}},
+	"readmemb": {ext: ".mem", new: func(w *bufio.Writer) WordWriter {
+		return &textWriter{w: w, f: "%016b\n"}
+	}},
+	"readmemh": {ext: ".mem", new: func(w *bufio.Writer) WordWriter {
+		return &textWriter{w: w, f: "%04x\n"}
+	}},
+	"mif": {ext: ".mif", new: func(w *bufio.Writer) WordWriter {
+		return &mifWriter{w: w}
+	}},
+}
+
+// Formats returns the names of the ROM image formats.
+func Formats() []string {
+	fs := make([]string, 0, len(formats))
+	for f := range formats {
+		fs = append(fs, f)
+	}
+	sort.Strings(fs)
+
+	return fs
+}
+
+// FormatExt returns the file extension of the format.
+func FormatExt(name string) (string, error) {
+	f, ok := formats[name]
+	if !ok {
+		return "", fmt.Errorf("unknown format %s (%s)", name, strings.Join(Formats(), ", "))
+	}
+
+	return f.ext, nil
+}
+
+func NewWordWriter(name string, w io.Writer) (WordWriter, error) {
+	f, ok := formats[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %s (%s)", name, strings.Join(Formats(), ", "))
+	}
+
+	return f.new(bufio.NewWriter(w)), nil
+}
+
+func (t *textWriter) WriteWord(v uint16) error {
+	_, err := fmt.Fprintf(t.w, t.f, v)
+	return err
+}
+
+func (t *textWriter) Flush() error {
+	return t.w.Flush()
+}
+
+func (b *binaryWriter) WriteWord(v uint16) error {
+	bs := make([]byte, 2)
+	b.o.PutUint16(bs, v)
+	_, err := b.w.Write(bs)
+	return err
+}
+
+func (b *binaryWriter) Flush() error {
+	return b.w.Flush()
+}
+
+func (h *ihexWriter) WriteWord(v uint16) error {
+	h.buf = append(h.buf, v)
+	if len(h.buf) < 8 {
+		return nil
+	}
+
+	return h.writeData()
+}
+
+func (h *ihexWriter) writeData() error {
+	bs := []byte{}
+	for _, v := range h.buf {
+		bs = append(bs, byte(v>>8), byte(v))
+	}
+	err := h.writeRecord(h.addr, 0x00, bs)
+	h.addr += len(h.buf)
+	h.buf = h.buf[:0]
+	return err
+}
+
+func (h *ihexWriter) writeRecord(addr int, typ byte, data []byte) error {
+	rec := append([]byte{byte(len(data)), byte(addr >> 8), byte(addr), typ}, data...)
+	var sum byte
+	for _, b := range rec {
+		sum += b
+	}
+	rec = append(rec, -sum)
+
+	_, err := fmt.Fprintf(h.w, ":%X\n", rec)
+	return err
+}
+
+func (h *ihexWriter) Flush() error {
+	if len(h.buf) > 0 {
+		if err := h.writeData(); err != nil {
+			return err
+		}
+	}
+	if err := h.writeRecord(0, 0x01, nil); err != nil {
+		return err
+	}
+
+	return h.w.Flush()
+}
+
+func (l *logisimWriter) WriteWord(v uint16) error {
+	if l.n == 0 {
+		if _, err := l.w.WriteString("v2.0 raw\n"); err != nil {
+			return err
+		}
+	}
+
+	sep := " "
+	if l.n%8 == 7 {
+		sep = "\n"
+	}
+	l.n++
+	_, err := fmt.Fprintf(l.w, "%x%s", v, sep)
+	return err
+}
+
+func (l *logisimWriter) Flush() error {
+	if l.n == 0 {
+		if _, err := l.w.WriteString("v2.0 raw\n"); err != nil {
+			return err
+		}
+	}
+	if l.n%8 != 0 {
+		if err := l.w.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+
+	return l.w.Flush()
+}
+
+func (m *mifWriter) header() error {
+	_, err := fmt.Fprintf(m.w, "WIDTH=16;\nDEPTH=%d;\n\nADDRESS_RADIX=UNS;\nDATA_RADIX=BIN;\n\nCONTENT BEGIN\n", romSize)
+	return err
+}
+
+func (m *mifWriter) WriteWord(v uint16) error {
+	if m.n == 0 {
+		if err := m.header(); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(m.w, "\t%d : %016b;\n", m.n, v)
+	m.n++
+	return err
+}
+
+func (m *mifWriter) Flush() error {
+	if m.n == 0 {
+		if err := m.header(); err != nil {
+			return err
+		}
+	}
+	if m.n < romSize {
+		if _, err := fmt.Fprintf(m.w, "\t[%d..%d] : %016b;\n", m.n, romSize-1, 0); err != nil {
+			return err
+		}
+	}
+	if _, err := m.w.WriteString("END;\n"); err != nil {
+		return err
+	}
+
+	return m.w.Flush()
+}
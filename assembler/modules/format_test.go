@@ -0,0 +1,78 @@
+package modules
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func writeWords(t *testing.T, format string, words []uint16) string {
+	t.Helper()
+	b := &bytes.Buffer{}
+	w, err := NewWordWriter(format, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range words {
+		if err := w.WriteWord(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	return b.String()
+}
+
+func TestFormats(t *testing.T) {
+	words := []uint16{0x0002, 0xEC10, 0x0003, 0xE090, 0x0000, 0xE308, 0x0006, 0xEA87, 0x8001}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"hack", "0000000000000010\n1110110000010000\n0000000000000011\n1110000010010000\n0000000000000000\n1110001100001000\n0000000000000110\n1110101010000111\n1000000000000001\n"},
+		{"bin-le", "\x02\x00\x10\xEC\x03\x00\x90\xE0\x00\x00\x08\xE3\x06\x00\x87\xEA\x01\x80"},
+		{"bin-be", "\x00\x02\xEC\x10\x00\x03\xE0\x90\x00\x00\xE3\x08\x00\x06\xEA\x87\x80\x01"},
+		{"ihex", ":100000000002EC100003E0900000E3080006EA871D\n:02000800800175\n:00000001FF\n"},
+		{"logisim", "v2.0 raw\n2 ec10 3 e090 0 e308 6 ea87\n8001 \n"},
+		{"readmemb", "0000000000000010\n1110110000010000\n0000000000000011\n1110000010010000\n0000000000000000\n1110001100001000\n0000000000000110\n1110101010000111\n1000000000000001\n"},
+		{"readmemh", "0002\nec10\n0003\ne090\n0000\ne308\n0006\nea87\n8001\n"},
+	}
+	for _, tt := range tests {
+		if got := writeWords(t, tt.format, words); got != tt.want {
+			t.Errorf("%s: got\n%q\nwant\n%q", tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestMIF(t *testing.T) {
+	got := writeWords(t, "mif", []uint16{0x0002, 0xEC10})
+	want := "WIDTH=16;\nDEPTH=32768;\n\nADDRESS_RADIX=UNS;\nDATA_RADIX=BIN;\n\nCONTENT BEGIN\n" +
+		"\t0 : 0000000000000010;\n\t1 : 1110110000010000;\n\t[2..32767] : 0000000000000000;\nEND;\n"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestEmptyImages(t *testing.T) {
+	for format, want := range map[string]string{"ihex": ":00000001FF\n", "logisim": "v2.0 raw\n", "hack": ""} {
+		if got := writeWords(t, format, nil); got != want {
+			t.Errorf("%s: got %q, want %q", format, got, want)
+		}
+	}
+}
+
+func TestFormatNames(t *testing.T) {
+	for _, f := range Formats() {
+		if _, err := FormatExt(f); err != nil {
+			t.Errorf("%s: %v", f, err)
+		}
+	}
+	if ext, _ := FormatExt("ihex"); ext != ".hex" {
+		t.Errorf("ihex files end with %s", ext)
+	}
+	if _, err := NewWordWriter("srec", &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "unknown format srec") {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package modules
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+type (
+	// Listing puts each source line side by side with the ROM address and
+	// the word assembled from it. Lines expanding to several words, like
+	// macro calls, get a row for each following word.
+	Listing struct {
+		src   []string
+		words map[int][]listedWord
+	}
+
+	listedWord struct {
+		addr uint16
+		word string
+	}
+)
+
+func NewListing(src []string) *Listing {
+	return &Listing{
+		src:   src,
+		words: map[int][]listedWord{},
+	}
+}
+
+// Add records the word assembled from the source line no.
+func (l *Listing) Add(no int, addr uint16, word string) {
+	l.words[no] = append(l.words[no], listedWord{addr: addr, word: word})
+}
+
+func (l *Listing) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	var n int64
+	for i, s := range l.src {
+		no := i + 1
+		lws := l.words[no]
+		var c int
+		var err error
+		if len(lws) == 0 {
+			c, err = fmt.Fprintf(bw, "%5s  %16s  %5d  %s\n", "", "", no, s)
+		} else {
+			c, err = fmt.Fprintf(bw, "%5d  %s  %5d  %s\n", lws[0].addr, lws[0].word, no, s)
+			lws = lws[1:]
+		}
+		n += int64(c)
+		if err != nil {
+			return n, err
+		}
+
+		for _, lw := range lws {
+			c, err = fmt.Fprintf(bw, "%5d  %s\n", lw.addr, lw.word)
+			n += int64(c)
+			if err != nil {
+				return n, err
+			}
+		}
+	}
+
+	return n, bw.Flush()
+}
@@ -0,0 +1,168 @@
+package modules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	ObjectExt = ".hobj"
+	romSize   = 32768
+)
+
+type (
+	// Object is a relocatable object assembled from one file. Code is
+	// assembled as if it starts at ROM address 0 and Relocs tell the words
+	// the linker fixes.
+	Object struct {
+		File    string    `json:"file"`
+		Code    []uint16  `json:"code"`
+		Exports []*Symbol `json:"exports"`
+		Imports []string  `json:"imports"`
+		Relocs  []*Reloc  `json:"relocs"`
+	}
+
+	// Reloc is a word of the code to fix when linking. Without Symbol the ROM
+	// base of the object is added to it, otherwise it becomes the address of
+	// Symbol, which is a label of another object or a variable.
+	Reloc struct {
+		Addr   uint16 `json:"addr"`
+		Symbol string `json:"symbol,omitempty"`
+	}
+)
+
+// NewObject assembles the whole source of p into an object. Labels are
+// local to the file unless declared by .global, which exports them.
+func NewObject(p *Parser) (*Object, error) {
+	p.SetRelocatable(true)
+	o := &Object{
+		File:    p.fn,
+		Code:    []uint16{},
+		Exports: []*Symbol{},
+		Imports: []string{},
+		Relocs:  []*Reloc{},
+	}
+
+	errs := ErrorList{}
+	imported := map[string]struct{}{}
+	for {
+		s, err := p.Read()
+		if err == io.EOF {
+			break
+		}
+		var e *Error
+		if errors.As(err, &e) {
+			errs = append(errs, e)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		w, err := strconv.ParseUint(s, 2, 16)
+		if err != nil {
+			return nil, err
+		}
+		o.Code = append(o.Code, uint16(w))
+
+		r := p.Reloc()
+		if r == nil {
+			continue
+		}
+		o.Relocs = append(o.Relocs, r)
+		if _, ok := imported[r.Symbol]; r.Symbol != "" && !ok {
+			imported[r.Symbol] = struct{}{}
+			o.Imports = append(o.Imports, r.Symbol)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	for _, s := range p.Symbols() {
+		if _, ok := p.gs[s.Name]; ok && s.Kind == LABEL {
+			o.Exports = append(o.Exports, s)
+		}
+	}
+	return o, nil
+}
+
+func WriteObject(w io.Writer, o *Object) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(o)
+}
+
+func ReadObject(r io.Reader) (*Object, error) {
+	o := &Object{}
+	if err := json.NewDecoder(r).Decode(o); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// Link places the objects one after another in ROM and resolves their
+// relocations. Exported labels are shared by all objects and symbols no
+// object exports become variables allocated from RAM 16 in order of first
+// use.
+func Link(objs []*Object) ([]uint16, []*Symbol, error) {
+	bases := make([]int, len(objs))
+	size := 0
+	for i, o := range objs {
+		bases[i] = size
+		size += len(o.Code)
+	}
+	if size > romSize {
+		return nil, nil, fmt.Errorf("program has %d words but ROM has only %d", size, romSize)
+	}
+
+	syms := []*Symbol{}
+	labels := map[string]*Symbol{}
+	from := map[string]string{}
+	for i, o := range objs {
+		for _, e := range o.Exports {
+			if f, ok := from[e.Name]; ok {
+				return nil, nil, fmt.Errorf("label %s is defined in both %s and %s", e.Name, f, o.File)
+			}
+			s := &Symbol{Name: e.Name, Kind: LABEL, Address: e.Address + uint16(bases[i])}
+			labels[e.Name] = s
+			from[e.Name] = o.File
+			syms = append(syms, s)
+		}
+	}
+
+	words := make([]uint16, 0, size)
+	var ra uint16 = 16
+	for i, o := range objs {
+		code := append([]uint16{}, o.Code...)
+		for _, r := range o.Relocs {
+			if int(r.Addr) >= len(code) {
+				return nil, nil, fmt.Errorf("%s: relocation at %d is out of the code", o.File, r.Addr)
+			}
+			if r.Symbol == "" {
+				v := int(code[r.Addr]) + bases[i]
+				if v > maxAddress {
+					return nil, nil, fmt.Errorf("%s: address %d is out of range 0..%d", o.File, v, maxAddress)
+				}
+				code[r.Addr] = uint16(v)
+				continue
+			}
+
+			s, ok := labels[r.Symbol]
+			if !ok {
+				s = &Symbol{Name: r.Symbol, Kind: VARIABLE, Address: ra}
+				labels[r.Symbol] = s
+				syms = append(syms, s)
+				ra++
+			}
+			code[r.Addr] = s.Address
+		}
+		words = append(words, code...)
+	}
+
+	return words, syms, nil
+}
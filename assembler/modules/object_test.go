@@ -0,0 +1,122 @@
+package modules
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newObject(t *testing.T, fn, src string) (*Object, error) {
+	t.Helper()
+	p, err := NewParser(fn, strings.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+
+	return NewObject(p)
+}
+
+func TestLinkKeepsLabelsLocal(t *testing.T) {
+	srcs := []struct{ fn, src string }{
+		{"a.asm", "@INC\n0;JMP\n(END)\n@END\n0;JMP\n"},
+		{"b.asm", ".global INC\n(INC)\n@x\nM=M+1\n(END)\n@END\n0;JMP\n"},
+		{"c.asm", "@x\n(END)\n@END\n0;JMP\n"},
+	}
+	objs := []*Object{}
+	for _, s := range srcs {
+		o, err := newObject(t, s.fn, s.src)
+		if err != nil {
+			t.Fatalf("%s: %v", s.fn, err)
+		}
+		objs = append(objs, o)
+	}
+	if len(objs[0].Exports) != 0 || len(objs[2].Exports) != 0 {
+		t.Errorf("objects without .global export %v and %v", objs[0].Exports, objs[2].Exports)
+	}
+	if len(objs[1].Exports) != 1 || objs[1].Exports[0].Name != "INC" {
+		t.Errorf("b.asm exports %v, want INC", objs[1].Exports)
+	}
+
+	words, _, err := Link(objs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint16{
+		4, 0xEA87, 2, 0xEA87, // a.asm
+		16, 0xFDC8, 6, 0xEA87, // b.asm
+		16, 9, 0xEA87, // c.asm
+	}
+	if !reflect.DeepEqual(words, want) {
+		t.Errorf("got %v, want %v", words, want)
+	}
+}
+
+func TestLinkRejectsDuplicateGlobals(t *testing.T) {
+	a, err := newObject(t, "a.asm", ".global F\n(F)\n0;JMP\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := newObject(t, "b.asm", ".global F\n(F)\n0;JMP\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Link([]*Object{a, b}); err == nil || !strings.Contains(err.Error(), "label F is defined in both a.asm and b.asm") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestGlobalMustBeLabel(t *testing.T) {
+	for _, src := range []string{".global F\n@F\n", ".global\n", ".global 1F\n"} {
+		if _, err := newObject(t, "a.asm", src); err == nil {
+			t.Errorf("%q: no error", src)
+		}
+	}
+}
+
+func TestObjectRoundTrip(t *testing.T) {
+	o, err := newObject(t, "a.asm", ".global MAIN\n(MAIN)\n@x\nM=1\n@MAIN\n0;JMP\n@LIB\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &bytes.Buffer{}
+	if err := WriteObject(b, o); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadObject(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, o) {
+		t.Errorf("got %+v, want %+v", got, o)
+	}
+	if want := []string{"x", "LIB"}; !reflect.DeepEqual(o.Imports, want) {
+		t.Errorf("imports %v, want %v", o.Imports, want)
+	}
+}
+
+func TestLinkSharesVariables(t *testing.T) {
+	a, err := newObject(t, "a.asm", "@x\nM=1\n@y\nM=1\n@F\n0;JMP\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := newObject(t, "b.asm", ".global F\n(F)\n@y\nD=M\n@x\nM=D\n(END)\n@END\n0;JMP\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	words, syms, err := Link([]*Object{a, b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint16{16, 0xEFC8, 17, 0xEFC8, 6, 0xEA87, 17, 0xFC10, 16, 0xE308, 10, 0xEA87}
+	if !reflect.DeepEqual(words, want) {
+		t.Errorf("got %v, want %v", words, want)
+	}
+	names := []string{}
+	for _, s := range syms {
+		names = append(names, s.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"F", "x", "y"}) {
+		t.Errorf("symbols %v", names)
+	}
+}
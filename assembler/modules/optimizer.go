@@ -0,0 +1,220 @@
+package modules
+
+import (
+	"strings"
+
+	"github.com/terashin777/assembler/table"
+)
+
+// pushPop is a push followed by a pop as the VM translator writes them. The
+// stack pointer ends where it started, so only the write of D stays.
+var pushPop = []string{"@SP", "AM=M+1", "A=A-1", "M=D", "@SP", "AM=M-1", "D=M"}
+
+// optimize runs the peephole optimizer over src until nothing changes and
+// returns how many words it removed. Removed commands become noCode. It
+// assumes nothing reads A right after a label, which holds for code reaching
+// the label by a jump.
+func optimize(src []*line) int {
+	saved := 0
+	for {
+		n := collapsePushPop(src) + dropJumpsToNext(src) + dropReloads(src)
+		if n == 0 {
+			return saved
+		}
+		saved += n
+	}
+}
+
+// collapsePushPop turns a push followed by a pop into
+//
+//	@SP
+//	A=M
+//	M=D
+//
+// which leaves A, D, SP and the stack as they were.
+func collapsePushPop(src []*line) int {
+	ls := codeAndLabels(src)
+	n := 0
+	for i := 0; i+len(pushPop) <= len(ls); i++ {
+		if !matches(ls[i:i+len(pushPop)], pushPop) {
+			continue
+		}
+
+		ls[i+1].text = "A=M"
+		for _, j := range []int{2, 4, 5, 6} {
+			ls[i+j].noCode = true
+		}
+		n += 4
+		i += len(pushPop) - 1
+	}
+
+	return n
+}
+
+// dropJumpsToNext removes "@L" and a jump without dest when L is one of the
+// labels right after them.
+func dropJumpsToNext(src []*line) int {
+	ls := codeAndLabels(src)
+	n := 0
+	for i := 0; i+1 < len(ls); i++ {
+		a, j := ls[i], ls[i+1]
+		if a.sym != nil || j.sym != nil || !strings.HasPrefix(a.text, "@") || !isPlainJump(j.text) {
+			continue
+		}
+
+		target := strings.TrimSpace(a.text[1:])
+		for _, l := range ls[i+2:] {
+			if l.sym == nil {
+				break
+			}
+			if l.sym.Name == target {
+				a.noCode, j.noCode = true, true
+				n += 2
+				break
+			}
+		}
+	}
+
+	return n
+}
+
+// dropReloads removes "@X" when A already holds X. A is unknown after a label
+// and after a command writing A.
+func dropReloads(src []*line) int {
+	n := 0
+	a := ""
+	for _, l := range codeAndLabels(src) {
+		if l.sym != nil {
+			a = ""
+			continue
+		}
+
+		c := canonical(l.text)
+		if !strings.HasPrefix(c, "@") {
+			if i := strings.IndexByte(c, '='); i != -1 && strings.Contains(c[:i], "A") {
+				a = ""
+			}
+			continue
+		}
+		if c == a {
+			l.noCode = true
+			n++
+			continue
+		}
+		a = c
+	}
+
+	return n
+}
+
+// codeAndLabels returns the commands and the labels of src in order.
+func codeAndLabels(src []*line) []*line {
+	ls := []*line{}
+	for _, l := range src {
+		if l.sym != nil || !l.noCode {
+			ls = append(ls, l)
+		}
+	}
+
+	return ls
+}
+
+func matches(ls []*line, pattern []string) bool {
+	for i, l := range ls {
+		if l.sym != nil || canonical(l.text) != pattern[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isPlainJump tells whether text is a C-instruction that jumps and writes
+// nothing.
+func isPlainJump(text string) bool {
+	c := canonical(text)
+	return !strings.HasPrefix(c, "@") && !strings.Contains(c, "=") && strings.Contains(c, ";")
+}
+
+// isJump tells whether text is a C-instruction that may jump.
+func isJump(text string) bool {
+	return !strings.HasPrefix(text, "@") && strings.Contains(text, ";")
+}
+
+// canonical spells a command the way the tables know it, so patterns match
+// however the source is written.
+func canonical(text string) string {
+	if strings.HasPrefix(text, "@") {
+		return "@" + strings.TrimSpace(text[1:])
+	}
+
+	d, c, j := "", text, ""
+	if i := strings.IndexByte(c, ';'); i != -1 {
+		c, j = c[:i], ";"+strings.TrimSpace(c[i+1:])
+	}
+	if i := strings.IndexByte(c, '='); i != -1 {
+		d, c = table.Dest.Normalize(c[:i])+"=", c[i+1:]
+	}
+
+	return d + table.Comp.Normalize(c) + j
+}
+
+// usesLabelArithmetic tells whether an expression computes an address from
+// a label. Removing commands would move what such an expression points to,
+// so the optimizer leaves these sources alone.
+func (p *Parser) usesLabelArithmetic() bool {
+	for _, l := range p.src {
+		if l.noCode || !strings.HasPrefix(l.text, "@") {
+			continue
+		}
+		if sym := l.text[1:]; isExpression(sym) && p.refersToLabel(sym, map[string]bool{}) {
+			return true
+		}
+	}
+	for _, c := range p.cs {
+		if isExpression(c.expr) && p.refersToLabel(c.expr, map[string]bool{}) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// jumpsToFixedAddress tells whether a jump takes its target from an
+// A-instruction using no label, like @5 before 0;JMP. Removing commands
+// would move the instruction at that address, so the optimizer leaves these
+// sources alone.
+func (p *Parser) jumpsToFixedAddress() bool {
+	var a *line
+	for _, l := range p.src {
+		if l.noCode {
+			continue
+		}
+		if a != nil && isJump(l.text) && !p.refersToLabel(a.text[1:], map[string]bool{}) {
+			return true
+		}
+		a = nil
+		if strings.HasPrefix(l.text, "@") {
+			a = l
+		}
+	}
+
+	return false
+}
+
+// refersToLabel tells whether expr uses a label, directly or through
+// constants. seen stops constants referring to themselves.
+func (p *Parser) refersToLabel(expr string, seen map[string]bool) bool {
+	found := false
+	evalExpr(expr, func(sym string) (int, error) {
+		if _, ok := p.ls[sym]; ok {
+			found = true
+		} else if c, ok := p.csi[sym]; ok && !seen[sym] {
+			seen[sym] = true
+			found = found || p.refersToLabel(c.expr, seen)
+		}
+		return 1, nil
+	})
+
+	return found
+}
@@ -0,0 +1,115 @@
+package modules
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		want  string
+		saved int
+	}{
+		{
+			"push then pop",
+			"@SP\nAM=M+1\nA=A-1\nM=D\n@SP\nAM=M-1\nD=M\n",
+			"@SP\nA=M\nM=D\n",
+			4,
+		},
+		{
+			"push then pop spelled otherwise",
+			"@ SP\nMA = M+1\nA=A-1\nM=D\n@SP\nAM=M-1\nD=M\n",
+			"@SP\nA=M\nM=D\n",
+			4,
+		},
+		{
+			"jump to the next line",
+			"@NEXT\n0;JMP\n(NEXT)\nD=1\n@NEXT\nD;JGT\n(OTHER)\n(NEXT2)\n",
+			"(NEXT)\nD=1\n@NEXT\nD;JGT\n(OTHER)\n(NEXT2)\n",
+			2,
+		},
+		{
+			"jump past a label list",
+			"@B\nD;JEQ\n(A)\n(B)\nD=1\n@A\n0;JMP\n",
+			"(A)\n(B)\nD=1\n@A\n0;JMP\n",
+			2,
+		},
+		{
+			"jump writing a register",
+			"@NEXT\nD=D-1;JGT\n(NEXT)\n@NEXT\n0;JMP\n",
+			"@NEXT\nD=D-1;JGT\n(NEXT)\n@NEXT\n0;JMP\n",
+			0,
+		},
+		{
+			"reloads",
+			"@i\nM=1\n@i\nM=M+1\n@i\nA=M\n@i\nD=M\n(L)\n@i\nM=0\n@L\n0;JMP\n",
+			"@i\nM=1\nM=M+1\nA=M\n@i\nD=M\n(L)\n@i\nM=0\n@L\n0;JMP\n",
+			2,
+		},
+		{
+			"label arithmetic",
+			"(L)\n@L+2\n0;JMP\n@x\n@x\n",
+			"(L)\n@L+2\n0;JMP\n@x\n@x\n",
+			0,
+		},
+		{
+			"label arithmetic in a constant",
+			".equ AFTER L+1\n(L)\n@AFTER\n0;JMP\n@x\n@x\n",
+			".equ AFTER L+1\n(L)\n@AFTER\n0;JMP\n@x\n@x\n",
+			0,
+		},
+		{
+			"jump to a number",
+			"@x\n@x\n@5\n0;JMP\nD=1\nD=0\n",
+			"@x\n@x\n@5\n0;JMP\nD=1\nD=0\n",
+			0,
+		},
+		{
+			"jump to a number in a constant",
+			".equ TARGET 2*2\n@x\n@x\n@TARGET\nD;JGT\nD=1\n",
+			".equ TARGET 2*2\n@x\n@x\n@TARGET\nD;JGT\nD=1\n",
+			0,
+		},
+		{
+			"jump to a label after a number",
+			"@x\n@x\n@5\nD=A\n(L)\n@L\n0;JMP\n",
+			"@x\n@5\nD=A\n(L)\n@L\n0;JMP\n",
+			1,
+		},
+	}
+	for _, tt := range tests {
+		res, err := Assemble(strings.NewReader(tt.src), nil, Options{File: "t.asm", Optimize: true})
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		want, err := assembleString(t, tt.want)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(res.Words, want) {
+			t.Errorf("%s: got %04x, want %04x", tt.name, res.Words, want)
+		}
+		if res.Saved != tt.saved {
+			t.Errorf("%s: saved %d words, want %d", tt.name, res.Saved, tt.saved)
+		}
+	}
+}
+
+func TestOptimizeKeepsSources(t *testing.T) {
+	src := "@i\nM=1\n@i\nM=M+1\n"
+	res, err := Assemble(strings.NewReader(src), nil, Options{File: "t.asm", Optimize: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := []int{}
+	for _, s := range res.Sources {
+		lines = append(lines, s.Line)
+	}
+	if want := []int{1, 2, 4}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("words come from lines %v, want %v", lines, want)
+	}
+}
@@ -1,12 +1,12 @@
 package modules
 
 import (
-	"bufio"
-	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/terashin777/assembler/table"
 )
@@ -14,12 +14,65 @@ import (
 type (
 	Parser struct {
 		c    *Code
-		s    *bufio.Scanner
+		r    io.Reader
 		t    map[string]uint16
 		ra   uint16
-		cur  string
-		src  []byte
-		size int64
+		fn   string
+		cur  *line
+		src  []*line
+		li   int
+		raw  []string
+		syms []*Symbol
+		cs   []*constant
+		csi  map[string]*constant
+		ls   map[string]*line
+		used map[string]struct{}
+		// gs are the labels declared by .global with the line declaring them.
+		gs map[string]*line
+
+		relocatable bool
+		rel         *Reloc
+		optimize    bool
+		saved       int
+	}
+
+	// SourcePos is a line of assembly an instruction comes from.
+	SourcePos struct {
+		File string
+		Line int
+		Text string
+	}
+
+	// ParserOption changes how NewParser prepares the source.
+	ParserOption func(*Parser)
+
+	// line is a command with the position it had in the original source.
+	line struct {
+		text   string
+		file   string
+		no     int
+		top    int
+		col    int
+		addr   uint16
+		noCode bool
+		err    error
+		errOff int
+		call   *expansion
+		// sym is the symbol a label line defines.
+		sym *Symbol
+	}
+
+	// constant is a symbol defined by ".equ NAME VALUE". It is evaluated after
+	// all labels are known, so VALUE may use labels defined later. rel is how
+	// many times the ROM base of the file is in the value, as for labels.
+	constant struct {
+		name  string
+		expr  string
+		off   int
+		l     *line
+		state int
+		v     int
+		rel   int
 	}
 
 	CommandType int
@@ -31,14 +84,26 @@ const (
 	L_COMMAND
 )
 
-func NewParser(r io.ReadSeeker, size int64) (*Parser, error) {
+// NewParser reads the whole source from r. fn is the file name reported in
+// errors.
+func NewParser(fn string, r io.Reader, opts ...ParserOption) (*Parser, error) {
 	p := &Parser{
-		s:    bufio.NewScanner(r),
-		c:    &Code{},
+		r:    r,
+		c:    &Code{isa: table.ISA_HACK},
 		ra:   16,
-		t:    table.DefinedSymbolTable,
-		src:  []byte{},
-		size: size,
+		t:    map[string]uint16{},
+		fn:   fn,
+		src:  []*line{},
+		csi:  map[string]*constant{},
+		ls:   map[string]*line{},
+		used: map[string]struct{}{},
+		gs:   map[string]*line{},
+	}
+	for k, v := range table.DefinedSymbolTable {
+		p.t[k] = v
+	}
+	for _, o := range opts {
+		o(p)
 	}
 
 	err := p.prepare()
@@ -49,69 +114,382 @@ func NewParser(r io.ReadSeeker, size int64) (*Parser, error) {
 	return p, nil
 }
 
-func (p *Parser) prepare() (err error) {
-	defer func() {
-		err = p.donePrepare()
-	}()
+// WithOptimizer makes the parser run the peephole optimizer before labels
+// get their addresses. Saved tells how many words it removed.
+func WithOptimizer() ParserOption {
+	return func(p *Parser) {
+		p.optimize = true
+	}
+}
+
+func (p *Parser) prepare() error {
+	defer p.donePrepare()
+
+	pp := newPreprocessor()
+	if err := pp.run(p.fn, p.r, 0); err != nil {
+		return err
+	}
+	p.raw = pp.raw
+
+	for _, sl := range pp.out {
+		l := p.extractCommand(sl)
+		if l == nil {
+			continue
+		}
+		p.cur = l
+		if l.err != nil {
+			p.src = append(p.src, l)
+			continue
+		}
 
-	w := bytes.NewBuffer(make([]byte, 0, p.size))
-	var c uint16 = 0
-	for p.s.Scan() {
-		com := p.extractCommand()
-		if com == "" {
+		if p.isDirective() {
+			l.noCode = true
+			p.prepareDirective()
+			p.src = append(p.src, l)
 			continue
 		}
-		p.cur = com
 
 		if p.isLCommand() {
-			p.t[p.symbol()] = c
+			l.noCode = true
+			l.err = p.defineLabel(p.symbol())
+			p.src = append(p.src, l)
 			continue
 		}
 
-		w.Write([]byte(com + "\n"))
-		c++
+		p.src = append(p.src, l)
 	}
+	for name, l := range p.gs {
+		if _, ok := p.ls[name]; !ok && l.err == nil {
+			l.err = fmt.Errorf("global %s is not a label of the file", name)
+		}
+	}
+	if p.optimize && !p.usesLabelArithmetic() && !p.jumpsToFixedAddress() {
+		p.saved = optimize(p.src)
+	}
+	p.assignAddresses()
+	p.evalConstants()
 
-	p.src = w.Bytes()
-	return p.s.Err()
+	return nil
 }
 
-func (p *Parser) extractCommand() string {
-	l := p.s.Text()
-	i := strings.Index(l, "//")
-	if i == -1 {
-		return strings.TrimSpace(l)
-	}
+// assignAddresses gives the commands and the labels their ROM addresses.
+func (p *Parser) assignAddresses() {
+	c := 0
+	for _, l := range p.src {
+		if l.sym != nil {
+			l.sym.Address = uint16(c)
+			p.t[l.sym.Name] = uint16(c)
+			continue
+		}
+		if l.noCode {
+			continue
+		}
 
-	return strings.TrimSpace(l[0:i])
+		if c == romSize {
+			l.err = fmt.Errorf("%w of %d words", ErrROMOverflow, romSize)
+		}
+		l.addr = uint16(c)
+		c++
+	}
 }
 
-func (p *Parser) donePrepare() error {
-	p.cur = ""
-	p.s = bufio.NewScanner(bytes.NewReader(p.src))
+// defineLabel defines sym as a label of the current line. The address is
+// given later by assignAddresses.
+func (p *Parser) defineLabel(sym string) error {
+	if sym == "" {
+		return fmt.Errorf("label is blank")
+	}
+	if !isSymbol(sym) {
+		return fmt.Errorf("%w %s", ErrInvalidSymbol, sym)
+	}
+	if l, ok := p.ls[sym]; ok {
+		return fmt.Errorf("%w %s, first defined at %s:%d", ErrDuplicateLabel, sym, l.file, l.no)
+	}
+	if _, ok := table.DefinedSymbolTable[sym]; ok {
+		return fmt.Errorf("%w %s", ErrPredefinedLabel, sym)
+	}
+
+	p.cur.sym = &Symbol{Name: sym, Kind: LABEL}
+	p.t[sym] = 0
+	p.ls[sym] = p.cur
+	p.syms = append(p.syms, p.cur.sym)
 	return nil
 }
 
-func (p *Parser) Read() (string, error) {
-	next := p.s.Scan()
-	if !next {
-		err := p.s.Err()
+func (p *Parser) prepareDirective() {
+	l := p.cur
+	fs := strings.Fields(l.text)
+	switch fs[0] {
+	case ".equ":
+		if len(fs) < 3 {
+			l.err = fmt.Errorf("usage is .equ NAME VALUE")
+			return
+		}
+		name := fs[1]
+		if !isSymbol(name) {
+			l.err = fmt.Errorf("invalid constant name %s", name)
+			return
+		}
+		if _, ok := p.csi[name]; ok {
+			l.err = fmt.Errorf("%s is already defined", name)
+			return
+		}
+
+		off := strings.Index(l.text[len(fs[0]):], name) + len(fs[0]) + len(name)
+		off += len(l.text[off:]) - len(strings.TrimLeft(l.text[off:], " \t"))
+		c := &constant{
+			name: name,
+			expr: l.text[off:],
+			off:  off,
+			l:    l,
+		}
+		p.cs = append(p.cs, c)
+		p.csi[name] = c
+	case ".global":
+		if len(fs) < 2 {
+			l.err = fmt.Errorf("usage is .global NAME...")
+			return
+		}
+		for _, name := range fs[1:] {
+			if !isSymbol(name) {
+				l.err = fmt.Errorf("invalid label name %s", name)
+				return
+			}
+			p.gs[name] = l
+		}
+	default:
+		l.err = fmt.Errorf("unknown directive %s", fs[0])
+	}
+}
+
+func (p *Parser) evalConstants() {
+	for _, c := range p.cs {
+		if _, ok := p.t[c.name]; ok {
+			c.l.err = fmt.Errorf("%s is already defined", c.name)
+			continue
+		}
+
+		v, err := p.evalConstant(c)
 		if err != nil {
-			return "", err
+			continue
 		}
+		p.t[c.name] = uint16(v)
+		p.syms = append(p.syms, &Symbol{Name: c.name, Kind: CONSTANT, Address: uint16(v)})
+	}
+}
 
-		return "", io.EOF
+func (p *Parser) evalConstant(c *constant) (int, error) {
+	switch c.state {
+	case 1:
+		return 0, fmt.Errorf("%s refers to itself", c.name)
+	case 2:
+		if c.l.err != nil {
+			return 0, fmt.Errorf("%s is invalid", c.name)
+		}
+		return c.v, nil
+	}
+
+	c.state = 1
+	v, err := evalExpr(c.expr, p.lookupAt(0))
+	if err == nil {
+		v1, _ := evalExpr(c.expr, p.lookupAt(1))
+		c.rel = v1 - v
+	}
+	c.state = 2
+	var ee *exprError
+	if errors.As(err, &ee) {
+		c.l.err, c.l.errOff = ee.err, c.off+ee.off
+		return 0, err
 	}
+	if err = checkAddress(v); err != nil {
+		c.l.err, c.l.errOff = err, c.off
+		return 0, err
+	}
+
+	c.v = v
+	return v, nil
+}
 
-	com := p.s.Text()
+// lookupAt returns a function giving the value of a symbol used in an
+// expression as if the file started at the ROM address base. Evaluating twice
+// with different bases tells whether a value is a ROM address to relocate.
+func (p *Parser) lookupAt(base int) func(sym string) (int, error) {
+	return func(sym string) (int, error) {
+		if c, ok := p.csi[sym]; ok {
+			v, err := p.evalConstant(c)
+			if err != nil {
+				return 0, err
+			}
+			return v + c.rel*base, nil
+		}
+		if v, ok := p.t[sym]; ok {
+			p.used[sym] = struct{}{}
+			return int(v) + p.relOf(sym)*base, nil
+		}
+
+		return 0, fmt.Errorf("undefined symbol %s", sym)
+	}
+}
+
+// relOf returns how many times the ROM base is in the value of sym.
+func (p *Parser) relOf(sym string) int {
+	if _, ok := p.ls[sym]; ok {
+		return 1
+	}
+	if c, ok := p.csi[sym]; ok {
+		return c.rel
+	}
+
+	return 0
+}
+
+func (p *Parser) extractCommand(sl srcLine) *line {
+	l := stripComment(sl.text)
+	com := strings.TrimSpace(l)
 	if com == "" {
-		return "", nil
+		return nil
+	}
+
+	return &line{
+		text:   com,
+		file:   sl.file,
+		no:     sl.no,
+		top:    sl.top,
+		col:    strings.Index(l, com) + 1,
+		noCode: sl.err != nil,
+		err:    sl.err,
+		call:   sl.call,
+	}
+}
+
+func (p *Parser) donePrepare() {
+	p.cur = nil
+	p.li = 0
+}
+
+// Read returns the next instruction as a 16 digit binary string. Errors are
+// returned as *Error and do not stop the parser, so callers can keep reading
+// to collect every error in the file.
+func (p *Parser) Read() (string, error) {
+	if p.li >= len(p.src) {
+		return "", io.EOF
+	}
+
+	p.cur = p.src[p.li]
+	p.li++
+	p.rel = nil
+	if p.cur.err != nil {
+		return "", p.errorAt(p.cur.errOff, p.cur.err)
+	}
+	if p.cur.noCode {
+		return p.Read()
 	}
-	p.cur = com
 
 	return p.parse()
 }
 
+// Pos returns the line number in the main file and the ROM address of the
+// instruction read last. Instructions from included files and macros are at
+// the line of the .include or the macro call.
+func (p *Parser) Pos() (int, uint16) {
+	return p.cur.top, p.cur.addr
+}
+
+// SetISA selects the instruction set profile, one of table.ISAs.
+func (p *Parser) SetISA(isa string) error {
+	for _, i := range table.ISAs {
+		if i == isa {
+			p.c.isa = isa
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown isa %s (%s)", isa, strings.Join(table.ISAs, ", "))
+}
+
+// Source returns where the instruction read last is written. Unlike Pos,
+// instructions from included files are in the included file.
+func (p *Parser) Source() SourcePos {
+	return SourcePos{File: p.cur.file, Line: p.cur.no, Text: p.cur.text}
+}
+
+// Saved returns how many words the optimizer removed.
+func (p *Parser) Saved() int {
+	return p.saved
+}
+
+// SetRelocatable makes the parser assemble as if the file starts at ROM
+// address 0 and leave undefined symbols to the linker instead of allocating
+// variables. Reloc tells what each instruction needs.
+func (p *Parser) SetRelocatable(b bool) {
+	p.relocatable = b
+}
+
+// Warnings returns the labels never referenced. It is complete after the
+// whole source is read. Labels declared by .global may be referenced by
+// other files, so they are never reported.
+func (p *Parser) Warnings() ErrorList {
+	ws := ErrorList{}
+	for _, s := range p.syms {
+		if _, ok := p.used[s.Name]; s.Kind != LABEL || ok {
+			continue
+		}
+		if _, ok := p.gs[s.Name]; ok {
+			continue
+		}
+		l := p.ls[s.Name]
+		e := l.newError(l.col, l.text, fmt.Errorf("%w %s", ErrUnusedLabel, s.Name))
+		e.Severity = SEVERITY_WARNING
+		ws = append(ws, e)
+	}
+
+	return ws
+}
+
+// Reloc returns the relocation the instruction read last needs, or nil.
+func (p *Parser) Reloc() *Reloc {
+	return p.rel
+}
+
+// SourceLines returns the lines of the main file as they were read.
+func (p *Parser) SourceLines() []string {
+	return p.raw
+}
+
+// Symbols returns the labels and the variables defined so far. Variables are
+// complete after the whole source is read.
+func (p *Parser) Symbols() []*Symbol {
+	return p.syms
+}
+
+// errorAt makes an *Error pointing at the given offset of the current command.
+func (p *Parser) errorAt(off int, err error) *Error {
+	return p.errorOf(off, p.cur.text[off:], err)
+}
+
+func (p *Parser) errorOf(off int, text string, err error) *Error {
+	return p.cur.newError(p.cur.col+off, text, err)
+}
+
+// newError makes an *Error at column col of l. Lines expanded from macros
+// are reported at the outermost call, with the line of each macro body on
+// the way in the message.
+func (l *line) newError(col int, text string, err error) *Error {
+	file, no := l.file, l.no
+	for c := l.call; c != nil; c = c.call {
+		err = fmt.Errorf("in expansion of %s (%s:%d:%d): %w", c.name, file, no, col, err)
+		file, no, col = c.file, c.no, c.col
+	}
+
+	return &Error{
+		File: file,
+		Line: no,
+		Col:  col,
+		Text: text,
+		Err:  err,
+	}
+}
+
 // func (p *Parser) readCommand() string {
 // 	l := p.s.Text()
 // 	com := strings.TrimSpace(l)
@@ -143,23 +521,75 @@ func (p *Parser) parse() (string, error) {
 func (p *Parser) resolveSymbol() (string, error) {
 	sym := p.symbol()
 	if sym == "" {
-		return "", fmt.Errorf("symbol is blank")
+		return "", p.errorAt(0, fmt.Errorf("symbol is blank"))
 	}
-	v, err := strconv.ParseInt(sym, 10, 16)
-	if err == nil {
+	if isExpression(sym) {
+		return p.resolveExpression(sym)
+	}
+	if unicode.IsDigit(rune(sym[0])) {
+		v, err := strconv.ParseInt(sym, 10, 32)
+		if err != nil {
+			return "", p.errorAt(1, fmt.Errorf("%w %s", ErrInvalidSymbol, sym))
+		}
+		if err = checkAddress(int(v)); err != nil {
+			return "", p.errorAt(1, err)
+		}
 		return fmt.Sprintf("%016s", strconv.FormatInt(v, 2)), nil
 	}
+	if !isSymbol(sym) {
+		return "", p.errorAt(1, fmt.Errorf("%w %s", ErrInvalidSymbol, sym))
+	}
 
 	l, ok := p.t[sym]
 	if ok {
+		p.used[sym] = struct{}{}
+		if err := p.relocate(p.relOf(sym)); err != nil {
+			return "", err
+		}
 		return fmt.Sprintf("%016s", strconv.FormatInt(int64(l), 2)), nil
 	}
 
+	if p.relocatable {
+		p.rel = &Reloc{Addr: p.cur.addr, Symbol: sym}
+		return fmt.Sprintf("%016b", 0), nil
+	}
 	return p.setRamAddress(sym), nil
 }
 
+func (p *Parser) resolveExpression(sym string) (string, error) {
+	v, err := evalExpr(sym, p.lookupAt(0))
+	var ee *exprError
+	if errors.As(err, &ee) {
+		return "", p.errorAt(1+ee.off, ee.err)
+	}
+	if err = checkAddress(v); err != nil {
+		return "", p.errorAt(1, err)
+	}
+
+	v1, _ := evalExpr(sym, p.lookupAt(1))
+	if err := p.relocate(v1 - v); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%016s", strconv.FormatInt(int64(v), 2)), nil
+}
+
+// relocate records that the current A-instruction needs the ROM base added
+// when rel is 1. Other values than 0 and 1 can not be relocated.
+func (p *Parser) relocate(rel int) error {
+	if !p.relocatable || rel == 0 {
+		return nil
+	}
+	if rel != 1 {
+		return p.errorAt(1, fmt.Errorf("value is not relocatable"))
+	}
+
+	p.rel = &Reloc{Addr: p.cur.addr}
+	return nil
+}
+
 func (p *Parser) setRamAddress(sym string) string {
 	p.t[sym] = p.ra
+	p.syms = append(p.syms, &Symbol{Name: sym, Kind: VARIABLE, Address: p.ra})
 	p.ra++
 	return fmt.Sprintf("%016s", strconv.FormatInt(int64(p.t[sym]), 2))
 }
@@ -167,24 +597,25 @@ func (p *Parser) setRamAddress(sym string) string {
 func (p *Parser) parseCCommand() (string, error) {
 	d, err := p.c.Dest(p.dest())
 	if err != nil {
-		return "", err
+		return "", p.errorOf(0, p.dest(), err)
 	}
 	d16 := uint16(d) << 3
 
+	si, _ := p.compRange()
 	c, err := p.c.Comp(p.comp())
 	if err != nil {
-		return "", err
+		return "", p.errorOf(si, p.comp(), err)
 	}
 	c16 := uint16(c) << 6
 
 	j, err := p.c.Jump(p.jump())
 	if err != nil {
-		return "", err
+		return "", p.errorAt(strings.IndexByte(p.cur.text, ';')+1, err)
 	}
 	j16 := uint16(j)
 
-	pre, _ := strconv.ParseUint("1110000000000000", 2, 16)
-	res := uint16(pre) | c16 | d16 | j16
+	pre := p.c.Prefix(p.comp()) << 13
+	res := pre | c16 | d16 | j16
 	return fmt.Sprintf("%016s", strconv.FormatInt(int64(res), 2)), nil
 }
 
@@ -201,45 +632,57 @@ func (p *Parser) commandType() CommandType {
 }
 
 func (p *Parser) isACommand() bool {
-	return strings.HasPrefix(p.cur, "@")
+	return strings.HasPrefix(p.cur.text, "@")
+}
+
+func (p *Parser) isDirective() bool {
+	return strings.HasPrefix(p.cur.text, ".")
 }
 
 func (p *Parser) isLCommand() bool {
-	return strings.HasPrefix(p.cur, "(") && strings.HasSuffix(p.cur, ")")
+	return strings.HasPrefix(p.cur.text, "(") && strings.HasSuffix(p.cur.text, ")")
 }
 
+// symbol returns the label of an L-command or the operand of an
+// A-instruction, which may be an expression ending in a parenthesis.
 func (p *Parser) symbol() string {
-	if p.cur[len(p.cur)-1] == ')' {
-		return p.cur[1 : len(p.cur)-1]
+	c := p.cur.text
+	if p.isLCommand() {
+		return c[1 : len(c)-1]
 	}
 
-	return p.cur[1:]
+	return c[1:]
 }
 
 func (p *Parser) dest() string {
-	if i := strings.IndexByte(p.cur, '='); i != -1 {
-		return p.cur[:i]
+	if i := strings.IndexByte(p.cur.text, '='); i != -1 {
+		return p.cur.text[:i]
 	}
 
 	return ""
 }
 
 func (p *Parser) comp() string {
+	si, ei := p.compRange()
+	return p.cur.text[si:ei]
+}
+
+func (p *Parser) compRange() (int, int) {
 	si := 0
-	ei := len(p.cur)
-	if i := strings.IndexByte(p.cur, '='); i != -1 {
+	ei := len(p.cur.text)
+	if i := strings.IndexByte(p.cur.text, '='); i != -1 {
 		si = i + 1
 	}
-	if i := strings.IndexByte(p.cur, ';'); i != -1 {
+	if i := strings.IndexByte(p.cur.text, ';'); i >= si {
 		ei = i
 	}
 
-	return p.cur[si:ei]
+	return si, ei
 }
 
 func (p *Parser) jump() string {
-	if i := strings.IndexByte(p.cur, ';'); i != -1 {
-		return p.cur[i+1:]
+	if i := strings.IndexByte(p.cur.text, ';'); i != -1 {
+		return p.cur.text[i+1:]
 	}
 
 	return ""
@@ -0,0 +1,178 @@
+package modules
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// readAll reads every instruction of the parser, collecting the errors.
+func readAll(t *testing.T, p *Parser) ([]uint16, ErrorList) {
+	t.Helper()
+	ws := []uint16{}
+	errs := ErrorList{}
+	for {
+		s, err := p.Read()
+		if err == io.EOF {
+			return ws, errs
+		}
+		var e *Error
+		if errors.As(err, &e) {
+			errs = append(errs, e)
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := strconv.ParseUint(s, 2, 16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ws = append(ws, uint16(v))
+	}
+}
+
+func TestParserTranslates(t *testing.T) {
+	src := `// Computes R0 = 2 + 3
+@2
+D=A
+@3
+D=D+A  // add
+@0
+M=D
+(END)
+@END
+0;JMP
+@i
+AM=M+1;JGE
+`
+	p, err := NewParser("Add.asm", strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws, errs := readAll(t, p)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	want := []uint16{2, 0xEC10, 3, 0xE090, 0, 0xE308, 6, 0xEA87, 16, 0xFDEB}
+	if !reflect.DeepEqual(ws, want) {
+		t.Errorf("got %04x, want %04x", ws, want)
+	}
+}
+
+func TestParserReportsPositions(t *testing.T) {
+	src := "@1\n  D=X\nAM=M+1;JXX\n(LOOP)\n(LOOP)\n@1a\nQ=M\n.foo\n@32768\n(SP)\n@LOOP\n"
+	p, err := NewParser("e.asm", strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws, errs := readAll(t, p)
+	if len(ws) != 2 {
+		t.Errorf("got %d words, want the 2 valid instructions", len(ws))
+	}
+
+	want := []struct {
+		line, col int
+		text      string
+		is        error
+		msg       string
+	}{
+		{2, 5, "X", nil, "invalid comp"},
+		{3, 8, "JXX", nil, "invalid jump"},
+		{5, 1, "(LOOP)", ErrDuplicateLabel, "first defined at e.asm:4"},
+		{6, 2, "1a", ErrInvalidSymbol, "1a"},
+		{7, 1, "Q", nil, "invalid dest"},
+		{8, 1, ".foo", nil, "unknown directive .foo"},
+		{9, 2, "32768", ErrOutOfRange, "not in 0..32767"},
+		{10, 1, "(SP)", ErrPredefinedLabel, "SP"},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("got %d errors, want %d:\n%v", len(errs), len(want), errs)
+	}
+	for i, w := range want {
+		e := errs[i]
+		if e.File != "e.asm" || e.Line != w.line || e.Col != w.col || e.Text != w.text || e.Severity != SEVERITY_ERROR {
+			t.Errorf("error %d at %s:%d:%d %q, want e.asm:%d:%d %q", i, e.File, e.Line, e.Col, e.Text, w.line, w.col, w.text)
+		}
+		if w.is != nil && !errors.Is(e, w.is) {
+			t.Errorf("error %d is %v, want %v", i, e.Err, w.is)
+		}
+		if !strings.Contains(e.Err.Error(), w.msg) {
+			t.Errorf("error %d is %q, want %q", i, e.Err, w.msg)
+		}
+	}
+}
+
+func TestErrorString(t *testing.T) {
+	e := &Error{File: "a.asm", Line: 3, Col: 5, Text: "X", Err: errors.New("invalid comp")}
+	if got, want := e.Error(), `a.asm:3:5: error: invalid comp: "X"`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	e.Severity = SEVERITY_WARNING
+	if got, want := e.Error(), `a.asm:3:5: warning: invalid comp: "X"`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	tests := []struct {
+		src  string
+		is   error
+		line int
+	}{
+		{"@32767\n@32768\n", ErrOutOfRange, 2},
+		{"@-1\n", ErrOutOfRange, 1},
+		{"(1LOOP)\n", ErrInvalidSymbol, 1},
+		{"(A-B)\n", ErrInvalidSymbol, 1},
+		{"@a-\n", nil, 1},
+		{"(KBD)\n", ErrPredefinedLabel, 1},
+		{"(R15)\n", ErrPredefinedLabel, 1},
+		{"(X)\n@X\n(X)\n", ErrDuplicateLabel, 3},
+		{"()\n", nil, 1},
+		{"@\n", nil, 1},
+		{strings.Repeat("D=0\n", 32769), ErrROMOverflow, 32769},
+	}
+	for _, tt := range tests {
+		res, err := Assemble(strings.NewReader(tt.src), nil, Options{File: "t.asm"})
+		var errs ErrorList
+		if !errors.As(err, &errs) {
+			t.Errorf("%.20q: err = %v, want an ErrorList", tt.src, err)
+			continue
+		}
+		e := errs[0]
+		if tt.is != nil && !errors.Is(e, tt.is) || e.Line != tt.line {
+			t.Errorf("%.20q: got %v, want %v at line %d", tt.src, e, tt.is, tt.line)
+		}
+		if len(res.Diagnostics) < len(errs) {
+			t.Errorf("%.20q: the result has %d diagnostics for %d errors", tt.src, len(res.Diagnostics), len(errs))
+		}
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	src := "(UNUSED)\n@1\n(LOOP)\n@LOOP\n0;JMP\n(ALSO)\n"
+	res, err := Assemble(strings.NewReader(src), nil, Options{File: "t.asm"})
+	if err != nil {
+		t.Fatalf("warnings stop assembling: %v", err)
+	}
+	if len(res.Words) != 3 {
+		t.Errorf("got %d words, want 3", len(res.Words))
+	}
+	ws := res.Diagnostics
+	if len(ws) != 2 || ws[0].Line != 1 || ws[1].Line != 6 {
+		t.Fatalf("diagnostics %v, want warnings at lines 1 and 6", ws)
+	}
+	for _, w := range ws {
+		if w.Severity != SEVERITY_WARNING || !errors.Is(w, ErrUnusedLabel) {
+			t.Errorf("%v is not an unused label warning", w)
+		}
+	}
+
+	res, _ = Assemble(strings.NewReader("(UNUSED)\nD=X\n"), nil, Options{File: "t.asm"})
+	if d := res.Diagnostics; len(d) != 2 || d[0].Severity != SEVERITY_ERROR || d[1].Severity != SEVERITY_WARNING {
+		t.Errorf("diagnostics %v, want the error before the warning", d)
+	}
+}
@@ -0,0 +1,317 @@
+package modules
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// maxExpansionDepth bounds macros calling macros, which catches recursion.
+const maxExpansionDepth = 16
+
+type (
+	// srcLine is a raw source line after preprocessing. file and no tell
+	// where it was written and top is the line of the main file it came from,
+	// which differs for included files and macro expansions. call is the
+	// macro call the line was expanded by.
+	srcLine struct {
+		text string
+		file string
+		no   int
+		top  int
+		err  error
+		call *expansion
+	}
+
+	// expansion is a macro call. call is the expansion the calling line
+	// itself came from, if the macro was called by another macro.
+	expansion struct {
+		name string
+		file string
+		no   int
+		col  int
+		call *expansion
+	}
+
+	macro struct {
+		name   string
+		params []string
+		body   []srcLine
+		no     int
+	}
+
+	// preprocessor expands .include and .macro/.endm before the parser sees
+	// the source.
+	preprocessor struct {
+		macros map[string]*macro
+		files  []string
+		def    *macro
+		defAt  srcLine
+		n      int
+		raw    []string
+		out    []srcLine
+	}
+)
+
+func newPreprocessor() *preprocessor {
+	return &preprocessor{
+		macros: map[string]*macro{},
+	}
+}
+
+// run preprocesses the file read from r. Only the lines of the main file are
+// kept as raw lines.
+func (pp *preprocessor) run(file string, r io.Reader, top int) error {
+	pp.files = append(pp.files, file)
+	defer func() {
+		pp.files = pp.files[:len(pp.files)-1]
+	}()
+
+	main := len(pp.files) == 1
+	s := bufio.NewScanner(r)
+	no := 0
+	for s.Scan() {
+		no++
+		sl := srcLine{text: s.Text(), file: file, no: no, top: top}
+		if main {
+			pp.raw = append(pp.raw, sl.text)
+			sl.top = no
+		}
+
+		if pp.def != nil {
+			pp.define(sl)
+			continue
+		}
+		if err := pp.handle(sl, 0); err != nil {
+			return err
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	// A macro is closed in the file it is defined in, so it does not take in
+	// the lines of the file including it.
+	if pp.def != nil {
+		pp.defAt.err = fmt.Errorf("macro %s is not closed by .endm", pp.def.name)
+		pp.out = append(pp.out, pp.defAt)
+		pp.def = nil
+	}
+	return nil
+}
+
+// define adds sl to the body of the macro being defined.
+func (pp *preprocessor) define(sl srcLine) {
+	fs := strings.Fields(stripComment(sl.text))
+	if len(fs) > 0 && fs[0] == ".endm" {
+		pp.macros[pp.def.name] = pp.def
+		pp.def = nil
+		return
+	}
+	if len(fs) > 0 && fs[0] == ".macro" {
+		sl.err = fmt.Errorf("macro cannot be defined in macro %s", pp.def.name)
+		pp.out = append(pp.out, sl)
+		return
+	}
+
+	pp.def.body = append(pp.def.body, sl)
+}
+
+func (pp *preprocessor) handle(sl srcLine, depth int) error {
+	fs := strings.Fields(stripComment(sl.text))
+	if len(fs) == 0 {
+		pp.out = append(pp.out, sl)
+		return nil
+	}
+
+	switch fs[0] {
+	case ".macro":
+		pp.startMacro(sl, fs)
+		return nil
+	case ".endm":
+		sl.err = fmt.Errorf(".endm without .macro")
+		pp.out = append(pp.out, sl)
+		return nil
+	case ".include":
+		return pp.include(sl, fs)
+	}
+
+	m, ok := pp.macros[fs[0]]
+	if !ok {
+		pp.out = append(pp.out, sl)
+		return nil
+	}
+	return pp.expand(m, sl, depth)
+}
+
+func (pp *preprocessor) startMacro(sl srcLine, fs []string) {
+	if len(fs) < 2 || !isSymbol(fs[1]) {
+		sl.err = fmt.Errorf("usage is .macro NAME [PARAM, ...]")
+		pp.out = append(pp.out, sl)
+		return
+	}
+	if _, ok := pp.macros[fs[1]]; ok {
+		sl.err = fmt.Errorf("macro %s is already defined", fs[1])
+		pp.out = append(pp.out, sl)
+		return
+	}
+
+	t := stripComment(sl.text)
+	pp.def = &macro{
+		name:   fs[1],
+		params: splitArgs(t[strings.Index(t, fs[1])+len(fs[1]):]),
+		no:     sl.no,
+	}
+	pp.defAt = sl
+}
+
+func (pp *preprocessor) include(sl srcLine, fs []string) error {
+	if len(fs) != 2 {
+		sl.err = fmt.Errorf("usage is .include \"FILE\"")
+		pp.out = append(pp.out, sl)
+		return nil
+	}
+
+	fn := filepath.Join(filepath.Dir(sl.file), strings.Trim(fs[1], `"`))
+	for _, f := range pp.files {
+		if f == fn {
+			sl.err = fmt.Errorf("%s includes itself", fn)
+			pp.out = append(pp.out, sl)
+			return nil
+		}
+	}
+
+	r, err := os.Open(fn)
+	if err != nil {
+		sl.err = err
+		pp.out = append(pp.out, sl)
+		return nil
+	}
+	defer r.Close()
+
+	return pp.run(fn, r, sl.top)
+}
+
+// expand writes out the body of m called by sl. Parameters are written as
+// \NAME in the body, and labels defined in the body are renamed to
+// NAME$LABEL$N for each expansion so they do not collide.
+func (pp *preprocessor) expand(m *macro, sl srcLine, depth int) error {
+	if depth >= maxExpansionDepth {
+		sl.err = fmt.Errorf("macro %s is expanded too deeply", m.name)
+		pp.out = append(pp.out, sl)
+		return nil
+	}
+
+	t := stripComment(sl.text)
+	args := splitArgs(t[strings.Index(t, m.name)+len(m.name):])
+	if len(args) != len(m.params) {
+		sl.err = fmt.Errorf("macro %s needs %d arguments but got %d", m.name, len(m.params), len(args))
+		pp.out = append(pp.out, sl)
+		return nil
+	}
+
+	pp.n++
+	r := m.replacer(args)
+	locals := m.labels()
+	call := &expansion{
+		name: m.name,
+		file: sl.file,
+		no:   sl.no,
+		col:  len(t) - len(strings.TrimLeft(t, " \t")) + 1,
+		call: sl.call,
+	}
+	for _, b := range m.body {
+		b.text = replaceSymbols(r.Replace(b.text), func(sym string) string {
+			if _, ok := locals[sym]; ok {
+				return fmt.Sprintf("%s$%s$%d", m.name, sym, pp.n)
+			}
+			return sym
+		})
+		b.top = sl.top
+		b.call = call
+		if err := pp.handle(b, depth+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *macro) replacer(args []string) *strings.Replacer {
+	ps := make([]int, len(m.params))
+	for i := range ps {
+		ps[i] = i
+	}
+	// longer names first so \AB is not taken as \A followed by B
+	sort.Slice(ps, func(i, j int) bool {
+		return len(m.params[ps[i]]) > len(m.params[ps[j]])
+	})
+
+	olds := []string{}
+	for _, i := range ps {
+		olds = append(olds, `\`+m.params[i], args[i])
+	}
+	return strings.NewReplacer(olds...)
+}
+
+func (m *macro) labels() map[string]struct{} {
+	ls := map[string]struct{}{}
+	for _, b := range m.body {
+		t := strings.TrimSpace(stripComment(b.text))
+		if strings.HasPrefix(t, "(") && strings.HasSuffix(t, ")") {
+			ls[t[1:len(t)-1]] = struct{}{}
+		}
+	}
+
+	return ls
+}
+
+func stripComment(l string) string {
+	if i := strings.Index(l, "//"); i != -1 {
+		return l[0:i]
+	}
+
+	return l
+}
+
+func splitArgs(s string) []string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+
+	args := []string{}
+	if strings.Contains(s, ",") {
+		for _, a := range strings.Split(s, ",") {
+			args = append(args, strings.TrimSpace(a))
+		}
+		return args
+	}
+
+	return strings.Fields(s)
+}
+
+// replaceSymbols replaces every whole symbol in s by the result of f.
+func replaceSymbols(s string, f func(sym string) string) string {
+	b := strings.Builder{}
+	for i := 0; i < len(s); {
+		if !isSymbolChar(rune(s[i])) {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+
+		j := i
+		for j < len(s) && isSymbolChar(rune(s[j])) {
+			j++
+		}
+		b.WriteString(f(s[i:j]))
+		i = j
+	}
+
+	return b.String()
+}
@@ -0,0 +1,143 @@
+package modules
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMacroExpansion(t *testing.T) {
+	src := `.macro LOOPN N
+@\N
+D=A
+(L)
+D=D-1
+@L
+D;JGT
+.endm
+LOOPN 3
+LOOPN 5
+`
+	got, err := assembleString(t, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint16{3, 0xEC10, 0xE390, 2, 0xE301, 5, 0xEC10, 0xE390, 7, 0xE301}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestMacroErrorsAreReportedAtCall(t *testing.T) {
+	tests := []struct {
+		src  string
+		line int
+		want string
+	}{
+		{".macro LOOPN N\n@\\N\nD=Y\n.endm\n\nLOOPN 3\n", 6, "in expansion of LOOPN (t.asm:3:3): invalid comp"},
+		{".macro INNER\nD=Y\n.endm\n.macro OUTER\n  INNER\n.endm\nOUTER\n", 7, "in expansion of OUTER (t.asm:5:3): in expansion of INNER (t.asm:2:3): invalid comp"},
+		{".macro TWO A, B\n.endm\nTWO 1\n", 3, "macro TWO needs 2 arguments but got 1"},
+		{".macro R\nR\n.endm\nR\n", 4, "is expanded too deeply"},
+	}
+	for _, tt := range tests {
+		_, err := assembleString(t, tt.src)
+		var errs ErrorList
+		if !errors.As(err, &errs) || len(errs) == 0 {
+			t.Errorf("%q: err = %v", tt.src, err)
+			continue
+		}
+		if e := errs[0]; e.File != "t.asm" || e.Line != tt.line || !strings.Contains(e.Err.Error(), tt.want) {
+			t.Errorf("%q: got %v, want t.asm:%d: %s", tt.src, e, tt.line, tt.want)
+		}
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.asm":     ".include \"lib/inc.asm\"\n@R0\nINC\n",
+		"lib/inc.asm":  ".include \"defs.asm\"\n.macro INC\n@ONE\n.endm\n",
+		"lib/defs.asm": ".equ ONE 1\nD=X\n",
+		"self.asm":     ".include \"self.asm\"\n",
+		"missing.asm":  ".include \"nothing.asm\"\n",
+	}
+	if err := os.Mkdir(filepath.Join(dir, "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for fn, s := range files {
+		if err := os.WriteFile(filepath.Join(dir, fn), []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	open := func(fn string) (*Result, error) {
+		f, err := os.Open(filepath.Join(dir, fn))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		return Assemble(f, nil, Options{File: filepath.Join(dir, fn)})
+	}
+
+	_, err := open("main.asm")
+	var errs ErrorList
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("err = %v, want the error of defs.asm", err)
+	}
+	if e := errs[0]; e.File != filepath.Join(dir, "lib/defs.asm") || e.Line != 2 {
+		t.Errorf("error at %s:%d, want lib/defs.asm:2", e.File, e.Line)
+	}
+
+	for fn, want := range map[string]string{"self.asm": "includes itself", "missing.asm": "no such file"} {
+		if _, err := open(fn); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: err = %v, want %q", fn, err, want)
+		}
+	}
+}
+
+func TestUnclosedMacroInInclude(t *testing.T) {
+	dir := t.TempDir()
+	inc := filepath.Join(dir, "inc.asm")
+	if err := os.WriteFile(inc, []byte("@1\n.macro INC\nM=M+1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	main := filepath.Join(dir, "main.asm")
+	if err := os.WriteFile(main, []byte(".include \"inc.asm\"\n@2\nD=X\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	_, err = Assemble(f, nil, Options{File: main})
+	var errs ErrorList
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("err = %v, want the unclosed macro and the error of main.asm", err)
+	}
+	if e := errs[0]; e.File != inc || e.Line != 2 || !strings.Contains(e.Error(), "macro INC is not closed by .endm") {
+		t.Errorf("error is %v, want it at inc.asm:2", e)
+	}
+	// The lines after the include are assembled, not taken into the macro.
+	if e := errs[1]; e.File != main || e.Line != 3 {
+		t.Errorf("error is %v, want it at main.asm:3", e)
+	}
+}
+
+func TestIncludedMacros(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "inc.asm"), []byte(".equ ONE 1\n.macro INC\n@ONE\nM=M+1\n.endm\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	main := filepath.Join(dir, "main.asm")
+	if err := os.WriteFile(main, []byte(".include \"inc.asm\"\nINC\nINC\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := assembleFile(t, main), []uint16{1, 0xFDC8, 1, 0xFDC8}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
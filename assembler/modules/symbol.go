@@ -0,0 +1,72 @@
+package modules
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+type (
+	// Symbol is a label or a variable the parser gave an address to.
+	Symbol struct {
+		Name    string     `json:"name"`
+		Kind    SymbolKind `json:"kind"`
+		Address uint16     `json:"address"`
+	}
+
+	SymbolKind int
+)
+
+const (
+	LABEL SymbolKind = iota
+	VARIABLE
+	CONSTANT
+)
+
+func (k SymbolKind) String() string {
+	switch k {
+	case LABEL:
+		return "label"
+	case VARIABLE:
+		return "variable"
+	case CONSTANT:
+		return "constant"
+	}
+
+	return "unknown"
+}
+
+func (k SymbolKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+func (k *SymbolKind) UnmarshalText(b []byte) error {
+	for _, c := range []SymbolKind{LABEL, VARIABLE, CONSTANT} {
+		if c.String() == string(b) {
+			*k = c
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown symbol kind %q", b)
+}
+
+// WriteSymbols writes symbols as a table of name, kind and address. Labels
+// are ROM addresses, variables are RAM addresses and constants are values.
+func WriteSymbols(w io.Writer, syms []*Symbol) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, s := range syms {
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%d\n", s.Name, s.Kind, s.Address); err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
+
+func WriteSymbolsJSON(w io.Writer, syms []*Symbol) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(syms)
+}
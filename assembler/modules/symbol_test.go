@@ -0,0 +1,98 @@
+package modules
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const symbolSrc = `.equ N 3
+@N
+D=A
+@i
+M=D
+(LOOP)
+@i
+MD=M-1
+@LOOP
+D;JGT
+@sum
+M=D
+`
+
+func TestSymbols(t *testing.T) {
+	res, err := Assemble(strings.NewReader(symbolSrc), nil, Options{File: "t.asm"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]Symbol{}
+	for _, s := range res.Symbols {
+		got[s.Name] = *s
+	}
+	want := map[string]Symbol{
+		"N":    {Name: "N", Kind: CONSTANT, Address: 3},
+		"LOOP": {Name: "LOOP", Kind: LABEL, Address: 4},
+		"i":    {Name: "i", Kind: VARIABLE, Address: 16},
+		"sum":  {Name: "sum", Kind: VARIABLE, Address: 17},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	b := &bytes.Buffer{}
+	if err := WriteSymbols(b, res.Symbols); err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []string{"LOOP  label", "i     variable  16", "sum   variable  17", "N     constant  3"} {
+		if !strings.Contains(b.String(), l) {
+			t.Errorf("no %q in the symbol map:\n%s", l, b)
+		}
+	}
+}
+
+func TestSymbolsJSON(t *testing.T) {
+	syms := []*Symbol{{Name: "LOOP", Kind: LABEL, Address: 4}, {Name: "i", Kind: VARIABLE, Address: 16}}
+	b := &bytes.Buffer{}
+	if err := WriteSymbolsJSON(b, syms); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `"kind": "variable"`) {
+		t.Errorf("kinds are not written by name:\n%s", b)
+	}
+
+	got := []*Symbol{}
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, syms) {
+		t.Errorf("got %v, want %v", got, syms)
+	}
+	if err := json.Unmarshal([]byte(`[{"kind": "macro"}]`), &got); err == nil {
+		t.Error("an unknown kind is read")
+	}
+}
+
+func TestListing(t *testing.T) {
+	src := ".macro INC2\nM=M+1\nM=M+1\n.endm\n@i // counter\nINC2\n"
+	res, err := Assemble(strings.NewReader(src), nil, Options{File: "t.asm"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &bytes.Buffer{}
+	if _, err := res.Listing.WriteTo(b); err != nil {
+		t.Fatal(err)
+	}
+	want := "" +
+		"                             1  .macro INC2\n" +
+		"                             2  M=M+1\n" +
+		"                             3  M=M+1\n" +
+		"                             4  .endm\n" +
+		"    0  0000000000010000      5  @i // counter\n" +
+		"    1  1111110111001000      6  INC2\n" +
+		"    2  1111110111001000\n"
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b, want)
+	}
+}
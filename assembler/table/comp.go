@@ -2,77 +2,88 @@ package table
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/terashin777/assembler/utils"
 )
 
-var Comp comp = comp{}
+var Comp comp = comp{
+	bins: map[string]string{
+		"0":   "0101010",
+		"1":   "0111111",
+		"-1":  "0111010",
+		"D":   "0001100",
+		"A":   "0110000",
+		"M":   "1110000",
+		"!D":  "0001101",
+		"!A":  "0110001",
+		"!M":  "1110001",
+		"-D":  "0001111",
+		"-A":  "0110011",
+		"-M":  "1110011",
+		"D+1": "0011111",
+		"A+1": "0110111",
+		"M+1": "1110111",
+		"D-1": "0001110",
+		"A-1": "0110010",
+		"M-1": "1110010",
+		"D+A": "0000010",
+		"D+M": "1000010",
+		"D-A": "0010011",
+		"D-M": "1010011",
+		"A-D": "0000111",
+		"M-D": "1000111",
+		"D&A": "0000000",
+		"D&M": "1000000",
+		"D|A": "0010101",
+		"D|M": "1010101",
+	},
+}
 
-type comp struct{}
+type comp struct {
+	bins map[string]string
+}
 
 func (t comp) Name() string {
 	return "comp"
 }
 
+// Normalize returns the spelling ToBinary knows for mn. It drops white space
+// and swaps the operands of commutative operations, so A + D becomes D+A.
+func (t comp) Normalize(mn string) string {
+	mn = strings.Join(strings.Fields(mn), "")
+	if _, ok := t.bins[mn]; ok {
+		return mn
+	}
+
+	for _, op := range "+&|" {
+		if i := strings.IndexRune(mn, op); i > 0 {
+			sw := mn[i+1:] + string(op) + mn[:i]
+			if _, ok := t.bins[sw]; ok {
+				return sw
+			}
+		}
+	}
+
+	return mn
+}
+
 func (t comp) ToBinary(mn string) (byte, error) {
-	switch mn {
-	case "0":
-		return utils.StringUtil.ToBinaryNoError("0101010"), nil
-	case "1":
-		return utils.StringUtil.ToBinaryNoError("0111111"), nil
-	case "-1":
-		return utils.StringUtil.ToBinaryNoError("0111010"), nil
-	case "D":
-		return utils.StringUtil.ToBinaryNoError("0001100"), nil
-	case "A":
-		return utils.StringUtil.ToBinaryNoError("0110000"), nil
-	case "M":
-		return utils.StringUtil.ToBinaryNoError("1110000"), nil
-	case "!D":
-		return utils.StringUtil.ToBinaryNoError("0001101"), nil
-	case "!A":
-		return utils.StringUtil.ToBinaryNoError("0110001"), nil
-	case "!M":
-		return utils.StringUtil.ToBinaryNoError("1110001"), nil
-	case "-D":
-		return utils.StringUtil.ToBinaryNoError("0001111"), nil
-	case "-A":
-		return utils.StringUtil.ToBinaryNoError("0110011"), nil
-	case "-M":
-		return utils.StringUtil.ToBinaryNoError("1110011"), nil
-	case "D+1":
-		return utils.StringUtil.ToBinaryNoError("0011111"), nil
-	case "A+1":
-		return utils.StringUtil.ToBinaryNoError("0110111"), nil
-	case "M+1":
-		return utils.StringUtil.ToBinaryNoError("1110111"), nil
-	case "D-1":
-		return utils.StringUtil.ToBinaryNoError("0001110"), nil
-	case "A-1":
-		return utils.StringUtil.ToBinaryNoError("0110010"), nil
-	case "M-1":
-		return utils.StringUtil.ToBinaryNoError("1110010"), nil
-	case "D+A":
-		return utils.StringUtil.ToBinaryNoError("0000010"), nil
-	case "D+M":
-		return utils.StringUtil.ToBinaryNoError("1000010"), nil
-	case "D-A":
-		return utils.StringUtil.ToBinaryNoError("0010011"), nil
-	case "D-M":
-		return utils.StringUtil.ToBinaryNoError("1010011"), nil
-	case "A-D":
-		return utils.StringUtil.ToBinaryNoError("0000111"), nil
-	case "M-D":
-		return utils.StringUtil.ToBinaryNoError("1000111"), nil
-	case "D&A":
-		return utils.StringUtil.ToBinaryNoError("0000000"), nil
-	case "D&M":
-		return utils.StringUtil.ToBinaryNoError("1000000"), nil
-	case "D|A":
-		return utils.StringUtil.ToBinaryNoError("0010101"), nil
-	case "D|M":
-		return utils.StringUtil.ToBinaryNoError("1010101"), nil
+	b, ok := t.bins[t.Normalize(mn)]
+	if !ok {
+		return 0, fmt.Errorf("invalid %s", t.Name())
+	}
+
+	return utils.StringUtil.ToBinaryNoError(b), nil
+}
+
+// ToMnemonic is the reverse of ToBinary.
+func (t comp) ToMnemonic(b byte) (string, error) {
+	for mn, bin := range t.bins {
+		if utils.StringUtil.ToBinaryNoError(bin) == b {
+			return mn, nil
+		}
 	}
 
-	return 0, fmt.Errorf("not match")
+	return "", fmt.Errorf("invalid %s", t.Name())
 }
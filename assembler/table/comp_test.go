@@ -0,0 +1,67 @@
+package table
+
+import (
+	"testing"
+)
+
+func TestCompNormalize(t *testing.T) {
+	tests := map[string]string{
+		"D+A":   "D+A",
+		"A+D":   "D+A",
+		"M + D": "D+M",
+		"1+D":   "D+1",
+		"1+M":   "M+1",
+		"A&D":   "D&A",
+		"M|D":   "D|M",
+		" ! D ": "!D",
+		"A-D":   "A-D",
+		"D-A":   "D-A",
+		"1-D":   "1-D",
+	}
+	for mn, want := range tests {
+		if got := Comp.Normalize(mn); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", mn, got, want)
+		}
+	}
+	if _, err := Comp.ToBinary("1-D"); err == nil {
+		t.Error("subtraction is swapped")
+	}
+}
+
+func TestDestNormalize(t *testing.T) {
+	tests := map[string]string{
+		"MD":  "MD",
+		"DM":  "MD",
+		"DA":  "AD",
+		"DMA": "AMD",
+		"MAD": "AMD",
+		"DD":  "DD",
+		"X":   "X",
+	}
+	for mn, want := range tests {
+		if got := Dest.Normalize(mn); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", mn, got, want)
+		}
+	}
+}
+
+func TestMnemonicsRoundTrip(t *testing.T) {
+	for mn := range Comp.bins {
+		b, err := Comp.ToBinary(mn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := Comp.ToMnemonic(b); err != nil || got != mn {
+			t.Errorf("comp %s: got %q %v", mn, got, err)
+		}
+	}
+	for mn := range Shift.bins {
+		b, err := Shift.ToBinary(mn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := Shift.ToMnemonic(b); err != nil || got != mn {
+			t.Errorf("shift %s: got %q %v", mn, got, err)
+		}
+	}
+}
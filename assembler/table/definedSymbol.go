@@ -17,3 +17,23 @@ func init() {
 		DefinedSymbolTable[fmt.Sprintf("R%d", i)] = uint16(i)
 	}
 }
+
+// DefinedSymbolName returns the predefined symbol for the address v. Named
+// registers such as SP are preferred to their Rn aliases.
+func DefinedSymbolName(v uint16) (string, bool) {
+	n := ""
+	for k, a := range DefinedSymbolTable {
+		if a != v {
+			continue
+		}
+		if n == "" || isRegisterAlias(n) && !isRegisterAlias(k) {
+			n = k
+		}
+	}
+
+	return n, n != ""
+}
+
+func isRegisterAlias(n string) bool {
+	return len(n) > 1 && n[0] == 'R' && '0' <= n[1] && n[1] <= '9'
+}
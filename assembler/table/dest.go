@@ -2,37 +2,71 @@ package table
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/terashin777/assembler/utils"
 )
 
-var Dest dest = dest{}
+var Dest dest = dest{
+	bins: map[string]string{
+		"":    "000",
+		"M":   "001",
+		"D":   "010",
+		"MD":  "011",
+		"A":   "100",
+		"AM":  "101",
+		"AD":  "110",
+		"AMD": "111",
+	},
+}
 
-type dest struct{}
+type dest struct {
+	bins map[string]string
+}
 
 func (t dest) Name() string {
 	return "dest"
 }
 
+// Normalize returns the spelling ToBinary knows for mn. It drops white space
+// and puts the registers in the order A, M, D, so DM becomes MD.
+func (t dest) Normalize(mn string) string {
+	mn = strings.Join(strings.Fields(mn), "")
+	if _, ok := t.bins[mn]; ok {
+		return mn
+	}
+
+	n := ""
+	for _, r := range "AMD" {
+		if c := strings.Count(mn, string(r)); c > 1 {
+			return mn
+		} else if c == 1 {
+			n += string(r)
+		}
+	}
+	if len(n) != len(mn) {
+		return mn
+	}
+
+	return n
+}
+
 func (t dest) ToBinary(mn string) (byte, error) {
-	switch mn {
-	case "":
-		return utils.StringUtil.ToBinaryNoError("000"), nil
-	case "M":
-		return utils.StringUtil.ToBinaryNoError("001"), nil
-	case "D":
-		return utils.StringUtil.ToBinaryNoError("010"), nil
-	case "MD":
-		return utils.StringUtil.ToBinaryNoError("011"), nil
-	case "A":
-		return utils.StringUtil.ToBinaryNoError("100"), nil
-	case "AM":
-		return utils.StringUtil.ToBinaryNoError("101"), nil
-	case "AD":
-		return utils.StringUtil.ToBinaryNoError("110"), nil
-	case "AMD":
-		return utils.StringUtil.ToBinaryNoError("111"), nil
+	b, ok := t.bins[t.Normalize(mn)]
+	if !ok {
+		return 0, fmt.Errorf("invalid %s", t.Name())
+	}
+
+	return utils.StringUtil.ToBinaryNoError(b), nil
+}
+
+// ToMnemonic is the reverse of ToBinary.
+func (t dest) ToMnemonic(b byte) (string, error) {
+	for mn, bin := range t.bins {
+		if utils.StringUtil.ToBinaryNoError(bin) == b {
+			return mn, nil
+		}
 	}
 
-	return 0, fmt.Errorf("not match")
+	return "", fmt.Errorf("invalid %s", t.Name())
 }
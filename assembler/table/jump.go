@@ -2,37 +2,48 @@ package table
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/terashin777/assembler/utils"
 )
 
-var Jump jump = jump{}
+var Jump jump = jump{
+	bins: map[string]string{
+		"":    "000",
+		"JGT": "001",
+		"JEQ": "010",
+		"JGE": "011",
+		"JLT": "100",
+		"JNE": "101",
+		"JLE": "110",
+		"JMP": "111",
+	},
+}
 
-type jump struct{}
+type jump struct {
+	bins map[string]string
+}
 
 func (t jump) Name() string {
 	return "jump"
 }
 
 func (t jump) ToBinary(mn string) (byte, error) {
-	switch mn {
-	case "":
-		return utils.StringUtil.ToBinaryNoError("000"), nil
-	case "JGT":
-		return utils.StringUtil.ToBinaryNoError("001"), nil
-	case "JEQ":
-		return utils.StringUtil.ToBinaryNoError("010"), nil
-	case "JGE":
-		return utils.StringUtil.ToBinaryNoError("011"), nil
-	case "JLT":
-		return utils.StringUtil.ToBinaryNoError("100"), nil
-	case "JNE":
-		return utils.StringUtil.ToBinaryNoError("101"), nil
-	case "JLE":
-		return utils.StringUtil.ToBinaryNoError("110"), nil
-	case "JMP":
-		return utils.StringUtil.ToBinaryNoError("111"), nil
+	b, ok := t.bins[strings.TrimSpace(mn)]
+	if !ok {
+		return 0, fmt.Errorf("invalid %s", t.Name())
+	}
+
+	return utils.StringUtil.ToBinaryNoError(b), nil
+}
+
+// ToMnemonic is the reverse of ToBinary.
+func (t jump) ToMnemonic(b byte) (string, error) {
+	for mn, bin := range t.bins {
+		if utils.StringUtil.ToBinaryNoError(bin) == b {
+			return mn, nil
+		}
 	}
 
-	return 0, fmt.Errorf("not match")
+	return "", fmt.Errorf("invalid %s", t.Name())
 }
@@ -0,0 +1,59 @@
+package table
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/terashin777/assembler/utils"
+)
+
+// ISA profiles the assembler accepts. The extended profile adds the shift
+// instructions of the extended Hack ALU, which are C-instructions starting
+// with 101 instead of 111.
+const (
+	ISA_HACK     = "hack"
+	ISA_EXTENDED = "extended"
+)
+
+var ISAs = []string{ISA_HACK, ISA_EXTENDED}
+
+// Shift is the comp table of the shift instructions. << shifts left and >>
+// shifts right keeping the sign.
+var Shift shift = shift{
+	bins: map[string]string{
+		"D<<": "0110000",
+		"A<<": "0100000",
+		"M<<": "1100000",
+		"D>>": "0010000",
+		"A>>": "0000000",
+		"M>>": "1000000",
+	},
+}
+
+type shift struct {
+	bins map[string]string
+}
+
+func (t shift) Name() string {
+	return "shift"
+}
+
+func (t shift) ToBinary(mn string) (byte, error) {
+	b, ok := t.bins[strings.Join(strings.Fields(mn), "")]
+	if !ok {
+		return 0, fmt.Errorf("invalid %s", t.Name())
+	}
+
+	return utils.StringUtil.ToBinaryNoError(b), nil
+}
+
+// ToMnemonic is the reverse of ToBinary.
+func (t shift) ToMnemonic(b byte) (string, error) {
+	for mn, bin := range t.bins {
+		if utils.StringUtil.ToBinaryNoError(bin) == b {
+			return mn, nil
+		}
+	}
+
+	return "", fmt.Errorf("invalid %s", t.Name())
+}
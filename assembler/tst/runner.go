@@ -0,0 +1,498 @@
+package tst
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/terashin777/assembler/cpu"
+)
+
+type (
+	// CompareError tells the first output line that differs from the compare
+	// file. File is the compare file and Line is the line of it, which is
+	// also the line of the output.
+	CompareError struct {
+		File     string
+		Line     int
+		Expected string
+		Actual   string
+	}
+
+	// ScriptError is an error of a command in a script.
+	ScriptError struct {
+		File    string
+		Line    int
+		Command string
+		Err     error
+	}
+
+	// Result is the outcome of a script that ran to the end.
+	Result struct {
+		Out      string
+		Compared bool
+		Lines    int
+	}
+
+	runner struct {
+		path string
+		dir  string
+		c    *cpu.CPU
+		out  *os.File
+		w    *bufio.Writer
+		cmp  []string
+		// cmpPath is the compare file cmp was read from.
+		cmpPath string
+		list    []*outputVar
+		res     *Result
+	}
+
+	outputVar struct {
+		name  string
+		f     byte
+		left  int
+		width int
+		right int
+	}
+)
+
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("%s:%d: %s: %s", e.File, e.Line, e.Command, e.Err)
+}
+
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
+
+func (e *CompareError) Error() string {
+	return fmt.Sprintf("comparison failure at line %d of %s: expected %q but got %q", e.Line, e.File, e.Expected, e.Actual)
+}
+
+// Run runs the test script at path. Files named in the script are relative to
+// its directory. A *CompareError is returned at the first output line that
+// differs from the compare-to file.
+func Run(path string) (*Result, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cs, err := parse(string(src))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	r := &runner{
+		path: path,
+		dir:  filepath.Dir(path),
+		c:    cpu.NewCPU(),
+		res:  &Result{},
+	}
+	defer r.close()
+
+	err = r.exec(cs)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.close(); err != nil {
+		return nil, err
+	}
+
+	return r.res, nil
+}
+
+func (r *runner) close() error {
+	if r.out == nil {
+		return nil
+	}
+
+	err := r.w.Flush()
+	if cerr := r.out.Close(); err == nil {
+		err = cerr
+	}
+	r.out = nil
+	return err
+}
+
+func (r *runner) exec(cs []*command) error {
+	for _, c := range cs {
+		err := r.execCommand(c)
+		var ce *CompareError
+		var se *ScriptError
+		if errors.As(err, &ce) || errors.As(err, &se) {
+			return err
+		}
+		if err != nil {
+			return &ScriptError{
+				File:    r.path,
+				Line:    c.line,
+				Command: c.name,
+				Err:     err,
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *runner) execCommand(c *command) error {
+	switch c.name {
+	case "load":
+		if len(c.args) != 1 {
+			return fmt.Errorf("needs a file")
+		}
+		return r.c.LoadFile(filepath.Join(r.dir, c.args[0]))
+	case "output-file":
+		if len(c.args) != 1 {
+			return fmt.Errorf("needs a file")
+		}
+		return r.openOutput(c.args[0])
+	case "compare-to":
+		if len(c.args) != 1 {
+			return fmt.Errorf("needs a file")
+		}
+		return r.readCompare(c.args[0])
+	case "output-list":
+		return r.setOutputList(c.args)
+	case "set":
+		if len(c.args) != 2 {
+			return fmt.Errorf("needs a variable and a value")
+		}
+		return r.set(c.args[0], c.args[1])
+	case "ticktock", "tock":
+		r.c.Step()
+	case "tick", "echo", "clear-echo", "breakpoint", "clear-breakpoints":
+	case "output":
+		return r.output()
+	case "repeat":
+		return r.repeat(c)
+	case "while":
+		return r.while(c)
+	default:
+		return fmt.Errorf("unknown command")
+	}
+
+	return nil
+}
+
+// repeat runs its block the given times. Without a count it runs until the
+// program halts, as there is nobody to stop it.
+func (r *runner) repeat(c *command) error {
+	if c.body == nil || len(c.args) > 1 {
+		return fmt.Errorf("needs a count and a block")
+	}
+	if len(c.args) == 0 {
+		for !r.c.Halted() {
+			if err := r.exec(c.body); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	n, err := strconv.Atoi(c.args[0])
+	if err != nil {
+		return fmt.Errorf("invalid count %q", c.args[0])
+	}
+
+	for i := 0; i < n; i++ {
+		if err := r.exec(c.body); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *runner) while(c *command) error {
+	if c.body == nil || len(c.args) != 3 {
+		return fmt.Errorf("needs a condition and a block")
+	}
+
+	for {
+		ok, err := r.cond(c.args[0], c.args[1], c.args[2])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		if err := r.exec(c.body); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *runner) cond(name, op, value string) (bool, error) {
+	x, err := r.get(name)
+	if err != nil {
+		return false, err
+	}
+	y, err := parseValue(value)
+	if err != nil {
+		return false, err
+	}
+
+	a, b := int16(x), int16(y)
+	switch op {
+	case "=":
+		return a == b, nil
+	case "<>":
+		return a != b, nil
+	case "<":
+		return a < b, nil
+	case ">":
+		return a > b, nil
+	case "<=":
+		return a <= b, nil
+	case ">=":
+		return a >= b, nil
+	}
+
+	return false, fmt.Errorf("unknown operator %q", op)
+}
+
+func (r *runner) openOutput(fn string) error {
+	if err := r.close(); err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(r.dir, fn))
+	if err != nil {
+		return err
+	}
+	r.out = f
+	r.w = bufio.NewWriter(f)
+	r.res.Out = f.Name()
+	return nil
+}
+
+func (r *runner) readCompare(fn string) error {
+	path := filepath.Join(r.dir, fn)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	r.cmpPath = path
+	r.cmp = strings.Split(strings.ReplaceAll(string(b), "\r\n", "\n"), "\n")
+	r.res.Compared = true
+	return nil
+}
+
+func (r *runner) setOutputList(args []string) error {
+	r.list = nil
+	for _, a := range args {
+		v, err := parseOutputVar(a)
+		if err != nil {
+			return err
+		}
+		r.list = append(r.list, v)
+	}
+
+	return r.writeLine(r.header())
+}
+
+func (r *runner) header() string {
+	b := strings.Builder{}
+	for _, v := range r.list {
+		space := v.left + v.width + v.right
+		n := v.name
+		if len(n) > space {
+			n = n[:space]
+		}
+		l := (space - len(n)) / 2
+		b.WriteString("|" + strings.Repeat(" ", l) + n + strings.Repeat(" ", space-l-len(n)))
+	}
+	b.WriteString("|")
+
+	return b.String()
+}
+
+func (r *runner) output() error {
+	b := strings.Builder{}
+	for _, v := range r.list {
+		x, err := r.get(v.name)
+		if err != nil {
+			return err
+		}
+		b.WriteString("|" + strings.Repeat(" ", v.left) + v.format(x) + strings.Repeat(" ", v.right))
+	}
+	b.WriteString("|")
+
+	return r.writeLine(b.String())
+}
+
+func (r *runner) writeLine(l string) error {
+	if r.out == nil {
+		return fmt.Errorf("output-file is not set")
+	}
+
+	r.res.Lines++
+	if _, err := r.w.WriteString(l + "\n"); err != nil {
+		return err
+	}
+
+	if !r.res.Compared {
+		return nil
+	}
+	exp := ""
+	if r.res.Lines <= len(r.cmp) {
+		exp = r.cmp[r.res.Lines-1]
+	}
+	if exp != l {
+		return &CompareError{
+			File:     r.cmpPath,
+			Line:     r.res.Lines,
+			Expected: exp,
+			Actual:   l,
+		}
+	}
+
+	return nil
+}
+
+func (r *runner) get(name string) (uint16, error) {
+	switch name {
+	case "A":
+		return r.c.A, nil
+	case "D":
+		return r.c.D, nil
+	case "PC":
+		return r.c.PC, nil
+	case "time":
+		return uint16(r.c.Cycles), nil
+	}
+
+	mem, a, err := parseMemory(name)
+	if err != nil {
+		return 0, err
+	}
+	if mem == "ROM" {
+		return r.c.ROM(a), nil
+	}
+	return r.c.Peek(a), nil
+}
+
+func (r *runner) set(name, value string) error {
+	v, err := parseValue(value)
+	if err != nil {
+		return err
+	}
+
+	switch name {
+	case "A":
+		r.c.A = v
+		return nil
+	case "D":
+		r.c.D = v
+		return nil
+	case "PC":
+		r.c.PC = v
+		return nil
+	}
+
+	mem, a, err := parseMemory(name)
+	if err != nil {
+		return err
+	}
+	if mem == "ROM" {
+		return fmt.Errorf("ROM is read only")
+	}
+	r.c.Poke(a, v)
+	return nil
+}
+
+// parseMemory parses names like RAM[16] or ROM[3].
+func parseMemory(name string) (string, uint16, error) {
+	i := strings.IndexByte(name, '[')
+	if i == -1 || !strings.HasSuffix(name, "]") {
+		return "", 0, fmt.Errorf("unknown variable %q", name)
+	}
+
+	mem := name[:i]
+	if mem != "RAM" && mem != "ROM" {
+		return "", 0, fmt.Errorf("unknown variable %q", name)
+	}
+	a, err := strconv.ParseUint(name[i+1:len(name)-1], 10, 16)
+	if err != nil || a >= cpu.RAMSize {
+		return "", 0, fmt.Errorf("invalid address in %q", name)
+	}
+
+	return mem, uint16(a), nil
+}
+
+// parseValue parses decimal values and values prefixed with %B, %X or %D.
+func parseValue(s string) (uint16, error) {
+	base := 10
+	if len(s) > 2 && s[0] == '%' {
+		switch s[1] {
+		case 'B':
+			base = 2
+		case 'X':
+			base = 16
+		case 'D':
+		default:
+			return 0, fmt.Errorf("invalid value %q", s)
+		}
+		s = s[2:]
+	}
+
+	v, err := strconv.ParseInt(s, base, 32)
+	if err != nil || v < -32768 || v > 65535 {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+
+	return uint16(v), nil
+}
+
+// parseOutputVar parses name%FL.W.R where F is the format and L, W and R are
+// the left padding, the width and the right padding.
+func parseOutputVar(s string) (*outputVar, error) {
+	v := &outputVar{name: s, f: 'B', left: 1, width: 16, right: 1}
+	i := strings.IndexByte(s, '%')
+	if i == -1 {
+		return v, nil
+	}
+
+	v.name = s[:i]
+	spec := s[i+1:]
+	if spec == "" || !strings.ContainsRune("BDXS", rune(spec[0])) {
+		return nil, fmt.Errorf("invalid format %q", s)
+	}
+	v.f = spec[0]
+	ns := strings.Split(spec[1:], ".")
+	if len(ns) != 3 {
+		return nil, fmt.Errorf("invalid format %q", s)
+	}
+	for i, p := range []*int{&v.left, &v.width, &v.right} {
+		n, err := strconv.Atoi(ns[i])
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid format %q", s)
+		}
+		*p = n
+	}
+
+	return v, nil
+}
+
+func (v *outputVar) format(x uint16) string {
+	s := ""
+	switch v.f {
+	case 'B':
+		s = fmt.Sprintf("%0*b", v.width, x)
+	case 'X':
+		s = fmt.Sprintf("%0*X", v.width, x)
+	case 'S':
+		s = fmt.Sprintf("%-*d", v.width, int16(x))
+	default:
+		s = fmt.Sprintf("%*d", v.width, int16(x))
+	}
+	if len(s) > v.width {
+		s = s[len(s)-v.width:]
+	}
+
+	return s
+}
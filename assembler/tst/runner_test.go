@@ -0,0 +1,110 @@
+package tst
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const addAsm = "@R0\nD=M\n@R1\nD=D+M\n@R2\nM=D\n(END)\n@END\n0;JMP\n"
+
+const addTst = `load Add.asm,
+output-file Add.out,
+compare-to Add.cmp,
+output-list RAM[0]%D2.6.2 RAM[1]%D2.6.2 RAM[2]%D2.6.2;
+
+set RAM[0] 2,
+set RAM[1] 3;
+repeat 6 {
+  ticktock;
+}
+output;
+
+set PC 0,
+set RAM[0] -1,
+set RAM[1] 1;
+repeat 6 {
+  ticktock;
+}
+output;
+`
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for fn, s := range files {
+		if err := os.WriteFile(filepath.Join(dir, fn), []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestRunComparesOutput(t *testing.T) {
+	cmp := "|  RAM[0]  |  RAM[1]  |  RAM[2]  |\n|       2  |       3  |       5  |\n|      -1  |       1  |       0  |\n"
+	dir := writeFiles(t, map[string]string{"Add.asm": addAsm, "Add.tst": addTst, "Add.cmp": cmp})
+
+	res, err := Run(filepath.Join(dir, "Add.tst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Compared || res.Lines != 3 {
+		t.Errorf("compared %v %d lines, want 3", res.Compared, res.Lines)
+	}
+	out, err := os.ReadFile(filepath.Join(dir, "Add.out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != cmp {
+		t.Errorf("output is\n%s\nwant\n%s", out, cmp)
+	}
+}
+
+func TestRunMult(t *testing.T) {
+	files := map[string]string{}
+	for _, fn := range []string{"Mult.asm", "Mult.tst", "Mult.cmp"} {
+		b, err := os.ReadFile(filepath.Join("../../projects/04/mult", fn))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[fn] = string(b)
+	}
+	dir := writeFiles(t, files)
+
+	res, err := Run(filepath.Join(dir, "Mult.tst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Compared || res.Lines < 2 {
+		t.Errorf("compared %v %d lines", res.Compared, res.Lines)
+	}
+}
+
+func TestRunReportsCompareLine(t *testing.T) {
+	cmp := "|  RAM[0]  |  RAM[1]  |  RAM[2]  |\n|       2  |       3  |       5  |\n|      -1  |       1  |       1  |\n"
+	dir := writeFiles(t, map[string]string{"Add.asm": addAsm, "Add.tst": addTst, "Add.cmp": cmp})
+
+	_, err := Run(filepath.Join(dir, "Add.tst"))
+	var ce *CompareError
+	if !errors.As(err, &ce) {
+		t.Fatalf("err = %v, want a *CompareError", err)
+	}
+	if ce.File != filepath.Join(dir, "Add.cmp") || ce.Line != 3 {
+		t.Errorf("failure at %s:%d, want Add.cmp:3", ce.File, ce.Line)
+	}
+	if ce.Expected != "|      -1  |       1  |       1  |" || ce.Actual != "|      -1  |       1  |       0  |" {
+		t.Errorf("expected %q, got %q", ce.Expected, ce.Actual)
+	}
+}
+
+func TestRunReportsScriptLine(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"Add.asm": addAsm, "Bad.tst": "load Add.asm;\nset RAM[0] 1,\nfrobnicate;\n"})
+
+	_, err := Run(filepath.Join(dir, "Bad.tst"))
+	var se *ScriptError
+	if !errors.As(err, &se) || se.Line != 3 || se.Command != "frobnicate" {
+		t.Errorf("err = %v, want an error at line 3", err)
+	}
+}
@@ -0,0 +1,137 @@
+package tst
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type (
+	// command is a statement of a test script. repeat and while have a body.
+	command struct {
+		name string
+		args []string
+		body []*command
+		line int
+	}
+
+	token struct {
+		s    string
+		line int
+	}
+)
+
+const terminators = ",;!"
+
+func tokenize(src string) ([]token, error) {
+	ts := []token{}
+	line := 1
+	rs := []rune(src)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case r == '\n':
+			line++
+		case unicode.IsSpace(r):
+		case r == '/' && i+1 < len(rs) && rs[i+1] == '/':
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+			i--
+		case r == '/' && i+1 < len(rs) && rs[i+1] == '*':
+			start := line
+			i += 2
+			for ; i+1 < len(rs) && !(rs[i] == '*' && rs[i+1] == '/'); i++ {
+				if rs[i] == '\n' {
+					line++
+				}
+			}
+			if i+1 >= len(rs) {
+				return nil, fmt.Errorf("line %d: comment is not closed", start)
+			}
+			i++
+		case r == '"':
+			j := i + 1
+			for j < len(rs) && rs[j] != '"' && rs[j] != '\n' {
+				j++
+			}
+			if j >= len(rs) || rs[j] != '"' {
+				return nil, fmt.Errorf("line %d: string is not closed", line)
+			}
+			ts = append(ts, token{s: string(rs[i : j+1]), line: line})
+			i = j
+		case strings.ContainsRune(terminators+"{}", r):
+			ts = append(ts, token{s: string(r), line: line})
+		default:
+			j := i
+			for j < len(rs) && !unicode.IsSpace(rs[j]) && !strings.ContainsRune(terminators+"{}", rs[j]) {
+				j++
+			}
+			ts = append(ts, token{s: string(rs[i:j]), line: line})
+			i = j - 1
+		}
+	}
+
+	return ts, nil
+}
+
+func parse(src string) ([]*command, error) {
+	ts, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+
+	cs, i, err := parseBlock(ts, 0)
+	if err != nil {
+		return nil, err
+	}
+	if i < len(ts) {
+		return nil, fmt.Errorf("line %d: unexpected %q", ts[i].line, ts[i].s)
+	}
+
+	return cs, nil
+}
+
+// parseBlock parses commands from ts[i] until "}" or the end and returns the
+// index of the token it stopped at.
+func parseBlock(ts []token, i int) ([]*command, int, error) {
+	cs := []*command{}
+	for i < len(ts) && ts[i].s != "}" {
+		t := ts[i]
+		if isDelimiter(t.s) {
+			return nil, 0, fmt.Errorf("line %d: unexpected %q", t.line, t.s)
+		}
+
+		c := &command{name: t.s, line: t.line}
+		for i++; i < len(ts) && !isDelimiter(ts[i].s); i++ {
+			c.args = append(c.args, ts[i].s)
+		}
+		if i >= len(ts) {
+			return nil, 0, fmt.Errorf("line %d: %s is not terminated", t.line, c.name)
+		}
+
+		if ts[i].s == "{" {
+			body, j, err := parseBlock(ts, i+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			if j >= len(ts) {
+				return nil, 0, fmt.Errorf("line %d: block of %s is not closed", t.line, c.name)
+			}
+			c.body = body
+			i = j
+		}
+		cs = append(cs, c)
+		if ts[i].s == "}" && c.body == nil {
+			// the last command in a block may omit its terminator
+			continue
+		}
+		i++
+	}
+
+	return cs, i, nil
+}
+
+func isDelimiter(s string) bool {
+	return len(s) == 1 && strings.Contains(terminators+"{}", s)
+}
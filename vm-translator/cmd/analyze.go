@@ -0,0 +1,155 @@
+/*
+Copyright © 2021 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/terashin777/vm-translator/modules"
+)
+
+var format string
+
+const (
+	FORMAT_TEXT = "text"
+	FORMAT_DOT  = "dot"
+	FORMAT_JSON = "json"
+)
+
+// analyzeCmd represents the analyze command
+var analyzeCmd = &cobra.Command{
+	Use:   "analyze [file]",
+	Short: "analyze the calls and the stack of your vm code",
+	Long: `analyze the calls and the stack of your vm code.
+A directory analyzes all of its .vm files. It prints the call graph as a table, Graphviz DOT or JSON.
+The depth is the most values a function has on its operand stack. The stack is the most words a call of it takes with the functions it calls, so the stack of Sys.init is what the program needs above 256.
+Paths that pop an empty stack or return with it are reported and fail the command. Recursion is warned about.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		n, err := analyze(args[0])
+		var errs modules.ErrorList
+		if errors.As(err, &errs) {
+			for _, e := range errs {
+				fmt.Fprintln(os.Stderr, e)
+			}
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Printf("analyze is failed because: %s\n", err)
+			os.Exit(1)
+		}
+		if n > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(analyzeCmd)
+
+	analyzeCmd.Flags().StringVarP(&format, "format", "f", FORMAT_TEXT, "text, dot or json")
+}
+
+// analyze prints the analysis of src and returns how many problems it found.
+func analyze(src string) (int, error) {
+	switch format {
+	case FORMAT_TEXT, FORMAT_DOT, FORMAT_JSON:
+	default:
+		return 0, fmt.Errorf("invalid format %s", format)
+	}
+
+	fns, _, err := getFiles(src)
+	if err != nil {
+		return 0, err
+	}
+	p, err := modules.NewParser(fns)
+	if err != nil {
+		return 0, err
+	}
+	prog, err := modules.ReadProgram(p)
+	if err != nil {
+		return 0, err
+	}
+
+	a := modules.Analyze(prog)
+	switch format {
+	case FORMAT_TEXT:
+		printAnalysis(a)
+	case FORMAT_DOT:
+		err = a.WriteDOT(os.Stdout)
+	case FORMAT_JSON:
+		err = a.WriteJSON(os.Stdout)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	for _, c := range a.Cycles {
+		if len(c) == 1 {
+			fmt.Fprintf(os.Stderr, "warning: %s calls itself\n", c[0])
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "warning: recursion among %s\n", strings.Join(c, ", "))
+	}
+	for _, i := range a.Issues {
+		fmt.Fprintln(os.Stderr, i)
+	}
+
+	return len(a.Issues), nil
+}
+
+func printAnalysis(a *modules.Analysis) {
+	width := len("function")
+	for _, f := range a.Functions {
+		if len(f.Name) > width {
+			width = len(f.Name)
+		}
+	}
+
+	calls := map[string]int{}
+	for _, e := range a.Calls {
+		calls[e.From]++
+	}
+	fmt.Printf("%-*s %7s %9s %9s %7s\n", width, "function", "locals", "depth", "stack", "calls")
+	for _, f := range a.Functions {
+		fmt.Printf("%-*s %7d %9s %9s %7d\n", width, f.Name, f.Locals, sizeString(f.MaxDepth), sizeString(f.Stack), calls[f.Name])
+	}
+	for _, f := range a.Functions {
+		if f.Name == "Sys.init" {
+			if f.Stack == modules.Unbounded {
+				fmt.Println("stack above 256: unbounded")
+			} else {
+				fmt.Printf("stack above 256: %d words\n", f.Stack)
+			}
+			break
+		}
+	}
+	if len(a.Undefined) > 0 {
+		fmt.Printf("undefined: %s\n", strings.Join(a.Undefined, ", "))
+	}
+}
+
+func sizeString(n int) string {
+	if n == modules.Unbounded {
+		return "unbounded"
+	}
+
+	return fmt.Sprint(n)
+}
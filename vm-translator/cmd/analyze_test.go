@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/terashin777/vm-translator/modules"
+)
+
+func setFormat(t *testing.T, f string) {
+	t.Helper()
+	old := format
+	format = f
+	t.Cleanup(func() {
+		format = old
+	})
+}
+
+func TestAnalyzeChecksFormatFirst(t *testing.T) {
+	setFormat(t, "xml")
+	_, err := analyze(filepath.Join(t.TempDir(), "Missing.vm"))
+	if err == nil || err.Error() != "invalid format xml" {
+		t.Errorf("err = %v, want invalid format xml", err)
+	}
+}
+
+func TestAnalyzeReturnsReadErrors(t *testing.T) {
+	setFormat(t, FORMAT_TEXT)
+	fn := filepath.Join(t.TempDir(), "Bad.vm")
+	if err := os.WriteFile(fn, []byte("push constant 1\nfrob\npush local x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := analyze(fn)
+	var errs modules.ErrorList
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("err = %v, want 2 errors", err)
+	}
+	if !strings.HasPrefix(errs[1].Error(), fn+":3:") {
+		t.Errorf("second error is %s", errs[1])
+	}
+}
@@ -16,26 +16,49 @@ limitations under the License.
 package cmd
 
 import (
+	"errors"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"github.com/terashin777/vm-translator/models"
 	"github.com/terashin777/vm-translator/modules"
 )
 
 var (
-	cfgFile        string
-	dest           string
-	assemblyExt    = ".asm"
-	vmExt          = ".vm"
-	defaultDestDir = "same dir as source file"
+	cfgFile         string
+	dest            string
+	comments        bool
+	sourceMap       string
+	code            string
+	optimize        bool
+	backend         string
+	dropUnreachable bool
+	vmExt           = ".vm"
+	defaultDestDir  = "same dir as source file"
 )
 
+const (
+	CODE_INLINE  = "inline"
+	CODE_COMPACT = "compact"
+)
+
+const (
+	BACKEND_HACK  = "hack"
+	BACKEND_C     = "c"
+	BACKEND_TRACE = "trace"
+)
+
+// backendExts are the extensions of the files each backend writes.
+var backendExts = map[string]string{
+	BACKEND_HACK:  ".asm",
+	BACKEND_C:     ".c",
+	BACKEND_TRACE: ".trace",
+}
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "translate [file]",
@@ -44,6 +67,13 @@ var rootCmd = &cobra.Command{
 	Args:  cobra.ExactArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
 		err := translate(args[1], dest)
+		var errs modules.ErrorList
+		if errors.As(err, &errs) {
+			for _, e := range errs {
+				fmt.Fprintln(os.Stderr, e)
+			}
+			os.Exit(1)
+		}
 		if err != nil {
 			fmt.Printf("assemble is failed because: %s", err)
 			os.Exit(1)
@@ -60,6 +90,12 @@ func init() {
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.vm-translator.yaml)")
 	rootCmd.Flags().StringVarP(&dest, "dest", "d", defaultDestDir, "destination for translated file")
+	rootCmd.Flags().BoolVarP(&comments, "comments", "c", false, "put a // file.vm:line: command comment before the code of each command")
+	rootCmd.Flags().StringVar(&sourceMap, "source-map", "", "write the ROM range, file, line and function of each command to this file as json")
+	rootCmd.Flags().BoolVarP(&optimize, "optimize", "O", false, "keep the top of the stack out of RAM within basic blocks and print the instructions saved per function")
+	rootCmd.Flags().StringVar(&code, "code", CODE_INLINE, "inline writes call, return, eq, gt and lt in place, compact jumps to routines shared by the program")
+	rootCmd.Flags().BoolVar(&dropUnreachable, "drop-unreachable", false, "translate only the functions reachable from Sys.init, or from Main.main and the first function without bootstrap, and print the dropped ones")
+	rootCmd.Flags().StringVar(&backend, "backend", BACKEND_HACK, "hack writes Hack assembly, c a C program running the VM program on the Hack RAM, trace a line per command with the Hack RAM it uses")
 }
 
 func initConfig() {
@@ -81,6 +117,23 @@ func initConfig() {
 }
 
 func translate(src, dest string) error {
+	if code != CODE_INLINE && code != CODE_COMPACT {
+		return fmt.Errorf("invalid code %s", code)
+	}
+	if _, ok := backendExts[backend]; !ok {
+		return fmt.Errorf("invalid backend %s", backend)
+	}
+	if backend != BACKEND_HACK {
+		// The other backends have no ROM to map, optimize or share code in.
+		switch {
+		case optimize:
+			return fmt.Errorf("--optimize needs the %s backend", BACKEND_HACK)
+		case code != CODE_INLINE:
+			return fmt.Errorf("--code %s needs the %s backend", code, BACKEND_HACK)
+		case sourceMap != "":
+			return fmt.Errorf("--source-map needs the %s backend", BACKEND_HACK)
+		}
+	}
 	fns, isDir, err := getFiles(src)
 	if err != nil {
 		return err
@@ -89,17 +142,75 @@ func translate(src, dest string) error {
 		dest = src
 	}
 
-	cw, err := newCodeWriter(src, dest)
+	cw, dest, err := newCodeWriter(src, dest)
 	if err != nil {
 		return err
 	}
 	defer cw.Close()
 
+	cw.SetComments(comments)
+
 	p, err := modules.NewParser(fns)
 	if err != nil {
 		return err
 	}
-	return parseAll(p, cw, isDir)
+	err = parseAll(p, cw, isDir)
+	var errs modules.ErrorList
+	if errors.As(err, &errs) {
+		cw.Close()
+		os.Remove(dest)
+		return errs
+	}
+	if err != nil {
+		return err
+	}
+	if err = cw.WriteEnd(); err != nil {
+		return err
+	}
+	if err = cw.Close(); err != nil {
+		return err
+	}
+	if optimize {
+		printSavings(cw.Savings())
+	}
+	if sourceMap != "" {
+		return writeSourceMap(sourceMap, cw.SourceMap())
+	}
+
+	return nil
+}
+
+func printSavings(ss []*modules.Saving) {
+	width := len("total")
+	for _, s := range ss {
+		if len(s.Function) > width {
+			width = len(s.Function)
+		}
+	}
+
+	total := &modules.Saving{Function: "total"}
+	for _, s := range ss {
+		total.Before += s.Before
+		total.After += s.After
+	}
+	fmt.Printf("%-*s %7s %7s %7s\n", width, "function", "before", "after", "saved")
+	for _, s := range append(ss, total) {
+		fmt.Printf("%-*s %7d %7d %7d\n", width, s.Function, s.Before, s.After, s.Before-s.After)
+	}
+}
+
+func writeSourceMap(path string, es []*modules.SourceEntry) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err = modules.WriteSourceMap(f, es); err != nil {
+		return err
+	}
+
+	return f.Close()
 }
 
 func getFiles(src string) ([]string, bool, error) {
@@ -142,7 +253,8 @@ func isVM(fn string) bool {
 	return filepath.Ext(fn) == vmExt
 }
 
-func newCodeWriter(src, dest string) (*modules.CodeWriter, error) {
+// newCodeWriter creates the destination file and returns its path.
+func newCodeWriter(src, dest string) (*modules.CodeWriter, string, error) {
 	if dest == defaultDestDir {
 		dest = filepath.Join(filepath.Dir(src), makeSameFileName(src))
 	} else {
@@ -150,80 +262,104 @@ func newCodeWriter(src, dest string) (*modules.CodeWriter, error) {
 	}
 	w, err := os.Create(dest)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+
+	switch backend {
+	case BACKEND_C:
+		return modules.NewCodeWriter(w, modules.NewCTranslator()), dest, nil
+	case BACKEND_TRACE:
+		return modules.NewCodeWriter(w, modules.NewTraceTranslator()), dest, nil
 	}
 
-	return modules.NewCodeWriter(
+	t := modules.NewTranslator()
+	t.SetCompact(code == CODE_COMPACT)
+	cw := modules.NewCodeWriter(
 		w,
-		modules.NewTranslator(),
-	), nil
+		t,
+	)
+	if optimize {
+		cw.SetOptimizer(modules.NewOptimizer(t))
+	}
+	return cw, dest, nil
 }
 
+// parseAll reads the whole program and translates every command, or the
+// reachable ones with --drop-unreachable. Commands that can not be read or
+// translated are reported together as an ErrorList in the order of the files.
 func parseAll(p *modules.Parser, w *modules.CodeWriter, isDir bool) error {
-	err := nextFile(p, w)
-	if err == io.EOF {
+	prog, err := modules.ReadProgram(p)
+	errs := modules.ErrorList{}
+	if err != nil && !errors.As(err, &errs) {
+		return err
+	}
+	if len(prog) == 0 {
 		return nil
 	}
-	if err != nil {
-		return err
+	if dropUnreachable {
+		var dropped []*modules.Dropped
+		prog, dropped = modules.DropUnreachable(prog, isDir)
+		printDropped(dropped)
 	}
 
-	if isDir {
-		w.WriteInit()
-	}
-	for {
-		err := p.Advance()
-		if err == io.EOF {
-			err := nextFile(p, w)
-			if err == io.EOF {
-				return nil
-			}
-			if err != nil {
+	for i, u := range prog {
+		w.SetFunctionName(u.Name)
+		if i == 0 && isDir {
+			if err = w.WriteInit(); err != nil {
 				return err
 			}
-
-			continue
 		}
-		if err != nil {
-			return err
+		for _, c := range u.Commands {
+			if err := w.WriteCommand(c); err != nil {
+				errs = append(errs, &modules.Error{
+					File:    c.File,
+					Line:    c.Line,
+					Command: c.Text,
+					Err:     err,
+				})
+			}
 		}
+	}
+	if len(errs) > 0 {
+		sortErrors(errs, prog)
+		return errs
+	}
+
+	return nil
+}
 
-		ct := p.CommandType()
-		switch ct {
-		case models.C_ARITHMETIC:
-			err = w.WriteArithmetic(p.Arg1())
-		case models.C_PUSH, models.C_POP:
-			err = w.WritePushPop(ct, p.Arg1(), p.Arg2())
-		case models.C_LABEL:
-			err = w.WriteLabel(p.Arg1())
-		case models.C_GOTO:
-			err = w.WriteGoto(p.Arg1())
-		case models.C_IF:
-			err = w.WriteIf(p.Arg1())
-		case models.C_CALL:
-			err = w.WriteCall(p.Arg1(), p.Arg2())
-		case models.C_FUNCTION:
-			err = w.WriteFunction(p.Arg1(), p.Arg2())
-		case models.C_RETURN:
-			err = w.WriteReturn()
+// sortErrors sorts errs by file as in prog and by line.
+func sortErrors(errs modules.ErrorList, prog []*modules.Unit) {
+	order := map[string]int{}
+	for i, u := range prog {
+		order[u.File] = i
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		if order[errs[i].File] != order[errs[j].File] {
+			return order[errs[i].File] < order[errs[j].File]
 		}
-		if err != nil {
-			return err
+		return errs[i].Line < errs[j].Line
+	})
+}
+
+func printDropped(ds []*modules.Dropped) {
+	width := len("function")
+	for _, d := range ds {
+		if len(d.Function) > width {
+			width = len(d.Function)
 		}
 	}
+
+	n := 0
+	fmt.Printf("%-*s %8s  %s\n", width, "function", "commands", "file")
+	for _, d := range ds {
+		n += d.Commands
+		fmt.Printf("%-*s %8d  %s\n", width, d.Function, d.Commands, filepath.Base(d.File))
+	}
+	fmt.Printf("dropped %d functions, %d commands\n", len(ds), n)
 }
 
 func makeSameFileName(path string) string {
 	fn := filepath.Base(path)
-	return fmt.Sprintf("%s%s", strings.Split(fn, ".")[0], assemblyExt)
-}
-
-func nextFile(p *modules.Parser, w *modules.CodeWriter) error {
-	fn, err := p.NextFile()
-	if err != nil {
-		return err
-	}
-
-	w.SetFunctionName(fn)
-	return nil
+	return fmt.Sprintf("%s%s", strings.Split(fn, ".")[0], backendExts[backend])
 }
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/terashin777/assembler/tst"
+)
+
+// copyDir copies the files of src into dir.
+func copyDir(t *testing.T, src, dir string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := os.ReadDir(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range fs {
+		if f.IsDir() {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(src, f.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, f.Name()), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// setFlags sets the flags of translate for one test and restores them after.
+func setFlags(t *testing.T, c string, o, drop bool) {
+	t.Helper()
+	oldCode, oldOptimize, oldDrop, oldBackend := code, optimize, dropUnreachable, backend
+	code, optimize, dropUnreachable, backend = c, o, drop, BACKEND_HACK
+	t.Cleanup(func() {
+		code, optimize, dropUnreachable, backend = oldCode, oldOptimize, oldDrop, oldBackend
+	})
+
+	// Savings and dropped functions are printed to stdout.
+	stdout := os.Stdout
+	null, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = null
+	t.Cleanup(func() {
+		os.Stdout = stdout
+		null.Close()
+	})
+}
+
+// TestProjects translates the VM programs of projects 07 and 08 in each mode
+// and runs their test scripts on the Hack CPU.
+func TestProjects(t *testing.T) {
+	tests := []string{
+		"07/StackArithmetic/SimpleAdd",
+		"07/StackArithmetic/StackTest",
+		"07/MemoryAccess/BasicTest",
+		"07/MemoryAccess/PointerTest",
+		"07/MemoryAccess/StaticTest",
+		"08/ProgramFlow/BasicLoop",
+		"08/ProgramFlow/FibonacciSeries",
+		"08/FunctionCalls/SimpleFunction",
+		"08/FunctionCalls/NestedCall",
+		"08/FunctionCalls/FibonacciElement",
+		"08/FunctionCalls/StaticsTest",
+	}
+	modes := []struct {
+		name     string
+		code     string
+		optimize bool
+		drop     bool
+	}{
+		{"inline", CODE_INLINE, false, false},
+		{"optimize", CODE_INLINE, true, false},
+		{"compact", CODE_COMPACT, false, false},
+		{"compact optimize", CODE_COMPACT, true, false},
+		{"drop unreachable", CODE_INLINE, false, true},
+	}
+	for _, m := range modes {
+		for _, p := range tests {
+			t.Run(m.name+"/"+p, func(t *testing.T) {
+				setFlags(t, m.code, m.optimize, m.drop)
+				n := filepath.Base(p)
+				dir := filepath.Join(t.TempDir(), n)
+				copyDir(t, filepath.Join("../../projects", p), dir)
+
+				src := filepath.Join(dir, n+".vm")
+				if _, err := os.Stat(filepath.Join(dir, "Sys.vm")); err == nil {
+					src = dir
+				}
+				if err := translate(src, defaultDestDir); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := tst.Run(filepath.Join(dir, n+".tst")); err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+	}
+}
+
+func TestTranslateRejectsFlags(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, "../../projects/07/StackArithmetic/SimpleAdd", dir)
+	src := filepath.Join(dir, "SimpleAdd.vm")
+	tests := []struct {
+		code, backend string
+		optimize      bool
+		want          string
+	}{
+		{"tiny", BACKEND_HACK, false, "invalid code tiny"},
+		{CODE_INLINE, "java", false, "invalid backend java"},
+		{CODE_INLINE, BACKEND_C, true, "--optimize needs the hack backend"},
+		{CODE_COMPACT, BACKEND_TRACE, false, "--code compact needs the hack backend"},
+	}
+	for _, tt := range tests {
+		setFlags(t, tt.code, tt.optimize, false)
+		backend = tt.backend
+		if err := translate(src, defaultDestDir); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("err = %v, want %q", err, tt.want)
+		}
+	}
+}
+
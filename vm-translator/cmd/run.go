@@ -0,0 +1,231 @@
+/*
+Copyright © 2021 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/terashin777/vm-translator/modules"
+	"github.com/terashin777/vm-translator/vm"
+)
+
+var (
+	steps     uint64
+	pokes     []string
+	peeks     []string
+	bootstrap string
+	osDir     string
+	input     string
+)
+
+const (
+	BOOTSTRAP_AUTO = "auto"
+	BOOTSTRAP_ON   = "on"
+	BOOTSTRAP_OFF  = "off"
+)
+
+// runCmd represents the run command
+var runCmd = &cobra.Command{
+	Use:   "run [file]",
+	Short: "run your vm code on the vm emulator",
+	Long: `run your vm code on the vm emulator.
+A directory runs all of its .vm files. It runs until the program halts or the step limit, then prints RAM.
+With the bootstrap the stack starts at 256 and Sys.init is called, as in translated code.
+Functions of the Jack OS the program does not define run natively, and text printed by Output is copied to stdout.
+With --os, classes compiled into a directory like projects/12 replace the native ones they define.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := runVM(args[0])
+		var errs modules.ErrorList
+		if errors.As(err, &errs) {
+			for _, e := range errs {
+				fmt.Fprintln(os.Stderr, e)
+			}
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Printf("run is failed because: %s\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+
+	runCmd.Flags().Uint64VarP(&steps, "steps", "s", 1000000, "max number of commands to execute (0 is no limit)")
+	runCmd.Flags().StringSliceVarP(&pokes, "poke", "p", nil, "set RAM before running, as addr=value")
+	runCmd.Flags().StringSliceVarP(&peeks, "peek", "r", []string{"0-15"}, "RAM to print after running, as addr or from-to")
+	runCmd.Flags().StringVarP(&bootstrap, "bootstrap", "b", BOOTSTRAP_AUTO, "call Sys.init first: auto does when it is defined, on or off")
+	runCmd.Flags().StringVar(&osDir, "os", "", "directory of .vm files of OS classes to use instead of the native ones")
+	runCmd.Flags().StringVarP(&input, "input", "i", "-", "file of the text typed on the keyboard, - for stdin")
+}
+
+func runVM(src string) error {
+	fns, _, err := getFiles(src)
+	if err != nil {
+		return err
+	}
+	if osDir != "" {
+		if fns, err = addOSFiles(fns, osDir); err != nil {
+			return err
+		}
+	}
+
+	v := vm.NewVM()
+	if input != "-" {
+		f, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		v.SetInput(f)
+	} else {
+		v.SetInput(os.Stdin)
+	}
+	out := &lineWriter{w: os.Stdout}
+	v.SetOutput(out)
+	if err = v.Load(fns); err != nil {
+		return err
+	}
+	switch bootstrap {
+	case BOOTSTRAP_AUTO:
+		if v.HasFunction("Sys.init") {
+			err = v.Bootstrap()
+		}
+	case BOOTSTRAP_ON:
+		err = v.Bootstrap()
+	case BOOTSTRAP_OFF:
+	default:
+		return fmt.Errorf("invalid bootstrap %s", bootstrap)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, p := range pokes {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid poke %q", p)
+		}
+		a, err := parseAddress(kv[0])
+		if err != nil {
+			return err
+		}
+		x, err := strconv.ParseInt(kv[1], 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid poke %q", p)
+		}
+		v.Poke(a, int16(x))
+	}
+
+	err = v.Run(steps)
+	out.end()
+	if err == vm.ErrStepLimit {
+		f, l := v.Pos()
+		fmt.Fprintf(os.Stderr, "stopped after %d steps in %s at %s:%d: %s\n", v.Steps(), v.Function(), f, l, err)
+	} else if err != nil {
+		return err
+	}
+
+	fmt.Printf("steps=%d\n", v.Steps())
+	for _, p := range peeks {
+		from, to, err := parseAddressRange(p)
+		if err != nil {
+			return err
+		}
+		for a := from; a <= to; a++ {
+			fmt.Printf("RAM[%d]=%d\n", a, v.Peek(a))
+		}
+	}
+
+	return nil
+}
+
+// addOSFiles adds the .vm files in dir to fns, except the classes fns has.
+func addOSFiles(fns []string, dir string) ([]string, error) {
+	ofs, err := getFilesInDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	classes := map[string]bool{}
+	for _, fn := range fns {
+		classes[filepath.Base(fn)] = true
+	}
+	for _, fn := range ofs {
+		if !classes[filepath.Base(fn)] {
+			fns = append(fns, fn)
+		}
+	}
+
+	return fns, nil
+}
+
+// lineWriter remembers whether the last line it wrote is complete, so what
+// is printed after the program starts on a line of its own.
+type lineWriter struct {
+	w       io.Writer
+	partial bool
+}
+
+func (w *lineWriter) Write(b []byte) (int, error) {
+	if len(b) > 0 {
+		w.partial = b[len(b)-1] != '\n'
+	}
+
+	return w.w.Write(b)
+}
+
+func (w *lineWriter) end() {
+	if w.partial {
+		fmt.Fprintln(w.w)
+		w.partial = false
+	}
+}
+
+func parseAddress(s string) (int, error) {
+	a, err := strconv.ParseUint(strings.TrimSpace(s), 10, 16)
+	if err != nil || a >= vm.RAMSize {
+		return 0, fmt.Errorf("invalid address %q", s)
+	}
+
+	return int(a), nil
+}
+
+func parseAddressRange(s string) (int, int, error) {
+	ft := strings.SplitN(s, "-", 2)
+	from, err := parseAddress(ft[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(ft) == 1 {
+		return from, from, nil
+	}
+
+	to, err := parseAddress(ft[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	return from, to, nil
+}
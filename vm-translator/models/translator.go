@@ -11,5 +11,6 @@ type ITranslator interface {
 	TranslateCall(fn string, n int) string
 	TranslateReturn() string
 	TranslateFunction(fn string, n int) string
+	TranslateEnd() string
 	SetFunctionName(n string)
 }
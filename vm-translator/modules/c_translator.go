@@ -0,0 +1,323 @@
+package modules
+
+import (
+	"fmt"
+	"strings"
+)
+
+// cHeader starts the C program. RAM is unsigned so arithmetic wraps the
+// way the Hack ALU does.
+const cHeader = `/*
+ * Generated by vm-translator. It runs the VM program on the RAM of the Hack
+ * platform. Arguments set RAM as addr=value before the run and print RAM as
+ * addr or from-to when the program halts.
+ */
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+static uint16_t RAM[32768];
+
+#define M(a) RAM[(uint16_t)(a) & 0x7fff]
+#define SP RAM[0]
+#define LCL RAM[1]
+#define ARG RAM[2]
+#define THIS RAM[3]
+#define THAT RAM[4]
+#define PUSH(x) (M(SP) = (uint16_t)(x), SP++)
+#define POP() (SP--, M(SP))
+#define TOP M(SP - 1)
+#define TRUE 0xffff
+
+static int vm_argc;
+static char **vm_argv;
+
+static void vm_halt(void);
+
+`
+
+// cMain prints RAM and starts the program.
+const cMain = `
+static void vm_halt(void)
+{
+	for (int i = 1; i < vm_argc; i++) {
+		unsigned from, to;
+		if (strchr(vm_argv[i], '=') != NULL) {
+			continue;
+		}
+		if (sscanf(vm_argv[i], "%%u-%%u", &from, &to) != 2) {
+			to = from;
+		}
+		for (unsigned a = from; a <= to && a < 32768; a++) {
+			printf("RAM[%%u]=%%d\n", a, RAM[a] & 0x8000 ? (int)RAM[a] - 65536 : (int)RAM[a]);
+		}
+	}
+	exit(0);
+}
+
+int main(int argc, char **argv)
+{
+	vm_argc = argc;
+	vm_argv = argv;
+	for (int i = 1; i < argc; i++) {
+		unsigned a;
+		int v;
+		if (sscanf(argv[i], "%%u=%%d", &a, &v) == 2) {
+			M(a) = (uint16_t)v;
+		}
+	}
+%s	vm_halt();
+	return 0;
+}
+`
+
+// cCompares compare by the sign of x-y as the Hack code does, so they
+// overflow the same way.
+var cCompares = map[string]string{
+	"eq": "TOP = TOP == y ? TRUE : 0;",
+	"gt": "uint16_t d = TOP - y; TOP = d != 0 && !(d & 0x8000) ? TRUE : 0;",
+	"lt": "uint16_t d = TOP - y; TOP = d & 0x8000 ? TRUE : 0;",
+}
+
+var cBinaries = map[string]string{
+	"add": "+",
+	"sub": "-",
+	"and": "&",
+	"or":  "|",
+}
+
+// CTranslator translates VM code to a portable C program, so VM programs can
+// run natively. Each VM function is a C function keeping its frame in RAM as
+// the Hack code does, and statics get RAM from 16 in the order they are used.
+// Calls of Sys.halt and loops jumping to themselves halt the program.
+type CTranslator struct {
+	fileName  string
+	started   bool
+	inFunc    bool
+	hasTop    bool
+	bootstrap bool
+	statics   map[string]int
+	calls     int
+	// first is the first function, which runs when there is nothing else to
+	// run, as the Hack code falls into it.
+	first string
+	// label is the label just defined, to find loops to itself.
+	label string
+}
+
+func NewCTranslator() *CTranslator {
+	return &CTranslator{
+		statics: map[string]int{},
+	}
+}
+
+func (t *CTranslator) SetFunctionName(n string) {
+	t.fileName = n
+}
+
+// open returns what has to come before a statement: the header of the
+// program and a C function for commands outside of VM functions.
+func (t *CTranslator) open() string {
+	s := ""
+	if !t.started {
+		t.started = true
+		s = cHeader
+	}
+	if !t.inFunc {
+		t.inFunc = true
+		t.hasTop = true
+		s += "void vm_top(void)\n{\n"
+	}
+	t.label = ""
+
+	return s
+}
+
+func (t *CTranslator) TranslateInit() string {
+	t.bootstrap = true
+	if t.started {
+		return ""
+	}
+
+	t.started = true
+	return cHeader
+}
+
+func (t *CTranslator) TranslateArithmetic(c string) string {
+	if _, ok := arithmethicCmds[c]; !ok {
+		return ""
+	}
+
+	s := t.open()
+	switch c {
+	case "neg":
+		return s + "\tTOP = -TOP;\n"
+	case "not":
+		return s + "\tTOP = ~TOP;\n"
+	}
+	if op, ok := cBinaries[c]; ok {
+		return s + fmt.Sprintf("\t{ uint16_t y = POP(); TOP = TOP %s y; }\n", op)
+	}
+
+	return s + fmt.Sprintf("\t{ uint16_t y = POP(); %s }\n", cCompares[c])
+}
+
+// address returns the C expression of a RAM word of a segment.
+func (t *CTranslator) address(seg string, i int) string {
+	switch seg {
+	case "local", "argument", "this", "that":
+		return fmt.Sprintf("M(%s + %d)", memoryMap[seg], i)
+	case "pointer", "temp":
+		return fmt.Sprintf("RAM[%d]", baseAddrMap[seg]+i)
+	case "static":
+		v := fmt.Sprintf("%s.%d", t.fileName, i)
+		if _, ok := t.statics[v]; !ok {
+			t.statics[v] = 16 + len(t.statics)
+		}
+		return fmt.Sprintf("RAM[%d] /* %s */", t.statics[v], v)
+	}
+
+	return ""
+}
+
+func (t *CTranslator) TranslatePush(seg string, i int) string {
+	if seg == "constant" {
+		return t.open() + fmt.Sprintf("\tPUSH(%d);\n", i)
+	}
+	a := t.address(seg, i)
+	if a == "" {
+		return ""
+	}
+
+	return t.open() + fmt.Sprintf("\tPUSH(%s);\n", a)
+}
+
+func (t *CTranslator) TranslatePop(seg string, i int) string {
+	a := t.address(seg, i)
+	if a == "" {
+		return ""
+	}
+
+	return t.open() + fmt.Sprintf("\t{ uint16_t v = POP(); %s = v; }\n", a)
+}
+
+func (t *CTranslator) TranslateLabel(l string) string {
+	s := t.open() + fmt.Sprintf("%s:;\n", cName("l_", l))
+	t.label = l
+	return s
+}
+
+func (t *CTranslator) TranslateGoto(l string) string {
+	self := t.label == l
+	s := t.open()
+	if self {
+		return s + "\tvm_halt();\n"
+	}
+
+	return s + fmt.Sprintf("\tgoto %s;\n", cName("l_", l))
+}
+
+func (t *CTranslator) TranslateIf(l string) string {
+	return t.open() + fmt.Sprintf("\tif (POP()) goto %s;\n", cName("l_", l))
+}
+
+func (t *CTranslator) TranslateCall(f string, n int) string {
+	s := t.open()
+	if f == "Sys.halt" {
+		return s + "\tvm_halt();\n"
+	}
+
+	return s + t.call(f, n)
+}
+
+func (t *CTranslator) call(f string, n int) string {
+	t.calls++
+	fn := cName("f_", f)
+	return fmt.Sprintf(`	{
+		void %s(void);
+		PUSH(%d);
+		PUSH(LCL);
+		PUSH(ARG);
+		PUSH(THIS);
+		PUSH(THAT);
+		ARG = SP - %d;
+		LCL = SP;
+		%s();
+	}
+`, fn, t.calls, n+5, fn)
+}
+
+func (t *CTranslator) TranslateReturn() string {
+	return t.open() + `	{
+		uint16_t frame = LCL;
+		uint16_t v = POP();
+		M(ARG) = v;
+		SP = ARG + 1;
+		THAT = M(frame - 1);
+		THIS = M(frame - 2);
+		ARG = M(frame - 3);
+		LCL = M(frame - 4);
+		return;
+	}
+`
+}
+
+func (t *CTranslator) TranslateFunction(f string, k int) string {
+	s := t.close()
+	if !t.started {
+		t.started = true
+		s = cHeader
+	}
+	t.inFunc = true
+	t.label = ""
+	if t.first == "" {
+		t.first = f
+	}
+
+	s += fmt.Sprintf("void %s(void)\n{\n", cName("f_", f))
+	if k > 0 {
+		s += fmt.Sprintf("\tfor (int i = 0; i < %d; i++) {\n\t\tPUSH(0);\n\t}\n", k)
+	}
+
+	return s
+}
+
+// close ends the C function being written.
+func (t *CTranslator) close() string {
+	if !t.inFunc {
+		return ""
+	}
+
+	t.inFunc = false
+	return "}\n\n"
+}
+
+// TranslateEnd closes the program with main, which runs the bootstrap, the
+// commands outside of functions or else the first function.
+func (t *CTranslator) TranslateEnd() string {
+	s := t.close()
+	if !t.started {
+		t.started = true
+		s = cHeader
+	}
+
+	run := ""
+	switch {
+	case t.bootstrap:
+		run = "\tSP = 256;\n" + t.call("Sys.init", 0)
+	case t.hasTop:
+		run = "\t{\n\t\tvoid vm_top(void);\n\t\tvm_top();\n\t}\n"
+	case t.first != "":
+		run = fmt.Sprintf("\t{\n\t\tvoid %[1]s(void);\n\t\t%[1]s();\n\t}\n", cName("f_", t.first))
+	}
+
+	return s + fmt.Sprintf(cMain, run)
+}
+
+// cName makes a C identifier of a VM name. Characters C does not allow are
+// escaped with _, which is escaped itself, so names stay apart.
+func cName(prefix, n string) string {
+	r := strings.NewReplacer("_", "__", ".", "_0", "$", "_1", ":", "_2")
+	return prefix + r.Replace(n)
+}
@@ -0,0 +1,114 @@
+package modules
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCName(t *testing.T) {
+	tests := map[string]string{
+		"Main.main":   "f_Main_0main",
+		"Main.a_b":    "f_Main_0a__b",
+		"Main.f$LOOP": "f_Main_0f_1LOOP",
+		"Main.f$L:1":  "f_Main_0f_1L_21",
+		"Main.a_0b":   "f_Main_0a__0b",
+		"Main.a.0b":   "f_Main_0a_00b",
+	}
+	for n, want := range tests {
+		if got := cName("f_", n); got != want {
+			t.Errorf("cName(%s) = %s, want %s", n, got, want)
+		}
+	}
+}
+
+func TestCTranslator(t *testing.T) {
+	tr := NewCTranslator()
+	tr.SetFunctionName("Main")
+	if s := tr.TranslateFunction("Main.f", 2); !strings.HasPrefix(s, cHeader) || !strings.Contains(s, "void f_Main_0f(void)\n{\n") {
+		t.Errorf("function:\n%s", s)
+	}
+	if s := tr.TranslatePop("static", 3) + tr.TranslatePush("static", 1) + tr.TranslatePush("static", 3); s !=
+		"\t{ uint16_t v = POP(); RAM[16] /* Main.3 */ = v; }\n\tPUSH(RAM[17] /* Main.1 */);\n\tPUSH(RAM[16] /* Main.3 */);\n" {
+		t.Errorf("statics:\n%s", s)
+	}
+	if s := tr.TranslateLabel("Main.f$END") + tr.TranslateGoto("Main.f$END"); !strings.HasSuffix(s, "\tvm_halt();\n") {
+		t.Errorf("a goto to itself does not halt:\n%s", s)
+	}
+	if s := tr.TranslateLabel("Main.f$A") + tr.TranslatePush("constant", 0) + tr.TranslateGoto("Main.f$A"); !strings.HasSuffix(s, "\tgoto l_Main_0f_1A;\n") {
+		t.Errorf("goto:\n%s", s)
+	}
+	if s := tr.TranslateCall("Sys.halt", 0); s != "\tvm_halt();\n" {
+		t.Errorf("Sys.halt:\n%s", s)
+	}
+	if s := tr.TranslateEnd(); !strings.HasPrefix(s, "}\n\n") || !strings.Contains(s, "f_Main_0f();") {
+		t.Errorf("a program without bootstrap does not run its first function:\n%s", s)
+	}
+	if s := tr.TranslatePush("heap", 0) + tr.TranslatePop("constant", 0) + tr.TranslateArithmetic("mul"); s != "" {
+		t.Errorf("unknown commands: %q", s)
+	}
+}
+
+// runC translates the .vm files of a project directory to C, compiles and
+// runs it with args and returns what it prints.
+func runC(t *testing.T, cc, dir string, bootstrap bool, args ...string) string {
+	t.Helper()
+	fns, err := filepath.Glob(filepath.Join("../../projects", dir, "*.vm"))
+	if err != nil || len(fns) == 0 {
+		t.Fatalf("%s: no .vm files: %v", dir, err)
+	}
+	p, err := NewParser(fns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := ReadProgram(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &bytes.Buffer{}
+	writeProgram(t, NewCodeWriter(nopCloser{b}, NewCTranslator()), prog, bootstrap)
+	tmp := t.TempDir()
+	src, bin := filepath.Join(tmp, "prog.c"), filepath.Join(tmp, "prog")
+	if err := os.WriteFile(src, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command(cc, "-std=c99", "-o", bin, src).CombinedOutput(); err != nil {
+		t.Fatalf("%s: %v\n%s", dir, err, out)
+	}
+	out, err := exec.Command(bin, args...).Output()
+	if err != nil {
+		t.Fatalf("%s: %v", dir, err)
+	}
+
+	return string(out)
+}
+
+func TestCTranslatorPrograms(t *testing.T) {
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("no C compiler")
+	}
+
+	tests := []struct {
+		dir       string
+		bootstrap bool
+		args      []string
+		want      string
+	}{
+		{"07/StackArithmetic/SimpleAdd", false, []string{"0=256", "0", "256"}, "RAM[0]=257\nRAM[256]=15\n"},
+		{"07/MemoryAccess/BasicTest", false,
+			[]string{"0=256", "1=300", "2=400", "3=3000", "4=3010", "256", "300", "401-402", "3006", "3012", "3015", "11"},
+			"RAM[256]=472\nRAM[300]=10\nRAM[401]=21\nRAM[402]=22\nRAM[3006]=36\nRAM[3012]=42\nRAM[3015]=45\nRAM[11]=510\n"},
+		{"08/FunctionCalls/FibonacciElement", true, []string{"0", "261"}, "RAM[0]=262\nRAM[261]=3\n"},
+		{"08/FunctionCalls/StaticsTest", true, []string{"0", "261-262"}, "RAM[0]=263\nRAM[261]=-2\nRAM[262]=8\n"},
+	}
+	for _, tt := range tests {
+		if got := runC(t, cc, tt.dir, tt.bootstrap, tt.args...); got != tt.want {
+			t.Errorf("%s printed\n%s\nwant\n%s", tt.dir, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package modules
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/terashin777/vm-translator/models"
+)
+
+// Unbounded is the depth or the stack of a function with no limit, as it
+// recurses or loops pushing more than it pops.
+const Unbounded = -1
+
+// frameSize is the words call pushes for the frame of the caller.
+const frameSize = 5
+
+// Analysis is what Analyze finds out about a program.
+type Analysis struct {
+	Functions []*FunctionInfo `json:"functions"`
+	Calls     []*CallEdge     `json:"calls"`
+	// Undefined are the functions called but not defined by the program,
+	// such as those of an OS left out.
+	Undefined []string `json:"undefined"`
+	// Cycles are the groups of functions calling each other.
+	Cycles [][]string `json:"cycles"`
+	Issues []*Issue   `json:"issues"`
+}
+
+// FunctionInfo is a function of the program. Commands outside of functions
+// are taken as a function named as their file, without a frame.
+type FunctionInfo struct {
+	Name   string `json:"name"`
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Locals int    `json:"locals"`
+	// MaxDepth is the most values the function has on its operand stack.
+	MaxDepth int `json:"max_depth"`
+	// Stack is the most words of stack a call of the function takes, from the
+	// frame call pushes to the stacks of the functions it calls. Undefined
+	// functions take their frame.
+	Stack     int  `json:"stack"`
+	Recursive bool `json:"recursive"`
+
+	cmds  []*Command
+	frame int
+	sites []callSite
+}
+
+// CallEdge is a function calling another Count times.
+type CallEdge struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Count int    `json:"count"`
+}
+
+// Analyze builds the call graph of prog and works out the stack each
+// function needs.
+func Analyze(prog []*Unit) *Analysis {
+	a := &Analysis{
+		Functions: []*FunctionInfo{},
+		Calls:     []*CallEdge{},
+		Undefined: []string{},
+		Cycles:    [][]string{},
+		Issues:    []*Issue{},
+	}
+	for _, u := range prog {
+		var f *FunctionInfo
+		for _, c := range u.Commands {
+			switch {
+			case c.Type == models.C_FUNCTION:
+				f = &FunctionInfo{Name: c.Arg1, File: c.File, Line: c.Line, Locals: c.Arg2, frame: frameSize}
+				a.Functions = append(a.Functions, f)
+			case f == nil:
+				f = &FunctionInfo{Name: u.Name, File: c.File, Line: c.Line}
+				a.Functions = append(a.Functions, f)
+			}
+			f.cmds = append(f.cmds, c)
+		}
+	}
+
+	funcs := map[string]*FunctionInfo{}
+	for _, f := range a.Functions {
+		if _, ok := funcs[f.Name]; !ok {
+			funcs[f.Name] = f
+		}
+		a.Issues = append(a.Issues, f.analyzeDepth()...)
+	}
+
+	undefined := map[string]bool{}
+	for _, f := range a.Functions {
+		edges := map[string]*CallEdge{}
+		for _, s := range f.sites {
+			if e, ok := edges[s.function]; ok {
+				e.Count++
+				continue
+			}
+			e := &CallEdge{From: f.Name, To: s.function, Count: 1}
+			edges[s.function] = e
+			a.Calls = append(a.Calls, e)
+			if _, ok := funcs[s.function]; !ok && !undefined[s.function] {
+				undefined[s.function] = true
+				a.Undefined = append(a.Undefined, s.function)
+			}
+		}
+	}
+
+	a.findCycles(funcs)
+	stacks := map[*FunctionInfo]int{}
+	for _, f := range a.Functions {
+		f.Stack = stackOf(f, funcs, stacks)
+	}
+
+	return a
+}
+
+// findCycles finds the groups of functions calling each other with the
+// algorithm of Tarjan, and marks them recursive.
+func (a *Analysis) findCycles(funcs map[string]*FunctionInfo) {
+	calls := map[string][]string{}
+	self := map[string]bool{}
+	for _, e := range a.Calls {
+		if _, ok := funcs[e.To]; ok {
+			calls[e.From] = append(calls[e.From], e.To)
+		}
+		if e.From == e.To {
+			self[e.From] = true
+		}
+	}
+
+	index := map[string]int{}
+	low := map[string]int{}
+	onStack := map[string]bool{}
+	stack := []string{}
+	var visit func(fn string)
+	visit = func(fn string) {
+		index[fn] = len(index)
+		low[fn] = index[fn]
+		stack = append(stack, fn)
+		onStack[fn] = true
+		for _, g := range calls[fn] {
+			if _, ok := index[g]; !ok {
+				visit(g)
+				if low[g] < low[fn] {
+					low[fn] = low[g]
+				}
+			} else if onStack[g] && index[g] < low[fn] {
+				low[fn] = index[g]
+			}
+		}
+		if low[fn] != index[fn] {
+			return
+		}
+
+		cycle := []string{}
+		for {
+			g := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[g] = false
+			cycle = append(cycle, g)
+			if g == fn {
+				break
+			}
+		}
+		if len(cycle) == 1 && !self[fn] {
+			return
+		}
+		for i, j := 0, len(cycle)-1; i < j; i, j = i+1, j-1 {
+			cycle[i], cycle[j] = cycle[j], cycle[i]
+		}
+		for _, g := range cycle {
+			funcs[g].Recursive = true
+		}
+		a.Cycles = append(a.Cycles, cycle)
+	}
+
+	for _, f := range a.Functions {
+		if _, ok := index[f.Name]; !ok {
+			visit(f.Name)
+		}
+	}
+}
+
+// stackOf returns the stack a call of f takes, remembering it in stacks.
+// Functions not recursing call none that do, so it ends.
+func stackOf(f *FunctionInfo, funcs map[string]*FunctionInfo, stacks map[*FunctionInfo]int) int {
+	if s, ok := stacks[f]; ok {
+		return s
+	}
+	if f.Recursive || f.MaxDepth == Unbounded {
+		stacks[f] = Unbounded
+		return Unbounded
+	}
+
+	s := f.MaxDepth
+	for _, c := range f.sites {
+		cs := frameSize
+		if g, ok := funcs[c.function]; ok {
+			cs = stackOf(g, funcs, stacks)
+		}
+		if cs == Unbounded {
+			s = Unbounded
+			break
+		}
+		if c.depth+cs > s {
+			s = c.depth + cs
+		}
+	}
+	if s != Unbounded {
+		s += f.frame + f.Locals
+	}
+	stacks[f] = s
+
+	return s
+}
+
+// WriteJSON writes the analysis as JSON.
+func (a *Analysis) WriteJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(a)
+}
+
+// WriteDOT writes the call graph for Graphviz. Recursive calls are red and
+// undefined functions dashed.
+func (a *Analysis) WriteDOT(w io.Writer) error {
+	inCycle := map[string]int{}
+	for i, c := range a.Cycles {
+		for _, fn := range c {
+			inCycle[fn] = i + 1
+		}
+	}
+
+	b := &errWriter{w: w}
+	b.printf("digraph calls {\n\tnode [shape=box];\n")
+	for _, f := range a.Functions {
+		b.printf("\t%q [label=%q];\n", f.Name, fmt.Sprintf("%s\nlocals %d, depth %s, stack %s", f.Name, f.Locals, sizeString(f.MaxDepth), sizeString(f.Stack)))
+	}
+	for _, fn := range a.Undefined {
+		b.printf("\t%q [style=dashed];\n", fn)
+	}
+	for _, e := range a.Calls {
+		attrs := ""
+		if e.Count > 1 {
+			attrs = fmt.Sprintf(" label=%q", fmt.Sprint(e.Count))
+		}
+		if inCycle[e.From] != 0 && inCycle[e.From] == inCycle[e.To] {
+			attrs += " color=red"
+		}
+		if attrs != "" {
+			attrs = " [" + attrs[1:] + "]"
+		}
+		b.printf("\t%q -> %q%s;\n", e.From, e.To, attrs)
+	}
+	b.printf("}\n")
+
+	return b.err
+}
+
+// sizeString writes a depth or a stack, which may be unbounded.
+func sizeString(n int) string {
+	if n == Unbounded {
+		return "unbounded"
+	}
+
+	return fmt.Sprint(n)
+}
+
+// errWriter keeps the first error of the writes, so they can be checked
+// once.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) printf(format string, a ...interface{}) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = fmt.Fprintf(e.w, format, a...)
+}
@@ -0,0 +1,144 @@
+package modules
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const factVM = `function Main.fact 0
+push argument 0
+if-goto REC
+push constant 1
+return
+label REC
+push argument 0
+push argument 0
+push constant 1
+sub
+call Main.fact 1
+call Math.multiply 2
+return
+function Main.even 0
+call Main.odd 0
+return
+function Main.odd 0
+call Main.even 0
+call Main.even 0
+return
+`
+
+// functionInfo returns the function of a named fn.
+func functionInfo(t *testing.T, a *Analysis, fn string) *FunctionInfo {
+	t.Helper()
+	for _, f := range a.Functions {
+		if f.Name == fn {
+			return f
+		}
+	}
+	t.Fatalf("no function %s", fn)
+
+	return nil
+}
+
+func TestAnalyzeStack(t *testing.T) {
+	a := Analyze(readVM(t, vmFile{"Sys.vm", sysVM}, vmFile{"Main.vm", mainVM}))
+	tests := []struct {
+		fn     string
+		locals int
+		depth  int
+		stack  int
+	}{
+		// The 2 values on the stack of Sys.init become the arguments of
+		// Main.add, which takes its frame, its local and its own 2 values.
+		{"Sys.init", 0, 2, 2 + 5 + 1 + 2 + 5},
+		{"Main.add", 1, 2, 5 + 1 + 2},
+	}
+	for _, tt := range tests {
+		f := functionInfo(t, a, tt.fn)
+		if f.Locals != tt.locals || f.MaxDepth != tt.depth || f.Stack != tt.stack || f.Recursive {
+			t.Errorf("%s: %+v, want locals %d, depth %d, stack %d", tt.fn, *f, tt.locals, tt.depth, tt.stack)
+		}
+	}
+	if len(a.Calls) != 1 || *a.Calls[0] != (CallEdge{From: "Sys.init", To: "Main.add", Count: 1}) {
+		t.Errorf("calls %v", a.Calls)
+	}
+	if len(a.Undefined) != 0 || len(a.Cycles) != 0 || len(a.Issues) != 0 {
+		t.Errorf("undefined %v, cycles %v, issues %v", a.Undefined, a.Cycles, a.Issues)
+	}
+}
+
+func TestAnalyzeRecursion(t *testing.T) {
+	a := Analyze(readVM(t, vmFile{"Main.vm", factVM}))
+
+	if !reflect.DeepEqual(a.Cycles, [][]string{{"Main.fact"}, {"Main.even", "Main.odd"}}) {
+		t.Errorf("cycles %v", a.Cycles)
+	}
+	if !reflect.DeepEqual(a.Undefined, []string{"Math.multiply"}) {
+		t.Errorf("undefined %v", a.Undefined)
+	}
+	for _, fn := range []string{"Main.fact", "Main.even", "Main.odd"} {
+		if f := functionInfo(t, a, fn); !f.Recursive || f.Stack != Unbounded {
+			t.Errorf("%s: %+v", fn, *f)
+		}
+	}
+	if f := functionInfo(t, a, "Main.fact"); f.MaxDepth != 3 {
+		t.Errorf("Main.fact depth %d, want 3", f.MaxDepth)
+	}
+	for _, e := range a.Calls {
+		if e.From == "Main.odd" && e.Count != 2 {
+			t.Errorf("Main.odd calls Main.even %d times", e.Count)
+		}
+	}
+}
+
+func TestAnalyzeCommandsOutsideFunctions(t *testing.T) {
+	a := Analyze(readVM(t, vmFile{"Top.vm", "push constant 1\npush constant 2\ncall Main.add 2\npop temp 0\n"}, vmFile{"Main.vm", mainVM}))
+	f := functionInfo(t, a, "Top")
+	// No frame of its own, nor a return.
+	if f.Locals != 0 || f.Stack != 2+8 || len(a.Issues) != 0 {
+		t.Errorf("%+v, issues %v", *f, a.Issues)
+	}
+}
+
+func TestAnalysisOutput(t *testing.T) {
+	a := Analyze(readVM(t, vmFile{"Main.vm", factVM}))
+
+	b := &bytes.Buffer{}
+	if err := a.WriteDOT(b); err != nil {
+		t.Fatal(err)
+	}
+	dot := b.String()
+	for _, s := range []string{
+		"digraph calls {\n",
+		"\"Main.fact\" [label=\"Main.fact\\nlocals 0, depth 3, stack unbounded\"];\n",
+		"\"Math.multiply\" [style=dashed];\n",
+		"\"Main.fact\" -> \"Main.fact\" [color=red];\n",
+		"\"Main.fact\" -> \"Math.multiply\";\n",
+		"\"Main.odd\" -> \"Main.even\" [label=\"2\" color=red];\n",
+	} {
+		if !strings.Contains(dot, s) {
+			t.Errorf("DOT has no %q:\n%s", s, dot)
+		}
+	}
+
+	b.Reset()
+	if err := a.WriteJSON(b); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Functions []struct {
+			Name  string `json:"name"`
+			Stack int    `json:"stack"`
+		} `json:"functions"`
+		Undefined []string `json:"undefined"`
+	}
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Functions) != 3 || got.Functions[0].Stack != Unbounded || got.Undefined[0] != "Math.multiply" {
+		t.Errorf("JSON:\n%s", b)
+	}
+}
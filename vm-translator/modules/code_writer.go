@@ -4,54 +4,247 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"path/filepath"
+	"strings"
 
 	"github.com/terashin777/vm-translator/models"
+	"github.com/terashin777/vm-translator/utils"
 )
 
+// MaxStatics is the number of static variables RAM 16 to 255 holds.
+const MaxStatics = 240
+
+var segments = map[string]struct{}{
+	"local":    {},
+	"argument": {},
+	"this":     {},
+	"that":     {},
+	"constant": {},
+	"static":   {},
+	"pointer":  {},
+	"temp":     {},
+}
+
+// segmentSizes are the sizes of the segments mapped to fixed registers.
+var segmentSizes = map[string]int{
+	"pointer": 2,
+	"temp":    8,
+}
+
 type CodeWriter struct {
 	w  io.WriteCloser
 	bw *bufio.Writer
 	t  models.ITranslator
 	f  string
+	// file is the file being written, which names its statics.
+	file string
+
+	// fn is the function being written, addr the ROM address of the next
+	// instruction and src the VM command being written.
+	fn       string
+	addr     int
+	comments bool
+	src      *SourceEntry
+	sm       []*SourceEntry
+	// statics are the static variables used so far as file.index.
+	statics map[string]struct{}
+
+	// opt translates the commands held in cmds when a function ends.
+	opt     *Optimizer
+	cmds    []*Command
+	savings []*Saving
 }
 
 func NewCodeWriter(w io.WriteCloser, t models.ITranslator) *CodeWriter {
 	return &CodeWriter{
-		w:  w,
-		bw: bufio.NewWriter(w),
-		t:  t,
+		w:       w,
+		bw:      bufio.NewWriter(w),
+		t:       t,
+		sm:      []*SourceEntry{},
+		statics: map[string]struct{}{},
 	}
 }
 
 func (w *CodeWriter) SetFunctionName(n string) {
+	w.flush()
 	w.f = n
+	w.file = n
+	w.fn = ""
 	w.t.SetFunctionName(n)
 }
 
-func (w *CodeWriter) WriteInit() error {
-	_, err := w.bw.WriteString(w.t.TranslateInit())
-	if err != nil {
-		return err
+// SetOptimizer makes the writer hold the commands of each function and
+// translate them with o when the function ends.
+func (w *CodeWriter) SetOptimizer(o *Optimizer) {
+	w.opt = o
+}
+
+// Savings returns what the optimizer saved in each function written so far.
+func (w *CodeWriter) Savings() []*Saving {
+	w.flush()
+	return w.savings
+}
+
+// SetComments makes the writer put a comment naming the VM command before
+// the code of each command.
+func (w *CodeWriter) SetComments(b bool) {
+	w.comments = b
+}
+
+// SetSource tells which VM command the code written next comes from.
+func (w *CodeWriter) SetSource(file string, line int, command string) {
+	w.endSource()
+	w.src = &SourceEntry{
+		From:     w.addr,
+		To:       w.addr - 1,
+		File:     file,
+		Line:     line,
+		Function: w.fn,
+		Command:  command,
 	}
+	if !w.comments {
+		return
+	}
+
+	c := fmt.Sprintf("// %s\n", command)
+	if file != "" {
+		c = fmt.Sprintf("// %s:%d: %s\n", file, line, command)
+	}
+	w.bw.WriteString(c)
+}
+
+// SourceMap returns the ROM ranges of the commands written so far. Commands
+// without instructions, such as labels, are left out.
+func (w *CodeWriter) SourceMap() []*SourceEntry {
+	w.endSource()
+	return w.sm
+}
+
+func (w *CodeWriter) endSource() {
+	if w.src != nil && w.src.To >= w.src.From {
+		w.sm = append(w.sm, w.src)
+	}
+	w.src = nil
+}
+
+// write writes the code of a command, ending it with a newline so the next
+// command starts on its own line, and counts its instructions.
+func (w *CodeWriter) write(code string) error {
+	if !strings.HasSuffix(code, "\n") {
+		code += "\n"
+	}
+	w.addr += countInstructions(code)
+	if w.src != nil {
+		w.src.To = w.addr - 1
+	}
+
+	_, err := w.bw.WriteString(code)
+	return err
+}
+
+// WriteCommand writes the code of c. With an optimizer, c is checked and
+// held until its function ends.
+func (w *CodeWriter) WriteCommand(c *Command) error {
+	if w.opt == nil {
+		w.SetSource(filepath.Base(c.File), c.Line, c.Text)
+		return w.writeCommand(c)
+	}
+
+	h := *c
+	switch c.Type {
+	case models.C_PUSH, models.C_POP:
+		if err := w.checkSegment(c.Type, c.Arg1, c.Arg2); err != nil {
+			return err
+		}
+	case models.C_ARITHMETIC:
+		if _, ok := arithmethicCmds[c.Arg1]; !ok {
+			return ErrNoCode
+		}
+	case models.C_LABEL, models.C_GOTO, models.C_IF:
+		if err := ValidateLabel(c.Arg1); err != nil {
+			return err
+		}
+		h.Arg1 = fmt.Sprintf("%s$%s", w.f, c.Arg1)
+	case models.C_CALL, models.C_FUNCTION:
+		if err := ValidateLabel(c.Arg1); err != nil {
+			return err
+		}
+	case models.C_RETURN:
+	default:
+		return ErrUnknownCommand
+	}
+	if c.Type == models.C_FUNCTION {
+		w.flush()
+		w.f = c.Arg1
+	}
+	w.cmds = append(w.cmds, &h)
 
 	return nil
 }
 
+func (w *CodeWriter) writeCommand(c *Command) error {
+	switch c.Type {
+	case models.C_ARITHMETIC:
+		return w.WriteArithmetic(c.Arg1)
+	case models.C_PUSH, models.C_POP:
+		return w.WritePushPop(c.Type, c.Arg1, c.Arg2)
+	case models.C_LABEL:
+		return w.WriteLabel(c.Arg1)
+	case models.C_GOTO:
+		return w.WriteGoto(c.Arg1)
+	case models.C_IF:
+		return w.WriteIf(c.Arg1)
+	case models.C_CALL:
+		return w.WriteCall(c.Arg1, c.Arg2)
+	case models.C_RETURN:
+		return w.WriteReturn()
+	case models.C_FUNCTION:
+		return w.WriteFunction(c.Arg1, c.Arg2)
+	}
+
+	return ErrUnknownCommand
+}
+
+// flush writes the held commands with the optimizer. Errors of the writer
+// stick and come back from Close.
+func (w *CodeWriter) flush() {
+	if len(w.cmds) == 0 {
+		return
+	}
+
+	code, s := w.opt.Translate(w.cmds)
+	for i, c := range w.cmds {
+		if c.Type == models.C_FUNCTION {
+			w.fn = c.Arg1
+		}
+		w.SetSource(filepath.Base(c.File), c.Line, c.Text)
+		if code[i] != "" {
+			w.write(code[i])
+		}
+	}
+	w.savings = append(w.savings, s)
+	w.cmds = nil
+}
+
+func (w *CodeWriter) WriteInit() error {
+	w.SetSource("", 0, "bootstrap")
+	return w.write(w.t.TranslateInit())
+}
+
 func (w *CodeWriter) WriteArithmetic(c string) error {
 	ar := w.t.TranslateArithmetic(c)
 	if ar == "" {
-		return nil
-	}
-
-	_, err := w.bw.WriteString(ar)
-	if err != nil {
-		return err
+		return ErrNoCode
 	}
 
-	return nil
+	return w.write(ar)
 }
 
 func (w *CodeWriter) WritePushPop(c models.CommandType, seg string, i int) error {
+	if err := w.checkSegment(c, seg, i); err != nil {
+		return err
+	}
+
 	p := ""
 	switch c {
 	case models.C_PUSH:
@@ -60,94 +253,83 @@ func (w *CodeWriter) WritePushPop(c models.CommandType, seg string, i int) error
 		p = w.t.TranslatePop(seg, i)
 	}
 	if p == "" {
-		return nil
-	}
-
-	_, err := w.bw.WriteString(p)
-	if err != nil {
-		return err
+		return fmt.Errorf("%w: segment %s", ErrNoCode, seg)
 	}
 
-	return nil
+	return w.write(p)
 }
 
 func (w *CodeWriter) WriteLabel(l string) error {
+	if err := ValidateLabel(l); err != nil {
+		return err
+	}
 	ar := w.t.TranslateLabel(fmt.Sprintf("%s$%s", w.f, l))
 	if ar == "" {
-		return nil
-	}
-
-	_, err := w.bw.WriteString(ar)
-	if err != nil {
-		return err
+		return ErrNoCode
 	}
 
-	return nil
+	return w.write(ar)
 }
 
 func (w *CodeWriter) WriteGoto(l string) error {
+	if err := ValidateLabel(l); err != nil {
+		return err
+	}
 	ar := w.t.TranslateGoto(fmt.Sprintf("%s$%s", w.f, l))
 	if ar == "" {
-		return nil
+		return ErrNoCode
 	}
 
-	_, err := w.bw.WriteString(ar)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return w.write(ar)
 }
 
 func (w *CodeWriter) WriteIf(l string) error {
+	if err := ValidateLabel(l); err != nil {
+		return err
+	}
 	ar := w.t.TranslateIf(fmt.Sprintf("%s$%s", w.f, l))
 	if ar == "" {
-		return nil
-	}
-
-	_, err := w.bw.WriteString(ar)
-	if err != nil {
-		return err
+		return ErrNoCode
 	}
 
-	return nil
+	return w.write(ar)
 }
 
 func (w *CodeWriter) WriteCall(fn string, n int) error {
+	if err := ValidateLabel(fn); err != nil {
+		return err
+	}
 	ar := w.t.TranslateCall(fn, n)
 	if ar == "" {
-		return nil
-	}
-
-	_, err := w.bw.WriteString(ar)
-	if err != nil {
-		return err
+		return ErrNoCode
 	}
 
-	return nil
+	return w.write(ar)
 }
 
 func (w *CodeWriter) WriteReturn() error {
 	ar := w.t.TranslateReturn()
 	if ar == "" {
-		return nil
-	}
-
-	_, err := w.bw.WriteString(ar)
-	if err != nil {
-		return err
+		return ErrNoCode
 	}
 
-	return nil
+	return w.write(ar)
 }
 
 func (w *CodeWriter) WriteFunction(fn string, n int) error {
+	if err := ValidateLabel(fn); err != nil {
+		return err
+	}
 	ar := w.t.TranslateFunction(fn, n)
 	if ar == "" {
-		return nil
+		return ErrNoCode
 	}
 
-	_, err := w.bw.WriteString(ar)
+	w.fn = fn
+	if w.src != nil {
+		w.src.Function = fn
+	}
+	err := w.write(ar)
 	if err != nil {
 		return err
 	}
@@ -156,6 +338,20 @@ func (w *CodeWriter) WriteFunction(fn string, n int) error {
 	return nil
 }
 
+// WriteEnd writes the code the translator puts after the last command, if
+// any.
+func (w *CodeWriter) WriteEnd() error {
+	w.flush()
+	ar := w.t.TranslateEnd()
+	if ar == "" {
+		return nil
+	}
+
+	w.fn = ""
+	w.SetSource("", 0, "shared routines")
+	return w.write(ar)
+}
+
 func (w *CodeWriter) Close() error {
 	defer w.w.Close()
 	err := w.bw.Flush()
@@ -165,3 +361,54 @@ func (w *CodeWriter) Close() error {
 
 	return nil
 }
+
+// ValidateSegment checks the segment and the index of a push or a pop.
+func ValidateSegment(c models.CommandType, seg string, i int) error {
+	if _, ok := segments[seg]; !ok {
+		return fmt.Errorf("%w %s", ErrUnknownSegment, seg)
+	}
+	if c == models.C_POP && seg == "constant" {
+		return ErrPopConstant
+	}
+	if n, ok := segmentSizes[seg]; ok && i >= n {
+		return fmt.Errorf("%w: %s %d is not in 0..%d", ErrOutOfBounds, seg, i, n-1)
+	}
+	if seg == "static" && i >= MaxStatics {
+		return fmt.Errorf("%w: static %d is not in 0..%d", ErrOutOfBounds, i, MaxStatics-1)
+	}
+
+	return nil
+}
+
+func (w *CodeWriter) checkSegment(c models.CommandType, seg string, i int) error {
+	if err := ValidateSegment(c, seg, i); err != nil || seg != "static" {
+		return err
+	}
+
+	v := fmt.Sprintf("%s.%d", w.file, i)
+	if _, ok := w.statics[v]; ok {
+		return nil
+	}
+	if len(w.statics) == MaxStatics {
+		return fmt.Errorf("%w: RAM has room for %d", ErrTooManyStatics, MaxStatics)
+	}
+	w.statics[v] = struct{}{}
+	return nil
+}
+
+// ValidateLabel checks that l is a symbol the assembler accepts.
+func ValidateLabel(l string) error {
+	if l == "" {
+		return fmt.Errorf("%w: label is blank", ErrInvalidLabel)
+	}
+	if utils.Char.IsNumber(rune(l[0])) {
+		return fmt.Errorf("%w %s: label is not allowed to start with number", ErrInvalidLabel, l)
+	}
+	for _, r := range l {
+		if !utils.Char.IsAlphabet(r) && !utils.Char.IsNumber(r) && !strings.ContainsRune("_.:$", r) {
+			return fmt.Errorf("%w %s: allowed label character is alphabet, number, _, ., : or $", ErrInvalidLabel, l)
+		}
+	}
+
+	return nil
+}
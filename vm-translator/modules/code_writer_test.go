@@ -0,0 +1,220 @@
+package modules
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/terashin777/vm-translator/models"
+)
+
+type nopCloser struct {
+	*bytes.Buffer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func newTestWriter(optimize bool) (*CodeWriter, *bytes.Buffer) {
+	b := &bytes.Buffer{}
+	t := NewTranslator()
+	w := NewCodeWriter(nopCloser{b}, t)
+	if optimize {
+		w.SetOptimizer(NewOptimizer(t))
+	}
+
+	return w, b
+}
+
+func TestCodeWriterStaticsAreCountedPerFile(t *testing.T) {
+	for _, optimize := range []bool{false, true} {
+		w, _ := newTestWriter(optimize)
+		w.SetFunctionName("Main")
+		for f := 0; f < 30; f++ {
+			c := &Command{Type: models.C_FUNCTION, Arg1: fmt.Sprintf("Main.f%d", f)}
+			if err := w.WriteCommand(c); err != nil {
+				t.Fatalf("optimize=%v: function: %v", optimize, err)
+			}
+			for i := 0; i < 10; i++ {
+				c := &Command{Type: models.C_PUSH, Arg1: "static", Arg2: i}
+				if err := w.WriteCommand(c); err != nil {
+					t.Fatalf("optimize=%v: Main.f%d static %d: %v", optimize, f, i, err)
+				}
+			}
+		}
+	}
+}
+
+func TestCodeWriterStaticsAreLimitedAcrossFiles(t *testing.T) {
+	for _, optimize := range []bool{false, true} {
+		w, _ := newTestWriter(optimize)
+		var err error
+		for n := 0; n <= MaxStatics && err == nil; n++ {
+			if n%100 == 0 {
+				w.SetFunctionName(fmt.Sprintf("F%d", n/100))
+			}
+			err = w.WriteCommand(&Command{Type: models.C_POP, Arg1: "static", Arg2: n % 100})
+		}
+		if !errors.Is(err, ErrTooManyStatics) {
+			t.Errorf("optimize=%v: err = %v, want %v", optimize, err, ErrTooManyStatics)
+		}
+	}
+}
+
+func TestCodeWriterRejectsBadCommands(t *testing.T) {
+	tests := []struct {
+		c    *Command
+		want error
+	}{
+		{&Command{Type: models.C_PUSH, Arg1: "heap", Arg2: 0}, ErrUnknownSegment},
+		{&Command{Type: models.C_POP, Arg1: "constant", Arg2: 0}, ErrPopConstant},
+		{&Command{Type: models.C_PUSH, Arg1: "temp", Arg2: 8}, ErrOutOfBounds},
+		{&Command{Type: models.C_POP, Arg1: "pointer", Arg2: 2}, ErrOutOfBounds},
+		{&Command{Type: models.C_PUSH, Arg1: "static", Arg2: MaxStatics}, ErrOutOfBounds},
+		{&Command{Type: models.C_LABEL, Arg1: "1LOOP"}, ErrInvalidLabel},
+		{&Command{Type: models.C_GOTO, Arg1: "A-B"}, ErrInvalidLabel},
+		{&Command{Type: models.C_CALL, Arg1: ""}, ErrInvalidLabel},
+		{&Command{Type: models.C_ARITHMETIC, Arg1: "mul"}, ErrNoCode},
+	}
+	for _, tt := range tests {
+		for _, optimize := range []bool{false, true} {
+			w, _ := newTestWriter(optimize)
+			w.SetFunctionName("Main")
+			if err := w.WriteCommand(tt.c); !errors.Is(err, tt.want) {
+				t.Errorf("optimize=%v: %d %s %d: err = %v, want %v", optimize, tt.c.Type, tt.c.Arg1, tt.c.Arg2, err, tt.want)
+			}
+		}
+	}
+}
+
+// vmFile is a .vm file to write for a test.
+type vmFile struct {
+	name string
+	src  string
+}
+
+// readVM writes the files into a directory and reads them as a program.
+func readVM(t *testing.T, files ...vmFile) []*Unit {
+	t.Helper()
+	dir := t.TempDir()
+	fns := []string{}
+	for _, f := range files {
+		fn := filepath.Join(dir, f.name)
+		if err := os.WriteFile(fn, []byte(f.src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fns = append(fns, fn)
+	}
+	p, err := NewParser(fns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := ReadProgram(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return prog
+}
+
+// writeProgram writes prog with w the way the translate command does.
+func writeProgram(t *testing.T, w *CodeWriter, prog []*Unit, bootstrap bool) {
+	t.Helper()
+	for i, u := range prog {
+		w.SetFunctionName(u.Name)
+		if i == 0 && bootstrap {
+			if err := w.WriteInit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, c := range u.Commands {
+			if err := w.WriteCommand(c); err != nil {
+				t.Fatalf("%s:%d: %v", c.File, c.Line, err)
+			}
+		}
+	}
+	if err := w.WriteEnd(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const sysVM = `function Sys.init 0
+push constant 7
+push constant 8
+call Main.add 2
+pop static 0
+label END
+goto END
+`
+
+const mainVM = `function Main.add 1
+push argument 0
+push argument 1
+add
+pop local 0
+push local 0
+return
+`
+
+func TestCodeWriterComments(t *testing.T) {
+	prog := readVM(t, vmFile{"Sys.vm", sysVM}, vmFile{"Main.vm", mainVM})
+	plain, pb := newTestWriter(false)
+	writeProgram(t, plain, prog, true)
+	commented, cb := newTestWriter(false)
+	commented.SetComments(true)
+	writeProgram(t, commented, prog, true)
+
+	out := cb.String()
+	for _, c := range []string{"// bootstrap\n", "// Sys.vm:2: push constant 7\n", "// Sys.vm:6: label END\n", "// Main.vm:4: add\n", "// Main.vm:7: return\n"} {
+		if !strings.Contains(out, c) {
+			t.Errorf("no %q in:\n%s", c, out)
+		}
+	}
+	if strings.Contains(pb.String(), "//") {
+		t.Error("comments are written without SetComments")
+	}
+	if a, b := countInstructions(pb.String()), countInstructions(out); a != b {
+		t.Errorf("comments changed the code from %d to %d instructions", a, b)
+	}
+}
+
+func TestCodeWriterSourceMap(t *testing.T) {
+	for _, optimize := range []bool{false, true} {
+		prog := readVM(t, vmFile{"Sys.vm", sysVM}, vmFile{"Main.vm", mainVM})
+		w, b := newTestWriter(optimize)
+		writeProgram(t, w, prog, true)
+		sm := w.SourceMap()
+
+		if len(sm) == 0 || sm[0].Command != "bootstrap" || sm[0].From != 0 || sm[0].File != "" {
+			t.Fatalf("optimize=%v: the map does not start with the bootstrap: %+v", optimize, sm)
+		}
+		next := 0
+		for _, e := range sm {
+			if e.From != next || e.To < e.From {
+				t.Errorf("optimize=%v: %+v does not follow address %d", optimize, e, next-1)
+			}
+			next = e.To + 1
+		}
+		if n := countInstructions(b.String()); next != n {
+			t.Errorf("optimize=%v: the map covers %d of %d instructions", optimize, next, n)
+		}
+
+		found := false
+		for _, e := range sm {
+			if e.File == "Main.vm" && e.Line == 4 && e.Command == "add" && e.Function == "Main.add" {
+				found = true
+			}
+		}
+		if !found && !optimize {
+			t.Errorf("no entry for Main.vm:4 add in %+v", sm)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package modules
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Labels of the routines compact code shares. $ keeps them apart from the
+// functions of the program, which are named Class.function.
+const (
+	routineEnd    = "VM$END"
+	routineCall   = "VM$CALL"
+	routineReturn = "VM$RETURN"
+)
+
+// routineOrder is the order the routines are written in.
+var routineOrder = []string{routineCall, routineReturn, "VM$JEQ", "VM$JGT", "VM$JLT"}
+
+// compactCall loads the argument count to R13 and the function to R14 and
+// jumps to the call routine with the return address in D.
+func (t *Translator) compactCall(f string, n int, ret string) string {
+	t.used[routineCall] = true
+	nArgs := fmt.Sprintf(`@%d
+D=A
+@R13
+M=D`, n)
+	if n == 0 {
+		nArgs = `@R13
+M=0`
+	}
+
+	return fmt.Sprintf(`%s
+@%s
+D=A
+@R14
+M=D
+@%s
+D=A
+@%s
+0;JMP
+(%s)
+`, nArgs, f, ret, routineCall, ret)
+}
+
+// compactCondition jumps to the comparison routine of jmp with the return
+// address in D.
+func (t *Translator) compactCondition(jmp, ret string) string {
+	r := "VM$" + jmp
+	t.used[r] = true
+	return fmt.Sprintf(`@%s
+D=A
+@%s
+0;JMP
+(%s)
+`, ret, r, ret)
+}
+
+// TranslateEnd returns the routines compact code used, behind a loop that
+// stops programs running off their last command. Inline code needs nothing.
+func (t *Translator) TranslateEnd() string {
+	if len(t.used) == 0 {
+		return ""
+	}
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, `(%[1]s)
+@%[1]s
+0;JMP
+`, routineEnd)
+	for _, r := range routineOrder {
+		if !t.used[r] {
+			continue
+		}
+		switch r {
+		case routineCall:
+			b.WriteString(t.callRoutine())
+		case routineReturn:
+			fmt.Fprintf(b, "(%s)\n%s", r, t.translateReturn())
+		default:
+			b.WriteString(t.conditionRoutine(strings.TrimPrefix(r, "VM$")))
+		}
+	}
+
+	return b.String()
+}
+
+// callRoutine pushes the return address in D and the frame of the caller,
+// then jumps to the function in R14 with R13 arguments.
+func (t *Translator) callRoutine() string {
+	return fmt.Sprintf(`(%[1]s)
+%[2]s
+%[3]s
+%[4]s
+%[5]s
+%[6]s
+@R13
+D=M
+@5
+D=D+A
+@SP
+D=M-D
+@ARG
+M=D
+@SP
+D=M
+@LCL
+M=D
+@R14
+A=M
+0;JMP
+`,
+		routineCall,
+		t.push(),
+		t.pushSegmentAddress("LCL"),
+		t.pushSegmentAddress("ARG"),
+		t.pushSegmentAddress("THIS"),
+		t.pushSegmentAddress("THAT"),
+	)
+}
+
+// conditionRoutine compares the two values on top of the stack as the
+// inline code does and returns to the address in D, kept in R15.
+func (t *Translator) conditionRoutine(jmp string) string {
+	return fmt.Sprintf(`(VM$%[1]s)
+@R15
+M=D
+@SP
+AM=M-1
+D=M
+A=A-1
+D=M-D
+M=-1
+@VM$%[1]s_TRUE
+D;%[1]s
+@SP
+A=M-1
+M=0
+(VM$%[1]s_TRUE)
+@R15
+A=M
+0;JMP
+`, jmp)
+}
@@ -0,0 +1,67 @@
+package modules
+
+import (
+	"strings"
+	"testing"
+)
+
+// translateCalls returns the code of n calls and comparisons followed by
+// the end of the program.
+func translateCalls(compact bool, n int) string {
+	t := NewTranslator()
+	t.SetCompact(compact)
+	t.SetFunctionName("Main")
+	b := &strings.Builder{}
+	b.WriteString(t.TranslateFunction("Main.main", 0))
+	for i := 0; i < n; i++ {
+		b.WriteString(t.TranslateCall("Main.f", 2))
+		b.WriteString(t.TranslateArithmetic("eq"))
+	}
+	b.WriteString(t.TranslateReturn())
+	b.WriteString(t.TranslateEnd())
+
+	return b.String()
+}
+
+func TestCompactIsSmaller(t *testing.T) {
+	for _, n := range []int{3, 10} {
+		inline := countInstructions(translateCalls(false, n))
+		compact := countInstructions(translateCalls(true, n))
+		if compact >= inline {
+			t.Errorf("%d calls: compact is %d instructions, inline %d", n, compact, inline)
+		}
+	}
+
+	// The routines are written once, so each further call costs only the
+	// jump to them.
+	cost := func(compact bool) int {
+		return countInstructions(translateCalls(compact, 11)) - countInstructions(translateCalls(compact, 10))
+	}
+	if c, i := cost(true), cost(false); c != 16 || i <= c {
+		t.Errorf("a call and an eq cost %d instructions compact, %d inline", c, i)
+	}
+}
+
+func TestCompactWritesUsedRoutinesOnce(t *testing.T) {
+	code := translateCalls(true, 3)
+	for _, r := range []string{routineEnd, routineCall, routineReturn, "VM$JEQ"} {
+		if n := strings.Count(code, "("+r+")"); n != 1 {
+			t.Errorf("(%s) is written %d times", r, n)
+		}
+	}
+	for _, r := range []string{"VM$JGT", "VM$JLT"} {
+		if strings.Contains(code, "("+r+")") {
+			t.Errorf("unused %s is written", r)
+		}
+	}
+
+	if code := translateCalls(false, 3); strings.Contains(code, "VM$") {
+		t.Errorf("inline code has routines:\n%s", code)
+	}
+
+	tr := NewTranslator()
+	tr.SetCompact(true)
+	if end := tr.TranslateEnd(); end != "" {
+		t.Errorf("a program using no routines ends with:\n%s", end)
+	}
+}
@@ -0,0 +1,50 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type (
+	// Error is a VM command that can not be translated, with the position of
+	// the command.
+	Error struct {
+		File    string
+		Line    int
+		Command string
+		Err     error
+	}
+
+	ErrorList []*Error
+)
+
+// Problems found in VM commands. Errors of the translation wrap them, so
+// callers can tell them apart with errors.Is.
+var (
+	ErrUnknownCommand = errors.New("unknown command")
+	ErrInvalidIndex   = errors.New("invalid index")
+	ErrUnknownSegment = errors.New("unknown segment")
+	ErrOutOfBounds    = errors.New("index is out of bounds")
+	ErrPopConstant    = errors.New("can not pop to constant")
+	ErrTooManyStatics = errors.New("too many static variables")
+	ErrInvalidLabel   = errors.New("invalid label")
+	ErrNoCode         = errors.New("translator has no code for the command")
+)
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d: %s: %q", e.File, e.Line, e.Err, e.Command)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (l ErrorList) Error() string {
+	ss := make([]string, 0, len(l))
+	for _, e := range l {
+		ss = append(ss, e.Error())
+	}
+
+	return strings.Join(ss, "\n")
+}
@@ -0,0 +1,105 @@
+package modules
+
+import (
+	"strings"
+
+	"github.com/terashin777/vm-translator/models"
+)
+
+// Command is a VM command as the parser read it, with where it comes from.
+type Command struct {
+	Type models.CommandType
+	Arg1 string
+	Arg2 int
+	File string
+	Line int
+	Text string
+}
+
+// ReadCommand returns the current command of p. The index or the count of
+// push, pop, call and function is checked here, the rest by the writer.
+func ReadCommand(p *Parser) (*Command, error) {
+	c := &Command{
+		Type: p.CommandType(),
+		Arg1: p.Arg1(),
+		File: p.File(),
+		Line: p.Line(),
+		Text: p.Command(),
+	}
+	switch c.Type {
+	case models.C_NONE:
+		return c, ErrUnknownCommand
+	case models.C_PUSH, models.C_POP, models.C_CALL, models.C_FUNCTION:
+		i, err := p.Arg2()
+		c.Arg2 = i
+		return c, err
+	}
+
+	return c, nil
+}
+
+// SplitBlocks splits cmds into basic blocks. A block starts at a function or
+// a label, which can be jumped to, and ends after a goto, an if-goto, a call
+// or a return, which jump away.
+func SplitBlocks(cmds []*Command) [][]*Command {
+	bs := [][]*Command{}
+	from := 0
+	for i, c := range cmds {
+		switch c.Type {
+		case models.C_FUNCTION, models.C_LABEL:
+			if i > from {
+				bs = append(bs, cmds[from:i])
+				from = i
+			}
+		case models.C_GOTO, models.C_IF, models.C_CALL, models.C_RETURN:
+			bs = append(bs, cmds[from:i+1])
+			from = i + 1
+		}
+	}
+	if from < len(cmds) {
+		bs = append(bs, cmds[from:])
+	}
+
+	return bs
+}
+
+// translate returns the code t makes of c. Labels must already be scoped to
+// their function.
+func translate(t models.ITranslator, c *Command) string {
+	switch c.Type {
+	case models.C_ARITHMETIC:
+		return t.TranslateArithmetic(c.Arg1)
+	case models.C_PUSH:
+		return t.TranslatePush(c.Arg1, c.Arg2)
+	case models.C_POP:
+		return t.TranslatePop(c.Arg1, c.Arg2)
+	case models.C_LABEL:
+		return t.TranslateLabel(c.Arg1)
+	case models.C_GOTO:
+		return t.TranslateGoto(c.Arg1)
+	case models.C_IF:
+		return t.TranslateIf(c.Arg1)
+	case models.C_CALL:
+		return t.TranslateCall(c.Arg1, c.Arg2)
+	case models.C_RETURN:
+		return t.TranslateReturn()
+	case models.C_FUNCTION:
+		return t.TranslateFunction(c.Arg1, c.Arg2)
+	}
+
+	return ""
+}
+
+// countInstructions counts the Hack instructions of code, leaving out labels
+// and comments.
+func countInstructions(code string) int {
+	n := 0
+	for _, l := range strings.Split(code, "\n") {
+		l = strings.TrimSpace(l)
+		if l != "" && !strings.HasPrefix(l, "(") && !strings.HasPrefix(l, "//") {
+			n++
+		}
+	}
+
+	return n
+}
@@ -0,0 +1,387 @@
+package modules
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/terashin777/vm-translator/models"
+)
+
+// Kinds of the value on top of the stack that the optimizer has not pushed
+// to RAM yet. Pushes only say where the value is and the command using it
+// loads it the cheapest way, so it never goes through the stack.
+const (
+	// opNone is a top that is in RAM at SP-1.
+	opNone = iota
+	// opD is a top in D.
+	opD
+	// opConst is the constant n.
+	opConst
+	// opAddr is a top at the fixed address addr, a static, temp or pointer.
+	opAddr
+	// opSeg is a top at the address in reg plus i.
+	opSeg
+	// opCond is the result of a comparison. D holds x-y and the value is
+	// true when jmp jumps on D.
+	opCond
+)
+
+type operand struct {
+	kind int
+	n    int
+	addr string
+	reg  string
+	i    int
+	jmp  string
+}
+
+var binaryOps = map[string]string{
+	"add": "D=D+M",
+	"sub": "D=M-D",
+	"and": "D=D&M",
+	"or":  "D=D|M",
+}
+
+var constOps = map[string]string{
+	"add": "D=D+A",
+	"sub": "D=D-A",
+	"and": "D=D&A",
+	"or":  "D=D|A",
+}
+
+var compareJumps = map[string]string{
+	"eq": "JEQ",
+	"gt": "JGT",
+	"lt": "JLT",
+}
+
+var negatedJumps = map[string]string{
+	"JEQ": "JNE",
+	"JNE": "JEQ",
+	"JGT": "JLE",
+	"JLE": "JGT",
+	"JLT": "JGE",
+	"JGE": "JLT",
+}
+
+// Saving is how many instructions the optimizer saved in a function.
+type Saving struct {
+	Function string
+	Before   int
+	After    int
+}
+
+// Optimizer translates the commands of a function to Hack a basic block at a
+// time, keeping the top of the stack out of RAM within a block. Calls,
+// returns and jumps are left to the translator.
+type Optimizer struct {
+	t    *Translator
+	base *Translator
+	top  operand
+	b    *strings.Builder
+}
+
+func NewOptimizer(t *Translator) *Optimizer {
+	base := NewTranslator()
+	base.compact = t.compact
+
+	return &Optimizer{
+		t:    t,
+		base: base,
+	}
+}
+
+// Translate returns the code of each command of cmds, which are the commands
+// of a function or of a file without functions, and what it saved against
+// the translator. Labels must already be scoped to their function.
+func (o *Optimizer) Translate(cmds []*Command) ([]string, *Saving) {
+	s := &Saving{Function: o.t.fileName}
+	o.base.SetFunctionName(o.t.fileName)
+	code := []string{}
+	for _, blk := range SplitBlocks(cmds) {
+		o.top = operand{}
+		for i, c := range blk {
+			if c.Type == models.C_FUNCTION {
+				s.Function = c.Arg1
+			}
+			o.b = &strings.Builder{}
+			o.command(c)
+			if i == len(blk)-1 {
+				o.spill()
+			}
+			code = append(code, o.b.String())
+			s.Before += countInstructions(translate(o.base, c))
+			s.After += countInstructions(o.b.String())
+		}
+	}
+
+	return code, s
+}
+
+func (o *Optimizer) command(c *Command) {
+	switch c.Type {
+	case models.C_PUSH:
+		o.spill()
+		o.push(c.Arg1, c.Arg2)
+	case models.C_POP:
+		o.pop(c.Arg1, c.Arg2)
+	case models.C_ARITHMETIC:
+		o.arithmetic(c.Arg1)
+	case models.C_IF:
+		o.ifGoto(c.Arg1)
+	case models.C_FUNCTION:
+		o.function(c.Arg1, c.Arg2)
+	default:
+		o.spill()
+		o.b.WriteString(translate(o.t, c))
+	}
+}
+
+func (o *Optimizer) emit(ls ...string) {
+	for _, l := range ls {
+		o.b.WriteString(l + "\n")
+	}
+}
+
+func (o *Optimizer) push(seg string, i int) {
+	switch seg {
+	case "constant":
+		o.top = operand{kind: opConst, n: i}
+	case "local", "argument", "this", "that":
+		o.top = operand{kind: opSeg, reg: memoryMap[seg], i: i}
+	default:
+		o.top = operand{kind: opAddr, addr: o.address(seg, i)}
+	}
+}
+
+// address returns the symbol of a segment at a fixed address.
+func (o *Optimizer) address(seg string, i int) string {
+	if seg == "static" {
+		return fmt.Sprintf("%s.%d", o.t.fileName, i)
+	}
+
+	return fmt.Sprint(baseAddrMap[seg] + i)
+}
+
+// spill pushes the top to RAM.
+func (o *Optimizer) spill() {
+	switch {
+	case o.top.kind == opNone:
+		return
+	case o.isSmall():
+		o.emit("@SP", "AM=M+1", "A=A-1", fmt.Sprintf("M=%d", o.top.n))
+	default:
+		o.toD()
+		o.emit("@SP", "AM=M+1", "A=A-1", "M=D")
+	}
+	o.top = operand{}
+}
+
+// isSmall tells whether the top is a constant the ALU makes by itself.
+func (o *Optimizer) isSmall() bool {
+	return o.top.kind == opConst && o.top.n >= -1 && o.top.n <= 1
+}
+
+// toD loads the top to D.
+func (o *Optimizer) toD() {
+	t := o.top
+	switch t.kind {
+	case opNone:
+		o.emit("@SP", "AM=M-1", "D=M")
+	case opConst:
+		switch {
+		case t.n >= -1 && t.n <= 1:
+			o.emit(fmt.Sprintf("D=%d", t.n))
+		case t.n > 0:
+			o.emit(fmt.Sprintf("@%d", t.n), "D=A")
+		case t.n > -32768:
+			o.emit(fmt.Sprintf("@%d", -t.n), "D=-A")
+		default:
+			o.emit("@32767", "D=-A", "D=D-1")
+		}
+	case opAddr:
+		o.emit("@"+t.addr, "D=M")
+	case opSeg:
+		o.segmentToA(t.reg, t.i, false)
+		o.emit("D=M")
+	case opCond:
+		o.t.symbolCount++
+		yes := fmt.Sprintf("%s_TRUE%d", t.jmp, o.t.symbolCount)
+		end := fmt.Sprintf("%s_END%d", t.jmp, o.t.symbolCount)
+		o.emit("@"+yes, "D;"+t.jmp, "D=0", "@"+end, "0;JMP", "("+yes+")", "D=-1", "("+end+")")
+	}
+	o.top = operand{kind: opD}
+}
+
+// segmentToA sets A to the address in reg plus i. Small indexes are added one
+// by one, which keeps D when keepD is set. Larger ones need keepD unset.
+func (o *Optimizer) segmentToA(reg string, i int, keepD bool) {
+	limit := 3
+	if keepD {
+		limit = 8
+	}
+
+	switch {
+	case i == 0:
+		o.emit("@"+reg, "A=M")
+	case i <= limit:
+		o.emit("@"+reg, "A=M+1")
+		for j := 1; j < i; j++ {
+			o.emit("A=A+1")
+		}
+	default:
+		o.emit("@"+reg, "D=M", fmt.Sprintf("@%d", i), "A=D+A")
+	}
+}
+
+func (o *Optimizer) pop(seg string, i int) {
+	defer func() { o.top = operand{} }()
+
+	if _, ok := memoryMap[seg]; !ok {
+		a := o.address(seg, i)
+		if o.isSmall() {
+			o.emit("@"+a, fmt.Sprintf("M=%d", o.top.n))
+			return
+		}
+		o.toD()
+		o.emit("@"+a, "M=D")
+		return
+	}
+
+	reg := memoryMap[seg]
+	if o.isSmall() {
+		o.segmentToA(reg, i, false)
+		o.emit(fmt.Sprintf("M=%d", o.top.n))
+		return
+	}
+	o.toD()
+	if i <= 8 {
+		o.segmentToA(reg, i, true)
+		o.emit("M=D")
+		return
+	}
+	// D is the value, so the address is made in D+value and the value taken
+	// back off it.
+	o.emit("@R13", "M=D", "@"+reg, "D=D+M", fmt.Sprintf("@%d", i), "D=D+A", "@R13", "A=D-M", "D=D-A", "M=D")
+}
+
+func (o *Optimizer) arithmetic(c string) {
+	switch c {
+	case "neg", "not":
+		o.unary(c)
+	case "eq", "gt", "lt":
+		o.compare(compareJumps[c])
+	default:
+		o.binary(c)
+	}
+}
+
+func (o *Optimizer) unary(c string) {
+	op := "-"
+	if c == "not" {
+		op = "!"
+	}
+
+	t := o.top
+	switch t.kind {
+	case opConst:
+		if c == "not" {
+			o.top.n = int(^int16(t.n))
+		} else {
+			o.top.n = int(-int16(t.n))
+		}
+		return
+	case opCond:
+		if c == "not" {
+			o.top.jmp = negatedJumps[t.jmp]
+			return
+		}
+		o.toD()
+		o.emit("D=-D")
+	case opNone:
+		o.emit("@SP", "A=M-1", "M="+op+"M")
+		return
+	case opAddr:
+		o.emit("@"+t.addr, "D="+op+"M")
+	case opSeg:
+		o.segmentToA(t.reg, t.i, false)
+		o.emit("D=" + op + "M")
+	case opD:
+		o.emit("D=" + op + "D")
+	}
+	o.top = operand{kind: opD}
+}
+
+func (o *Optimizer) binary(c string) {
+	t := o.top
+	switch {
+	case t.kind == opConst && (c == "add" || c == "sub") && t.n >= 0 && t.n <= 1:
+		o.emit("@SP", "AM=M-1")
+		switch {
+		case t.n == 0:
+			o.emit("D=M")
+		case c == "add":
+			o.emit("D=M+1")
+		default:
+			o.emit("D=M-1")
+		}
+	case t.kind == opConst && t.n >= 0:
+		o.emit("@SP", "AM=M-1", "D=M", fmt.Sprintf("@%d", t.n), constOps[c])
+	default:
+		o.toD()
+		o.emit("@SP", "AM=M-1", binaryOps[c])
+	}
+	o.top = operand{kind: opD}
+}
+
+// compare computes x-y in D, leaving the jump to whoever uses the result.
+func (o *Optimizer) compare(jmp string) {
+	t := o.top
+	switch {
+	case t.kind == opConst && t.n == 0:
+		o.emit("@SP", "AM=M-1", "D=M")
+	case t.kind == opConst && t.n > 0:
+		o.emit("@SP", "AM=M-1", "D=M", fmt.Sprintf("@%d", t.n), "D=D-A")
+	default:
+		o.toD()
+		o.emit("@SP", "AM=M-1", "D=M-D")
+	}
+	o.top = operand{kind: opCond, jmp: jmp}
+}
+
+func (o *Optimizer) ifGoto(l string) {
+	t := o.top
+	switch t.kind {
+	case opCond:
+		o.emit("@"+l, "D;"+t.jmp)
+	case opConst:
+		if t.n != 0 {
+			o.emit("@"+l, "0;JMP")
+		}
+	default:
+		o.toD()
+		o.emit("@"+l, "D;JNE")
+	}
+	o.top = operand{}
+}
+
+// function pushes a few locals one by one, as a loop costs more.
+func (o *Optimizer) function(f string, k int) {
+	if k > 3 {
+		o.b.WriteString(o.t.TranslateFunction(f, k))
+		return
+	}
+
+	o.emit("(" + f + ")")
+	switch k {
+	case 0:
+	case 1:
+		o.emit("@SP", "AM=M+1", "A=A-1", "M=0")
+	default:
+		o.emit("@SP", "A=M")
+		for j := 0; j < k; j++ {
+			o.emit("M=0", "A=A+1")
+		}
+		o.emit("D=A", "@SP", "M=D")
+	}
+}
@@ -0,0 +1,82 @@
+package modules
+
+import (
+	"testing"
+
+	"github.com/terashin777/vm-translator/models"
+)
+
+func TestSplitBlocks(t *testing.T) {
+	prog := readVM(t, vmFile{"Sys.vm", sysVM}, vmFile{"Main.vm", mainVM})
+	cmds := append(prog[0].Commands, prog[1].Commands...)
+	bs := SplitBlocks(cmds)
+
+	// Sys.init splits after the call, at the label and after the goto.
+	want := []int{4, 1, 2, 7}
+	if len(bs) != len(want) {
+		t.Fatalf("%d blocks, want %d", len(bs), len(want))
+	}
+	n := 0
+	for i, b := range bs {
+		if len(b) != want[i] {
+			t.Errorf("block %d has %d commands, want %d", i, len(b), want[i])
+		}
+		n += len(b)
+	}
+	if n != len(cmds) {
+		t.Errorf("blocks have %d commands, want %d", n, len(cmds))
+	}
+	if bs[2][0].Type != models.C_LABEL || bs[3][0].Type != models.C_FUNCTION {
+		t.Errorf("blocks do not start at the label and the function")
+	}
+}
+
+func TestOptimizerTranslate(t *testing.T) {
+	prog := readVM(t, vmFile{"Main.vm", mainVM})
+	tr := NewTranslator()
+	tr.SetFunctionName("Main")
+	code, s := NewOptimizer(tr).Translate(prog[0].Commands)
+
+	if len(code) != len(prog[0].Commands) {
+		t.Fatalf("%d codes for %d commands", len(code), len(prog[0].Commands))
+	}
+	if s.Function != "Main.add" {
+		t.Errorf("saving of %s", s.Function)
+	}
+	after := 0
+	for _, c := range code {
+		after += countInstructions(c)
+	}
+	if s.After != after || s.After >= s.Before {
+		t.Errorf("saved %d to %d, code is %d", s.Before, s.After, after)
+	}
+
+	// The second argument is added without going through the stack.
+	base := NewTranslator()
+	before := 0
+	for _, c := range prog[0].Commands[1:4] {
+		before += countInstructions(translate(base, c))
+	}
+	if n := countInstructions(code[1] + code[2] + code[3]); n >= before {
+		t.Errorf("push, push and add are %d instructions, %d unoptimized", n, before)
+	}
+}
+
+func TestOptimizerSavings(t *testing.T) {
+	prog := readVM(t, vmFile{"Sys.vm", sysVM}, vmFile{"Main.vm", mainVM})
+	w, _ := newTestWriter(true)
+	writeProgram(t, w, prog, true)
+
+	ss := w.Savings()
+	if len(ss) != 2 {
+		t.Fatalf("%d savings, want 2", len(ss))
+	}
+	for i, fn := range []string{"Sys.init", "Main.add"} {
+		if ss[i].Function != fn || ss[i].After > ss[i].Before {
+			t.Errorf("saving %d = %+v, want one of %s", i, *ss[i], fn)
+		}
+	}
+	if ss[1].After == ss[1].Before {
+		t.Errorf("nothing saved in Main.add")
+	}
+}
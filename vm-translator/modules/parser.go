@@ -32,6 +32,8 @@ type Parser struct {
 	s     *bufio.Scanner
 	parts []string
 	f     string
+	// no is the line number of the current command in the current file.
+	no int
 }
 
 func NewParser(fns []string) (*Parser, error) {
@@ -56,7 +58,7 @@ func (p *Parser) Advance() error {
 		return err
 	}
 
-	p.parts = strings.Split(t, " ")
+	p.parts = strings.Fields(t)
 	return nil
 }
 
@@ -79,6 +81,7 @@ func (p *Parser) initByCurrentFile(src string) (string, error) {
 	p.r = f
 	p.s = bufio.NewScanner(f)
 	p.parts = nil
+	p.no = 0
 	return utils.Filepath.FileNameWithoutExt(filepath.Base(fn)), nil
 }
 
@@ -88,6 +91,7 @@ func (p *Parser) scan() (string, error) {
 		if !next {
 			return "", io.EOF
 		}
+		p.no++
 
 		t := p.extractCommand(p.s.Text())
 		if t == "" {
@@ -114,6 +118,21 @@ func (p *Parser) removeComment(t string) string {
 	return strings.TrimSpace(strings.Split(t, "//")[0])
 }
 
+// File returns the path of the file the current command is in.
+func (p *Parser) File() string {
+	return p.fns[p.cur]
+}
+
+// Line returns the line number of the current command.
+func (p *Parser) Line() int {
+	return p.no
+}
+
+// Command returns the current command without its comment.
+func (p *Parser) Command() string {
+	return strings.Join(p.parts, " ")
+}
+
 func (p *Parser) CommandType() models.CommandType {
 	switch true {
 	case p.isPush():
@@ -191,15 +210,17 @@ func (p *Parser) Arg1() string {
 	return p.parts[1]
 }
 
-func (p *Parser) Arg2() int {
+// Arg2 returns the index or the count of the current command, which is 0
+// to 32767.
+func (p *Parser) Arg2() (int, error) {
 	if len(p.parts) < 3 {
-		return -1
+		return 0, fmt.Errorf("%w: missing", ErrInvalidIndex)
 	}
 
 	i, err := strconv.ParseInt(p.parts[2], 10, 16)
-	if err != nil {
-		return -1
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("%w %s", ErrInvalidIndex, p.parts[2])
 	}
 
-	return int(i)
+	return int(i), nil
 }
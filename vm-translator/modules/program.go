@@ -0,0 +1,138 @@
+package modules
+
+import (
+	"io"
+
+	"github.com/terashin777/vm-translator/models"
+)
+
+// Unit is the commands of a .vm file. Name is the file name without its
+// extension, which names its statics.
+type Unit struct {
+	Name     string
+	File     string
+	Commands []*Command
+}
+
+// Dropped is a function left out of the program as it can never run.
+type Dropped struct {
+	Function string
+	File     string
+	Commands int
+}
+
+// ReadProgram reads the commands of every file of p. Commands that can not
+// be read are left out and reported together as an ErrorList after the last
+// file, with the program read.
+func ReadProgram(p *Parser) ([]*Unit, error) {
+	prog := []*Unit{}
+	errs := ErrorList{}
+	for {
+		n, err := p.NextFile()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		u := &Unit{Name: n, File: p.File(), Commands: []*Command{}}
+		for {
+			err := p.Advance()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			c, err := ReadCommand(p)
+			if err != nil {
+				errs = append(errs, &Error{
+					File:    c.File,
+					Line:    c.Line,
+					Command: c.Text,
+					Err:     err,
+				})
+				continue
+			}
+			u.Commands = append(u.Commands, c)
+		}
+		prog = append(prog, u)
+	}
+	if len(errs) > 0 {
+		return prog, errs
+	}
+
+	return prog, nil
+}
+
+// DropUnreachable leaves out the functions of prog no call can reach and
+// returns them in program order. A bootstrapped program starts at Sys.init.
+// Otherwise it starts at Main.main and at its first command, which runs into
+// the first function. Commands outside of functions are kept and what they
+// call is reachable.
+func DropUnreachable(prog []*Unit, bootstrap bool) ([]*Unit, []*Dropped) {
+	calls := map[string][]string{}
+	roots := []string{}
+	if bootstrap {
+		roots = append(roots, "Sys.init")
+	} else {
+		roots = append(roots, "Main.main")
+	}
+	first := true
+	for _, u := range prog {
+		fn := ""
+		for _, c := range u.Commands {
+			switch c.Type {
+			case models.C_FUNCTION:
+				fn = c.Arg1
+				if first && !bootstrap {
+					roots = append(roots, fn)
+				}
+				first = false
+			case models.C_CALL:
+				if fn == "" {
+					roots = append(roots, c.Arg1)
+				} else {
+					calls[fn] = append(calls[fn], c.Arg1)
+				}
+			}
+		}
+	}
+
+	reached := map[string]bool{}
+	for len(roots) > 0 {
+		fn := roots[len(roots)-1]
+		roots = roots[:len(roots)-1]
+		if reached[fn] {
+			continue
+		}
+		reached[fn] = true
+		roots = append(roots, calls[fn]...)
+	}
+
+	kept := make([]*Unit, 0, len(prog))
+	dropped := []*Dropped{}
+	for _, u := range prog {
+		k := &Unit{Name: u.Name, File: u.File, Commands: []*Command{}}
+		var d *Dropped
+		for _, c := range u.Commands {
+			if c.Type == models.C_FUNCTION {
+				d = nil
+				if !reached[c.Arg1] {
+					d = &Dropped{Function: c.Arg1, File: u.File}
+					dropped = append(dropped, d)
+				}
+			}
+			if d != nil {
+				d.Commands++
+				continue
+			}
+			k.Commands = append(k.Commands, c)
+		}
+		kept = append(kept, k)
+	}
+
+	return kept, dropped
+}
@@ -0,0 +1,151 @@
+package modules
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/terashin777/vm-translator/models"
+)
+
+func TestReadProgramReportsErrors(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "Bad.vm")
+	src := "push constant 1\n// comment\nfrob\npush local x\ncall Main.f\n  add  // ok\n"
+	if err := os.WriteFile(fn, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewParser([]string{fn})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prog, err := ReadProgram(p)
+	var errs ErrorList
+	if !errors.As(err, &errs) {
+		t.Fatalf("err = %v, want an ErrorList", err)
+	}
+	want := []struct {
+		line int
+		is   error
+		text string
+	}{
+		{3, ErrUnknownCommand, "frob"},
+		{4, ErrInvalidIndex, "push local x"},
+		{5, ErrUnknownCommand, "call Main.f"},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("got %d errors, want %d:\n%v", len(errs), len(want), errs)
+	}
+	for i, w := range want {
+		e := errs[i]
+		if e.File != fn || e.Line != w.line || !errors.Is(e, w.is) || e.Command != w.text {
+			t.Errorf("error %d is %v, want %v at line %d", i, e, w.is, w.line)
+		}
+	}
+	if !strings.HasPrefix(errs[0].Error(), fn+":3: unknown command") {
+		t.Errorf("error reads %s", errs[0])
+	}
+
+	if len(prog) != 1 || len(prog[0].Commands) != 2 || prog[0].Commands[1].Type != models.C_ARITHMETIC {
+		t.Errorf("the valid commands are not kept: %+v", prog)
+	}
+}
+
+const libVM = `function Lib.used 0
+call Lib.deep 0
+return
+function Lib.deep 0
+push constant 0
+return
+function Lib.unused 0
+call Lib.deep 0
+call Lib.alsoUnused 0
+return
+function Lib.alsoUnused 1
+call Lib.unused 0
+return
+`
+
+// functionsOf returns the functions of prog in order.
+func functionsOf(prog []*Unit) []string {
+	fns := []string{}
+	for _, u := range prog {
+		for _, c := range u.Commands {
+			if c.Type == models.C_FUNCTION {
+				fns = append(fns, c.Arg1)
+			}
+		}
+	}
+
+	return fns
+}
+
+func TestDropUnreachable(t *testing.T) {
+	const sys = "function Sys.init 0\ncall Lib.used 0\nlabel END\ngoto END\n"
+	const main = "function Main.main 0\ncall Lib.deep 0\nreturn\n"
+	const first = "function Other.first 0\ncall Lib.used 0\nreturn\n"
+	const top = "push constant 1\ncall Lib.unused 1\n"
+	tests := []struct {
+		files     []vmFile
+		bootstrap bool
+		kept      []string
+		dropped   []string
+	}{
+		{
+			[]vmFile{{"Sys.vm", sys}, {"Main.vm", main}, {"Lib.vm", libVM}}, true,
+			[]string{"Sys.init", "Lib.used", "Lib.deep"},
+			[]string{"Main.main", "Lib.unused", "Lib.alsoUnused"},
+		},
+		{
+			[]vmFile{{"Main.vm", main}, {"Lib.vm", libVM}}, false,
+			[]string{"Main.main", "Lib.deep"},
+			[]string{"Lib.used", "Lib.unused", "Lib.alsoUnused"},
+		},
+		{
+			// The first function is run into without a call.
+			[]vmFile{{"Other.vm", first}, {"Lib.vm", libVM}}, false,
+			[]string{"Other.first", "Lib.used", "Lib.deep"},
+			[]string{"Lib.unused", "Lib.alsoUnused"},
+		},
+		{
+			// Calls outside of functions are reachable, cycles included.
+			[]vmFile{{"A.vm", top}, {"Lib.vm", libVM}}, false,
+			[]string{"Lib.used", "Lib.deep", "Lib.unused", "Lib.alsoUnused"},
+			[]string{},
+		},
+	}
+	for i, tt := range tests {
+		kept, dropped := DropUnreachable(readVM(t, tt.files...), tt.bootstrap)
+		if got := functionsOf(kept); strings.Join(got, " ") != strings.Join(tt.kept, " ") {
+			t.Errorf("%d: kept %v, want %v", i, got, tt.kept)
+		}
+		got := []string{}
+		for _, d := range dropped {
+			got = append(got, d.Function)
+		}
+		if strings.Join(got, " ") != strings.Join(tt.dropped, " ") {
+			t.Errorf("%d: dropped %v, want %v", i, got, tt.dropped)
+		}
+	}
+}
+
+func TestDropUnreachableCountsCommands(t *testing.T) {
+	prog := readVM(t, vmFile{"Main.vm", "push constant 0\npop temp 0\n"}, vmFile{"Lib.vm", libVM})
+	kept, dropped := DropUnreachable(prog, false)
+	// The commands outside of functions run into Lib.used.
+	if len(kept) != 2 || len(kept[0].Commands) != 2 || len(kept[1].Commands) != 6 {
+		t.Errorf("kept %v", functionsOf(kept))
+	}
+	want := []int{4, 3}
+	if len(dropped) != len(want) {
+		t.Fatalf("dropped %d functions", len(dropped))
+	}
+	for i, d := range dropped {
+		if d.Commands != want[i] || d.File != prog[1].File {
+			t.Errorf("dropped %+v, want %d commands of %s", *d, want[i], prog[1].File)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package modules
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// SourceEntry tells that the instructions at the ROM addresses From to To of
+// the translated code come from a VM command. Function is empty outside
+// functions and File is empty for the bootstrap code.
+type SourceEntry struct {
+	From     int    `json:"from"`
+	To       int    `json:"to"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Function string `json:"function"`
+	Command  string `json:"command"`
+}
+
+// WriteSourceMap writes the entries as JSON.
+func WriteSourceMap(w io.Writer, es []*SourceEntry) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(es)
+}
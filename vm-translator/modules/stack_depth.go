@@ -0,0 +1,201 @@
+package modules
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/terashin777/vm-translator/models"
+)
+
+// growing is the depth of a stack a loop grows without bound while the
+// depths are worked out. The function reports it as Unbounded.
+const growing = math.MaxInt32
+
+// Issue is a problem with the stack of a function the analysis found.
+type Issue struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Function string `json:"function"`
+	Command  string `json:"command"`
+	Message  string `json:"message"`
+}
+
+func (i *Issue) String() string {
+	return fmt.Sprintf("%s:%d: %s: %q", i.File, i.Line, i.Message, i.Command)
+}
+
+// depthRange is the least and the most values the operand stack may hold
+// before a command, over every path to it.
+type depthRange struct {
+	lo int
+	hi int
+}
+
+// callSite is a call of a function with the depth of the stack before it,
+// the arguments included.
+type callSite struct {
+	function string
+	depth    int
+}
+
+// stackEffect returns how many values c takes off the stack and how many
+// it adds to the stack in all.
+func stackEffect(c *Command) (int, int) {
+	switch c.Type {
+	case models.C_PUSH:
+		return 0, 1
+	case models.C_POP, models.C_IF:
+		return 1, -1
+	case models.C_ARITHMETIC:
+		if c.Arg1 == "neg" || c.Arg1 == "not" {
+			return 1, 0
+		}
+		return 2, -1
+	case models.C_CALL:
+		return c.Arg2, 1 - c.Arg2
+	case models.C_RETURN:
+		return 1, 0
+	}
+
+	return 0, 0
+}
+
+// analyzeDepth works out the depth of the operand stack before each command
+// of f by following every path from its start, and sets the most it gets to
+// and the calls it makes. A depth higher than all the pushes of f together
+// can only come from a loop, so it is taken as unbounded.
+func (f *FunctionInfo) analyzeDepth() []*Issue {
+	labels := map[string]int{}
+	bound := 0
+	for i, c := range f.cmds {
+		if c.Type == models.C_LABEL {
+			labels[c.Arg1] = i
+		}
+		if _, d := stackEffect(c); d > 0 {
+			bound += d
+		}
+	}
+
+	msgs := map[int]string{}
+	report := func(i int, format string, a ...interface{}) {
+		if _, ok := msgs[i]; !ok {
+			msgs[i] = fmt.Sprintf(format, a...)
+		}
+	}
+
+	// in[len(f.cmds)] is the depth of paths running off the end.
+	in := make([]*depthRange, len(f.cmds)+1)
+	in[0] = &depthRange{}
+	work := []int{0}
+	join := func(i int, r depthRange) {
+		old := in[i]
+		if old != nil {
+			if old.lo <= r.lo && old.hi >= r.hi {
+				return
+			}
+			if old.lo < r.lo {
+				r.lo = old.lo
+			}
+			if old.hi > r.hi {
+				r.hi = old.hi
+			}
+		}
+		if r.hi != growing && r.hi > bound {
+			r.hi = growing
+			report(i, "stack grows without bound in a loop")
+		}
+		in[i] = &r
+		work = append(work, i)
+	}
+
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		if i == len(f.cmds) {
+			continue
+		}
+
+		c := f.cmds[i]
+		r := *in[i]
+		need, delta := stackEffect(c)
+		if c.Type == models.C_RETURN {
+			if r.lo < need {
+				report(i, "return with an empty stack")
+			}
+			continue
+		}
+		if r.lo < need {
+			report(i, "pop of an empty stack: needs %d, has %d", need, r.lo)
+			r.lo = need
+		}
+		if r.hi < need {
+			r.hi = need
+		}
+		out := depthRange{lo: r.lo + delta, hi: r.hi}
+		if r.hi != growing {
+			out.hi = r.hi + delta
+		}
+
+		switch c.Type {
+		case models.C_GOTO, models.C_IF:
+			t, ok := labels[c.Arg1]
+			if !ok {
+				report(i, "undefined label %s", c.Arg1)
+			} else {
+				join(t, out)
+			}
+			if c.Type == models.C_GOTO {
+				continue
+			}
+		}
+		join(i+1, out)
+	}
+
+	f.MaxDepth = 0
+	f.sites = []callSite{}
+	for i, r := range in {
+		if r == nil {
+			continue
+		}
+		switch {
+		case r.hi == growing:
+			f.MaxDepth = Unbounded
+		case f.MaxDepth != Unbounded && r.hi > f.MaxDepth:
+			f.MaxDepth = r.hi
+		}
+		if i == len(f.cmds) {
+			if f.frame > 0 {
+				report(i-1, "function ends without return")
+			}
+			continue
+		}
+
+		c := f.cmds[i]
+		switch {
+		case c.Type == models.C_LABEL && r.lo != r.hi && r.hi != growing:
+			report(i, "stack depth differs between paths: %d to %d", r.lo, r.hi)
+		case c.Type == models.C_CALL:
+			f.sites = append(f.sites, callSite{function: c.Arg1, depth: r.hi})
+		}
+	}
+
+	is := make([]int, 0, len(msgs))
+	for i := range msgs {
+		is = append(is, i)
+	}
+	sort.Ints(is)
+	issues := []*Issue{}
+	for _, i := range is {
+		c := f.cmds[i]
+		issues = append(issues, &Issue{
+			File:     c.File,
+			Line:     c.Line,
+			Function: f.Name,
+			Command:  c.Text,
+			Message:  msgs[i],
+		})
+	}
+
+	return issues
+}
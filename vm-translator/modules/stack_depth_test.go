@@ -0,0 +1,74 @@
+package modules
+
+import (
+	"testing"
+
+	"github.com/terashin777/vm-translator/models"
+)
+
+func TestAnalyzeIssues(t *testing.T) {
+	src := `function Bad.pop 0
+pop temp 0
+push constant 0
+return
+function Bad.loop 0
+label L
+push constant 1
+goto L
+function Bad.paths 0
+push constant 0
+if-goto L
+push constant 1
+label L
+return
+function Bad.end 0
+push constant 1
+`
+	a := Analyze(readVM(t, vmFile{"Bad.vm", src}))
+	want := []struct {
+		fn   string
+		line int
+		msg  string
+	}{
+		{"Bad.pop", 2, "pop of an empty stack: needs 1, has 0"},
+		{"Bad.loop", 8, "stack grows without bound in a loop"},
+		{"Bad.paths", 13, "stack depth differs between paths: 0 to 1"},
+		{"Bad.paths", 14, "return with an empty stack"},
+		{"Bad.end", 16, "function ends without return"},
+	}
+	if len(a.Issues) != len(want) {
+		t.Fatalf("%d issues, want %d: %v", len(a.Issues), len(want), a.Issues)
+	}
+	for i, w := range want {
+		is := a.Issues[i]
+		if is.Function != w.fn || is.Line != w.line || is.Message != w.msg {
+			t.Errorf("issue %d is %s in %s, want %s:%d %s", i, is, is.Function, w.fn, w.line, w.msg)
+		}
+	}
+
+	if f := functionInfo(t, a, "Bad.loop"); f.MaxDepth != Unbounded || f.Stack != Unbounded {
+		t.Errorf("Bad.loop: %+v", *f)
+	}
+}
+
+func TestStackEffect(t *testing.T) {
+	tests := []struct {
+		c    *Command
+		need int
+		diff int
+	}{
+		{&Command{Type: models.C_PUSH, Arg1: "local"}, 0, 1},
+		{&Command{Type: models.C_POP, Arg1: "local"}, 1, -1},
+		{&Command{Type: models.C_ARITHMETIC, Arg1: "add"}, 2, -1},
+		{&Command{Type: models.C_ARITHMETIC, Arg1: "not"}, 1, 0},
+		{&Command{Type: models.C_IF, Arg1: "L"}, 1, -1},
+		{&Command{Type: models.C_CALL, Arg1: "F.f", Arg2: 3}, 3, -2},
+		{&Command{Type: models.C_CALL, Arg1: "F.f", Arg2: 0}, 0, 1},
+		{&Command{Type: models.C_LABEL, Arg1: "L"}, 0, 0},
+	}
+	for _, tt := range tests {
+		if need, diff := stackEffect(tt.c); need != tt.need || diff != tt.diff {
+			t.Errorf("%v %s: %d, %d, want %d, %d", tt.c.Type, tt.c.Arg1, need, diff, tt.need, tt.diff)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package modules
+
+import (
+	"fmt"
+)
+
+// TraceTranslator translates VM code to a text with a line per command,
+// naming the function the command is in and the Hack RAM it reads or writes,
+// so a run of the Hack code can be followed against it. A line is
+//
+//	function<TAB>command operands
+//
+// where RAM is written as RAM[address], with LCL, ARG, THIS and THAT for the
+// registers holding segment bases and file.index for statics, as the Hack
+// symbols are named.
+type TraceTranslator struct {
+	fileName string
+	fn       string
+}
+
+func NewTraceTranslator() *TraceTranslator {
+	return &TraceTranslator{}
+}
+
+func (t *TraceTranslator) SetFunctionName(n string) {
+	t.fileName = n
+	t.fn = n
+}
+
+func (t *TraceTranslator) line(format string, a ...interface{}) string {
+	return fmt.Sprintf("%s\t%s\n", t.fn, fmt.Sprintf(format, a...))
+}
+
+func (t *TraceTranslator) TranslateInit() string {
+	return "bootstrap\tset RAM[0] 256\nbootstrap\tcall Sys.init 0\n"
+}
+
+func (t *TraceTranslator) TranslateArithmetic(c string) string {
+	if _, ok := arithmethicCmds[c]; !ok {
+		return ""
+	}
+
+	return t.line("%s", c)
+}
+
+// address returns the RAM word of a segment.
+func (t *TraceTranslator) address(seg string, i int) string {
+	switch seg {
+	case "local", "argument", "this", "that":
+		return fmt.Sprintf("RAM[%s+%d]", memoryMap[seg], i)
+	case "pointer", "temp":
+		return fmt.Sprintf("RAM[%d]", baseAddrMap[seg]+i)
+	case "static":
+		return fmt.Sprintf("RAM[%s.%d]", t.fileName, i)
+	}
+
+	return ""
+}
+
+func (t *TraceTranslator) TranslatePush(seg string, i int) string {
+	if seg == "constant" {
+		return t.line("push %d", i)
+	}
+	a := t.address(seg, i)
+	if a == "" {
+		return ""
+	}
+
+	return t.line("push %s", a)
+}
+
+func (t *TraceTranslator) TranslatePop(seg string, i int) string {
+	a := t.address(seg, i)
+	if a == "" {
+		return ""
+	}
+
+	return t.line("pop %s", a)
+}
+
+func (t *TraceTranslator) TranslateLabel(l string) string {
+	return t.line("label %s", l)
+}
+
+func (t *TraceTranslator) TranslateGoto(l string) string {
+	return t.line("goto %s", l)
+}
+
+func (t *TraceTranslator) TranslateIf(l string) string {
+	return t.line("if-goto %s", l)
+}
+
+func (t *TraceTranslator) TranslateCall(f string, n int) string {
+	return t.line("call %s %d", f, n)
+}
+
+func (t *TraceTranslator) TranslateReturn() string {
+	return t.line("return")
+}
+
+func (t *TraceTranslator) TranslateFunction(f string, k int) string {
+	t.fn = f
+	return t.line("function %s %d", f, k)
+}
+
+// TranslateEnd returns nothing, as a trace has no code of its own.
+func (t *TraceTranslator) TranslateEnd() string {
+	return ""
+}
@@ -0,0 +1,50 @@
+package modules
+
+import (
+	"testing"
+)
+
+func TestTraceTranslator(t *testing.T) {
+	tr := NewTraceTranslator()
+	got := tr.TranslateInit()
+	tr.SetFunctionName("Main")
+	for _, s := range []string{
+		tr.TranslateFunction("Main.f", 1),
+		tr.TranslatePush("constant", 7),
+		tr.TranslatePop("local", 0),
+		tr.TranslatePush("static", 2),
+		tr.TranslatePop("temp", 3),
+		tr.TranslatePush("pointer", 1),
+		tr.TranslateArithmetic("add"),
+		tr.TranslateIf("LOOP"),
+		tr.TranslateCall("Math.max", 2),
+		tr.TranslateReturn(),
+		tr.TranslateEnd(),
+	} {
+		got += s
+	}
+
+	want := "bootstrap\tset RAM[0] 256\n" +
+		"bootstrap\tcall Sys.init 0\n" +
+		"Main.f\tfunction Main.f 1\n" +
+		"Main.f\tpush 7\n" +
+		"Main.f\tpop RAM[LCL+0]\n" +
+		"Main.f\tpush RAM[Main.2]\n" +
+		"Main.f\tpop RAM[8]\n" +
+		"Main.f\tpush RAM[4]\n" +
+		"Main.f\tadd\n" +
+		"Main.f\tif-goto LOOP\n" +
+		"Main.f\tcall Math.max 2\n" +
+		"Main.f\treturn\n"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestTraceTranslatorSkipsUnknownCommands(t *testing.T) {
+	tr := NewTraceTranslator()
+	tr.SetFunctionName("Main")
+	if s := tr.TranslatePush("heap", 0) + tr.TranslatePop("constant", 0) + tr.TranslateArithmetic("mul"); s != "" {
+		t.Errorf("got %q", s)
+	}
+}
@@ -2,9 +2,6 @@ package modules
 
 import (
 	"fmt"
-	"strings"
-
-	"github.com/terashin777/vm-translator/utils"
 )
 
 var memoryMap = map[string]string{
@@ -22,14 +19,25 @@ var baseAddrMap = map[string]int{
 type Translator struct {
 	fileName    string
 	symbolCount int
+
+	// compact makes calls, returns and comparisons jump to routines shared
+	// by the whole program, which used tells to write at the end.
+	compact bool
+	used    map[string]bool
 }
 
 func NewTranslator() *Translator {
 	return &Translator{
 		symbolCount: 0,
+		used:        map[string]bool{},
 	}
 }
 
+// SetCompact makes the translator write compact code instead of inline code.
+func (t *Translator) SetCompact(b bool) {
+	t.compact = b
+}
+
 func (t *Translator) SetFunctionName(n string) {
 	t.fileName = n
 }
@@ -130,9 +138,6 @@ M=D`
 }
 
 func (t *Translator) TranslatePop(seg string, i int) string {
-	if i == -1 {
-		fmt.Print("ok")
-	}
 	switch seg {
 	case "local", "argument", "this", "that":
 		return fmt.Sprintf(`@%s
@@ -168,38 +173,10 @@ M=D
 }
 
 func (t *Translator) TranslateLabel(l string) string {
-	t.validateLabel(l)
 	return fmt.Sprintf(`(%s)
 `, l)
 }
 
-func (t *Translator) validateLabel(l string) {
-	if t.isStartWithNumber(l) {
-		panic(fmt.Errorf("invalid format label: label is not allowed to start with number"))
-	}
-	if !t.isValidLabelChar(l) {
-		panic(fmt.Errorf("invalid format label: allowed label character is alphabet, number, _, ., : or $"))
-	}
-}
-
-func (t *Translator) isStartWithNumber(l string) bool {
-	return utils.Char.IsNumber(rune(l[0]))
-}
-
-func (t *Translator) isValidLabelChar(l string) bool {
-	for _, r := range l {
-		if !t.isIncludeValidLabelCharOnly(r) {
-			return false
-		}
-	}
-
-	return true
-}
-
-func (t *Translator) isIncludeValidLabelCharOnly(r rune) bool {
-	return utils.Char.IsAlphabet(r) || utils.Char.IsNumber(r) || strings.ContainsRune("_.:$", r)
-}
-
 func (t *Translator) TranslateGoto(l string) string {
 	return fmt.Sprintf(`@%s
 0;JMP
@@ -224,6 +201,9 @@ func (t *Translator) TranslateCall(f string, n int) string {
 func (t *Translator) translateCall(f string, n int) string {
 	t.symbolCount++
 	ret := fmt.Sprintf("%s_RETURN%d", f, t.symbolCount)
+	if t.compact {
+		return t.compactCall(f, n, ret)
+	}
 	return fmt.Sprintf(`%[1]s
 %[2]s 
 %[3]s
@@ -269,6 +249,17 @@ D=M
 }
 
 func (t *Translator) TranslateReturn() string {
+	if t.compact {
+		t.used[routineReturn] = true
+		return fmt.Sprintf(`@%s
+0;JMP
+`, routineReturn)
+	}
+
+	return t.translateReturn()
+}
+
+func (t *Translator) translateReturn() string {
 	frame := "13"
 	ret := "14"
 	return fmt.Sprintf(`@LCL
@@ -343,6 +334,10 @@ D=D-1
 
 func (t *Translator) conditionStatement(jmp string) string {
 	t.symbolCount++
+	if t.compact {
+		return t.compactCondition(jmp, fmt.Sprintf("%s_RETURN%d", jmp, t.symbolCount))
+	}
+
 	lb := fmt.Sprintf("%s_TRUE%d", jmp, t.symbolCount)
 	return fmt.Sprintf(`@SP
 AM=M-1
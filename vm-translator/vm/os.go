@@ -0,0 +1,210 @@
+package vm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/terashin777/vm-translator/models"
+	"github.com/terashin777/vm-translator/modules"
+)
+
+// Native is a function of the Jack OS implemented in Go. It gets the
+// arguments of the call, this first for methods, and returns the return
+// value. Void functions return 0 as the compiled OS does.
+type Native func(v *VM, args []int16) (int16, error)
+
+// natives are the functions of the Jack OS by their VM names. A call is only
+// made to a native when the program does not define the function, so a .vm
+// implementation of a class always wins.
+var natives = map[string]Native{}
+
+// nativeFile is the file reported for the commands of the native Sys.init.
+const nativeFile = "(native)"
+
+// osInit are the functions the native Sys.init calls in order, as the
+// compiled OS does. It halts afterwards.
+var osInit = []string{"Memory.init", "Math.init", "Screen.init", "Output.init", "Keyboard.init", "Main.main"}
+
+// errHalt is returned by natives to halt the program.
+var errHalt = errors.New("halt")
+
+var ErrNoInput = errors.New("keyboard input is exhausted")
+
+// sysErrors are what the error codes of the Jack OS mean.
+var sysErrors = map[int16]string{
+	1:  "duration must be positive",
+	2:  "array size must be positive",
+	3:  "division by zero",
+	4:  "cannot compute square root of a negative number",
+	5:  "allocated memory size must be positive",
+	6:  "heap overflow",
+	7:  "illegal pixel coordinates",
+	8:  "illegal line coordinates",
+	9:  "illegal rectangle coordinates",
+	12: "illegal center coordinates",
+	13: "illegal radius",
+	14: "maximum length must be non-negative",
+	15: "string index out of bounds",
+	16: "string index out of bounds",
+	17: "string is full",
+	18: "string is empty",
+	19: "insufficient string capacity",
+	20: "illegal cursor location",
+}
+
+// SysError is a call of Sys.error, which the OS makes when a function is
+// called wrongly. It halts the program.
+type SysError struct {
+	Code int16
+}
+
+func (e *SysError) Error() string {
+	if m, ok := sysErrors[e.Code]; ok {
+		return fmt.Sprintf("Sys.error %d: %s", e.Code, m)
+	}
+
+	return fmt.Sprintf("Sys.error %d", e.Code)
+}
+
+// jackOS is the state the natives keep outside of RAM.
+type jackOS struct {
+	heap  *heap
+	row   int
+	col   int
+	black bool
+	in    *bufio.Reader
+	out   io.Writer
+}
+
+func newJackOS() *jackOS {
+	return &jackOS{
+		heap:  newHeap(),
+		black: true,
+		in:    bufio.NewReader(eofReader{}),
+		out:   io.Discard,
+	}
+}
+
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+// SetInput sets the text typed on the keyboard for Keyboard.readChar and
+// the functions using it. A newline is the newline key.
+func (v *VM) SetInput(r io.Reader) {
+	v.os.in = bufio.NewReader(r)
+}
+
+// SetOutput sets where the text printed by Output is copied to, besides the
+// screen. Moves of the cursor are not copied.
+func (v *VM) SetOutput(w io.Writer) {
+	v.os.out = w
+}
+
+// Call runs fn with args until it returns and returns its return value. fn
+// is a function of the program or a native, so natives can call other
+// classes without knowing how they are implemented.
+func (v *VM) Call(fn string, args ...int16) (int16, error) {
+	t, ok := v.funcs[fn]
+	if !ok {
+		if n, ok := natives[fn]; ok {
+			return n(v, args)
+		}
+		return 0, fmt.Errorf("undefined function %s", fn)
+	}
+
+	pc := v.pc
+	for _, a := range args {
+		v.push(a)
+	}
+	v.call(len(v.prog), t, len(args))
+	for v.pc != len(v.prog) {
+		if v.halted {
+			return 0, errHalt
+		}
+		if v.limit != 0 && v.steps >= v.limit {
+			return 0, ErrStepLimit
+		}
+		if err := v.Step(); err != nil {
+			return 0, err
+		}
+	}
+	v.pc = pc
+
+	return v.pop(), nil
+}
+
+// callNative pops the arguments of in, calls its native and pushes what it
+// returns.
+func (v *VM) callNative(in *instruction) error {
+	args := make([]int16, in.arg2)
+	for i := in.arg2 - 1; i >= 0; i-- {
+		args[i] = v.pop()
+	}
+
+	x, err := in.native(v, args)
+	var e *modules.Error
+	switch {
+	case err == errHalt:
+		v.halted = true
+		return nil
+	case err == ErrStepLimit, errors.As(err, &e):
+		// Errors of functions the native called tell where they are.
+		return err
+	case err != nil:
+		return v.errorOf(in, err)
+	}
+	v.push(x)
+
+	return nil
+}
+
+// nativeInit returns the commands of a Sys.init that initializes the OS,
+// calls Main.main and halts.
+func nativeInit() []*instruction {
+	fn := "Sys.init"
+	ins := []*instruction{
+		{ct: models.C_FUNCTION, arg1: fn, command: "function Sys.init 0"},
+	}
+	for _, f := range append(osInit, "Sys.halt") {
+		ins = append(ins,
+			&instruction{ct: models.C_CALL, arg1: f, command: fmt.Sprintf("call %s 0", f)},
+			&instruction{ct: models.C_POP, arg1: "temp", command: "pop temp 0"},
+		)
+	}
+	for _, in := range ins {
+		in.fn = fn
+		in.file = nativeFile
+	}
+
+	return ins
+}
+
+// arg returns the i-th argument, or 0 when the call has fewer.
+func arg(args []int16, i int) int16 {
+	if i >= len(args) {
+		return 0
+	}
+
+	return args[i]
+}
+
+func init() {
+	natives["Sys.halt"] = func(v *VM, args []int16) (int16, error) {
+		return 0, errHalt
+	}
+	natives["Sys.error"] = func(v *VM, args []int16) (int16, error) {
+		return 0, &SysError{Code: arg(args, 0)}
+	}
+	natives["Sys.wait"] = func(v *VM, args []int16) (int16, error) {
+		// Natives take no time, so there is nothing to wait for.
+		if arg(args, 0) < 0 {
+			return 0, &SysError{Code: 1}
+		}
+		return 0, nil
+	}
+}
@@ -0,0 +1,93 @@
+package vm
+
+import "io"
+
+// readKey returns the next key typed on the input. A newline is the
+// newline key and a backspace or a delete the backspace key.
+func (v *VM) readKey() (int16, error) {
+	c, err := v.os.in.ReadByte()
+	if err == io.EOF {
+		return 0, ErrNoInput
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	switch c {
+	case '\n':
+		return NewLine, nil
+	case '\b', 0x7f:
+		return BackSpace, nil
+	case '\r':
+		return v.readKey()
+	}
+	return int16(c), nil
+}
+
+// readLine prints message and reads a line into a new string. readChar
+// echoes the keys, so the line ends up on the screen as it is typed.
+func (v *VM) readLine(message int16) (int16, error) {
+	if _, err := v.Call("Output.printString", message); err != nil {
+		return 0, err
+	}
+	s, err := v.Call("String.new", OutputCols)
+	if err != nil {
+		return 0, err
+	}
+
+	for n := 0; ; {
+		c, err := v.Call("Keyboard.readChar")
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case c == NewLine:
+			return s, nil
+		case c == BackSpace:
+			if n > 0 {
+				_, err = v.Call("String.eraseLastChar", s)
+				n--
+			}
+		case n < OutputCols:
+			_, err = v.Call("String.appendChar", s, c)
+			n++
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func init() {
+	natives["Keyboard.init"] = func(v *VM, args []int16) (int16, error) {
+		return 0, nil
+	}
+	natives["Keyboard.keyPressed"] = func(v *VM, args []int16) (int16, error) {
+		return v.Peek(KBD), nil
+	}
+	natives["Keyboard.readChar"] = func(v *VM, args []int16) (int16, error) {
+		c, err := v.readKey()
+		if err != nil {
+			return 0, err
+		}
+		if _, err := v.Call("Output.printChar", c); err != nil {
+			return 0, err
+		}
+		return c, nil
+	}
+	natives["Keyboard.readLine"] = func(v *VM, args []int16) (int16, error) {
+		return v.readLine(arg(args, 0))
+	}
+	natives["Keyboard.readInt"] = func(v *VM, args []int16) (int16, error) {
+		s, err := v.readLine(arg(args, 0))
+		if err != nil {
+			return 0, err
+		}
+		x, err := v.Call("String.intValue", s)
+		if err != nil {
+			return 0, err
+		}
+		_, err = v.Call("String.dispose", s)
+		return x, err
+	}
+}
@@ -0,0 +1,46 @@
+package vm
+
+func init() {
+	natives["Math.init"] = func(v *VM, args []int16) (int16, error) {
+		return 0, nil
+	}
+	natives["Math.abs"] = func(v *VM, args []int16) (int16, error) {
+		x := arg(args, 0)
+		if x < 0 {
+			return -x, nil
+		}
+		return x, nil
+	}
+	natives["Math.multiply"] = func(v *VM, args []int16) (int16, error) {
+		return arg(args, 0) * arg(args, 1), nil
+	}
+	natives["Math.divide"] = func(v *VM, args []int16) (int16, error) {
+		if arg(args, 1) == 0 {
+			return 0, &SysError{Code: 3}
+		}
+		return arg(args, 0) / arg(args, 1), nil
+	}
+	natives["Math.min"] = func(v *VM, args []int16) (int16, error) {
+		if arg(args, 0) < arg(args, 1) {
+			return arg(args, 0), nil
+		}
+		return arg(args, 1), nil
+	}
+	natives["Math.max"] = func(v *VM, args []int16) (int16, error) {
+		if arg(args, 0) > arg(args, 1) {
+			return arg(args, 0), nil
+		}
+		return arg(args, 1), nil
+	}
+	natives["Math.sqrt"] = func(v *VM, args []int16) (int16, error) {
+		x := int(arg(args, 0))
+		if x < 0 {
+			return 0, &SysError{Code: 4}
+		}
+		y := 0
+		for (y+1)*(y+1) <= x {
+			y++
+		}
+		return int16(y), nil
+	}
+}
@@ -0,0 +1,108 @@
+package vm
+
+import "sort"
+
+// The heap is the RAM between the statics and the stack and the screen.
+const (
+	HeapBase = 2048
+	HeapEnd  = ScreenBase
+)
+
+// block is a run of free heap words.
+type block struct {
+	addr int
+	size int
+}
+
+// heap allocates RAM first fit. Sizes of the allocated blocks are kept here
+// rather than in RAM, so programs writing past their arrays do not break it.
+type heap struct {
+	free  []block
+	sizes map[int]int
+}
+
+func newHeap() *heap {
+	return &heap{
+		free:  []block{{addr: HeapBase, size: HeapEnd - HeapBase}},
+		sizes: map[int]int{},
+	}
+}
+
+func (h *heap) alloc(size int) (int, bool) {
+	for i, b := range h.free {
+		if b.size < size {
+			continue
+		}
+		if b.size == size {
+			h.free = append(h.free[:i], h.free[i+1:]...)
+		} else {
+			h.free[i] = block{addr: b.addr + size, size: b.size - size}
+		}
+		h.sizes[b.addr] = size
+		return b.addr, true
+	}
+
+	return 0, false
+}
+
+// deAlloc frees the block at addr and merges it with its free neighbours.
+// Addresses that were not allocated are ignored.
+func (h *heap) deAlloc(addr int) {
+	size, ok := h.sizes[addr]
+	if !ok {
+		return
+	}
+	delete(h.sizes, addr)
+
+	i := sort.Search(len(h.free), func(i int) bool { return h.free[i].addr > addr })
+	h.free = append(h.free, block{})
+	copy(h.free[i+1:], h.free[i:])
+	h.free[i] = block{addr: addr, size: size}
+	if i+1 < len(h.free) && h.free[i].addr+h.free[i].size == h.free[i+1].addr {
+		h.free[i].size += h.free[i+1].size
+		h.free = append(h.free[:i+1], h.free[i+2:]...)
+	}
+	if i > 0 && h.free[i-1].addr+h.free[i-1].size == h.free[i].addr {
+		h.free[i-1].size += h.free[i].size
+		h.free = append(h.free[:i], h.free[i+1:]...)
+	}
+}
+
+func init() {
+	natives["Memory.init"] = func(v *VM, args []int16) (int16, error) {
+		v.os.heap = newHeap()
+		return 0, nil
+	}
+	natives["Memory.peek"] = func(v *VM, args []int16) (int16, error) {
+		return v.Peek(int(uint16(arg(args, 0)))), nil
+	}
+	natives["Memory.poke"] = func(v *VM, args []int16) (int16, error) {
+		v.Poke(int(uint16(arg(args, 0))), arg(args, 1))
+		return 0, nil
+	}
+	natives["Memory.alloc"] = func(v *VM, args []int16) (int16, error) {
+		size := arg(args, 0)
+		if size <= 0 {
+			return 0, &SysError{Code: 5}
+		}
+		a, ok := v.os.heap.alloc(int(size))
+		if !ok {
+			return 0, &SysError{Code: 6}
+		}
+		return int16(a), nil
+	}
+	natives["Memory.deAlloc"] = func(v *VM, args []int16) (int16, error) {
+		v.os.heap.deAlloc(int(uint16(arg(args, 0))))
+		return 0, nil
+	}
+
+	natives["Array.new"] = func(v *VM, args []int16) (int16, error) {
+		if arg(args, 0) <= 0 {
+			return 0, &SysError{Code: 2}
+		}
+		return v.Call("Memory.alloc", arg(args, 0))
+	}
+	natives["Array.dispose"] = func(v *VM, args []int16) (int16, error) {
+		return v.Call("Memory.deAlloc", arg(args, 0))
+	}
+}
@@ -0,0 +1,154 @@
+package vm
+
+// drawPixel sets the pixel at x, y in the current color. The least
+// significant bit of a word is its leftmost pixel.
+func (v *VM) drawPixel(x, y int) {
+	a := ScreenBase + y*ScreenWidth/16 + x/16
+	w := v.Peek(a)
+	if v.os.black {
+		w |= 1 << (x % 16)
+	} else {
+		w &^= 1 << (x % 16)
+	}
+	v.Poke(a, w)
+}
+
+// drawRow draws the pixels from x1 to x2 of row y that are on the screen.
+func (v *VM) drawRow(x1, x2, y int) {
+	if y < 0 || y >= ScreenHeight {
+		return
+	}
+	if x1 < 0 {
+		x1 = 0
+	}
+	if x2 >= ScreenWidth {
+		x2 = ScreenWidth - 1
+	}
+	for x := x1; x <= x2; x++ {
+		v.drawPixel(x, y)
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+
+	return x
+}
+
+func onScreen(x, y int) bool {
+	return x >= 0 && x < ScreenWidth && y >= 0 && y < ScreenHeight
+}
+
+func init() {
+	natives["Screen.init"] = func(v *VM, args []int16) (int16, error) {
+		v.os.black = true
+		return 0, nil
+	}
+	natives["Screen.clearScreen"] = func(v *VM, args []int16) (int16, error) {
+		for a := ScreenBase; a < KBD; a++ {
+			v.Poke(a, 0)
+		}
+		return 0, nil
+	}
+	natives["Screen.setColor"] = func(v *VM, args []int16) (int16, error) {
+		v.os.black = arg(args, 0) != 0
+		return 0, nil
+	}
+	natives["Screen.drawPixel"] = func(v *VM, args []int16) (int16, error) {
+		x, y := int(arg(args, 0)), int(arg(args, 1))
+		if !onScreen(x, y) {
+			return 0, &SysError{Code: 7}
+		}
+		v.drawPixel(x, y)
+		return 0, nil
+	}
+	natives["Screen.drawLine"] = func(v *VM, args []int16) (int16, error) {
+		x1, y1 := int(arg(args, 0)), int(arg(args, 1))
+		x2, y2 := int(arg(args, 2)), int(arg(args, 3))
+		if !onScreen(x1, y1) || !onScreen(x2, y2) {
+			return 0, &SysError{Code: 8}
+		}
+
+		// Bresenham's algorithm along the longer axis, drawing the same
+		// pixels as the compiled OS.
+		dx, dy := abs(x2-x1), abs(y2-y1)
+		steep := dx < dy
+		if steep && y2 < y1 || !steep && x2 < x1 {
+			x1, y1, x2, y2 = x2, y2, x1, y1
+		}
+		a, b, end, down := x1, y1, x2, y1 > y2
+		if steep {
+			dx, dy = dy, dx
+			a, b, end, down = y1, x1, y2, x1 > x2
+		}
+		plot := func() {
+			if steep {
+				v.drawPixel(b, a)
+			} else {
+				v.drawPixel(a, b)
+			}
+		}
+
+		d := 2*dy - dx
+		plot()
+		for a < end {
+			if d < 0 {
+				d += 2 * dy
+			} else {
+				d += 2 * (dy - dx)
+				if down {
+					b--
+				} else {
+					b++
+				}
+			}
+			a++
+			plot()
+		}
+		return 0, nil
+	}
+	natives["Screen.drawRectangle"] = func(v *VM, args []int16) (int16, error) {
+		x1, y1 := int(arg(args, 0)), int(arg(args, 1))
+		x2, y2 := int(arg(args, 2)), int(arg(args, 3))
+		if !onScreen(x1, y1) || !onScreen(x2, y2) || x1 > x2 || y1 > y2 {
+			return 0, &SysError{Code: 9}
+		}
+		for y := y1; y <= y2; y++ {
+			v.drawRow(x1, x2, y)
+		}
+		return 0, nil
+	}
+	natives["Screen.drawCircle"] = func(v *VM, args []int16) (int16, error) {
+		x, y, r := int(arg(args, 0)), int(arg(args, 1)), int(arg(args, 2))
+		if !onScreen(x, y) {
+			return 0, &SysError{Code: 12}
+		}
+		if r < 0 || !onScreen(x-r, y-r) || !onScreen(x+r, y+r) {
+			return 0, &SysError{Code: 13}
+		}
+
+		// The midpoint algorithm, filling the rows of each octant as the
+		// compiled OS does.
+		fill := func(a, b int) {
+			v.drawRow(x-a, x+a, y-b)
+			v.drawRow(x-a, x+a, y+b)
+			v.drawRow(x-b, x+b, y-a)
+			v.drawRow(x-b, x+b, y+a)
+		}
+		a, b, d := 0, r, 1-r
+		fill(a, b)
+		for b > a {
+			if d < 0 {
+				d += 2*a + 3
+			} else {
+				d += 2*(a-b) + 5
+				b--
+			}
+			a++
+			fill(a, b)
+		}
+		return 0, nil
+	}
+}
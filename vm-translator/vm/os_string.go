@@ -0,0 +1,129 @@
+package vm
+
+import "strconv"
+
+// Character codes of the Hack keyboard the OS knows.
+const (
+	NewLine     = 128
+	BackSpace   = 129
+	DoubleQuote = 34
+)
+
+// A native string is 3 words: its maximum length, its length and the
+// address of an array of its characters, 0 when the maximum length is 0.
+const (
+	strMax = iota
+	strLen
+	strChars
+)
+
+func init() {
+	natives["String.new"] = func(v *VM, args []int16) (int16, error) {
+		max := arg(args, 0)
+		if max < 0 {
+			return 0, &SysError{Code: 14}
+		}
+		s, err := v.Call("Memory.alloc", 3)
+		if err != nil {
+			return 0, err
+		}
+		chars := int16(0)
+		if max > 0 {
+			if chars, err = v.Call("Memory.alloc", max); err != nil {
+				return 0, err
+			}
+		}
+		v.Poke(int(s)+strMax, max)
+		v.Poke(int(s)+strLen, 0)
+		v.Poke(int(s)+strChars, chars)
+		return s, nil
+	}
+	natives["String.dispose"] = func(v *VM, args []int16) (int16, error) {
+		s := int(arg(args, 0))
+		if chars := v.Peek(s + strChars); chars != 0 {
+			if _, err := v.Call("Memory.deAlloc", chars); err != nil {
+				return 0, err
+			}
+		}
+		return v.Call("Memory.deAlloc", int16(s))
+	}
+	natives["String.length"] = func(v *VM, args []int16) (int16, error) {
+		return v.Peek(int(arg(args, 0)) + strLen), nil
+	}
+	natives["String.charAt"] = func(v *VM, args []int16) (int16, error) {
+		s, j := int(arg(args, 0)), arg(args, 1)
+		if j < 0 || j >= v.Peek(s+strLen) {
+			return 0, &SysError{Code: 15}
+		}
+		return v.Peek(int(v.Peek(s+strChars)) + int(j)), nil
+	}
+	natives["String.setCharAt"] = func(v *VM, args []int16) (int16, error) {
+		s, j := int(arg(args, 0)), arg(args, 1)
+		if j < 0 || j >= v.Peek(s+strLen) {
+			return 0, &SysError{Code: 16}
+		}
+		v.Poke(int(v.Peek(s+strChars))+int(j), arg(args, 2))
+		return 0, nil
+	}
+	natives["String.appendChar"] = func(v *VM, args []int16) (int16, error) {
+		s := int(arg(args, 0))
+		n := v.Peek(s + strLen)
+		if n >= v.Peek(s+strMax) {
+			return 0, &SysError{Code: 17}
+		}
+		v.Poke(int(v.Peek(s+strChars))+int(n), arg(args, 1))
+		v.Poke(s+strLen, n+1)
+		return int16(s), nil
+	}
+	natives["String.eraseLastChar"] = func(v *VM, args []int16) (int16, error) {
+		s := int(arg(args, 0))
+		n := v.Peek(s + strLen)
+		if n == 0 {
+			return 0, &SysError{Code: 18}
+		}
+		v.Poke(s+strLen, n-1)
+		return 0, nil
+	}
+	natives["String.intValue"] = func(v *VM, args []int16) (int16, error) {
+		s := int(arg(args, 0))
+		chars := int(v.Peek(s + strChars))
+		x, neg := int16(0), false
+		for j := 0; j < int(v.Peek(s+strLen)); j++ {
+			c := v.Peek(chars + j)
+			if j == 0 && c == '-' {
+				neg = true
+				continue
+			}
+			if c < '0' || c > '9' {
+				break
+			}
+			x = x*10 + c - '0'
+		}
+		if neg {
+			return -x, nil
+		}
+		return x, nil
+	}
+	natives["String.setInt"] = func(v *VM, args []int16) (int16, error) {
+		s := int(arg(args, 0))
+		d := strconv.Itoa(int(arg(args, 1)))
+		if len(d) > int(v.Peek(s+strMax)) {
+			return 0, &SysError{Code: 19}
+		}
+		chars := int(v.Peek(s + strChars))
+		for j := 0; j < len(d); j++ {
+			v.Poke(chars+j, int16(d[j]))
+		}
+		v.Poke(s+strLen, int16(len(d)))
+		return 0, nil
+	}
+	natives["String.newLine"] = func(v *VM, args []int16) (int16, error) {
+		return NewLine, nil
+	}
+	natives["String.backSpace"] = func(v *VM, args []int16) (int16, error) {
+		return BackSpace, nil
+	}
+	natives["String.doubleQuote"] = func(v *VM, args []int16) (int16, error) {
+		return DoubleQuote, nil
+	}
+}
@@ -0,0 +1,259 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// runMain loads main as Main.vm and runs it under the native Sys.init.
+func runMain(t *testing.T, main string) (*VM, error) {
+	t.Helper()
+	v, err := load(t, map[string]string{"Main.vm": main})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+
+	return v, v.Run(1000000)
+}
+
+func TestNativeMath(t *testing.T) {
+	tests := []struct {
+		fn   string
+		args []int16
+		want int16
+	}{
+		{"Math.multiply", []int16{-7, 6}, -42},
+		{"Math.divide", []int16{-43, 6}, -7},
+		{"Math.abs", []int16{-5}, 5},
+		{"Math.min", []int16{3, -2}, -2},
+		{"Math.max", []int16{3, -2}, 3},
+		{"Math.sqrt", []int16{80}, 8},
+	}
+	v := NewVM()
+	for _, tt := range tests {
+		got, err := v.Call(tt.fn, tt.args...)
+		if err != nil || got != tt.want {
+			t.Errorf("%s%v = %d, %v, want %d", tt.fn, tt.args, got, err, tt.want)
+		}
+	}
+}
+
+func TestNativeInit(t *testing.T) {
+	v, err := runMain(t, `function Main.main 0
+push constant 6
+push constant 7
+call Math.multiply 2
+pop static 0
+push constant 0
+return
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Halted() || v.Peek(StaticBase) != 42 {
+		t.Errorf("halted=%v Main.0=%d", v.Halted(), v.Peek(StaticBase))
+	}
+}
+
+func TestProgramOverridesNative(t *testing.T) {
+	v, err := runMain(t, `function Main.main 0
+push constant 6
+push constant 7
+call Math.multiply 2
+pop static 0
+push constant 0
+return
+function Math.multiply 0
+push constant 1
+return
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Peek(StaticBase) != 1 {
+		t.Errorf("Main.0 = %d, the native was called", v.Peek(StaticBase))
+	}
+}
+
+func TestSysError(t *testing.T) {
+	_, err := runMain(t, `function Main.main 0
+push constant 1
+push constant 0
+call Math.divide 2
+return
+`)
+	var e *SysError
+	if !errors.As(err, &e) || e.Code != 3 {
+		t.Fatalf("err = %v, want Sys.error 3", err)
+	}
+	if !strings.Contains(err.Error(), "division by zero") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestNativeMemory(t *testing.T) {
+	v := NewVM()
+	call := func(fn string, args ...int16) int16 {
+		x, err := v.Call(fn, args...)
+		if err != nil {
+			t.Fatalf("%s: %v", fn, err)
+		}
+		return x
+	}
+
+	a := call("Memory.alloc", 10)
+	b := call("Memory.alloc", 5)
+	if a != HeapBase || b != HeapBase+10 {
+		t.Errorf("allocated %d and %d", a, b)
+	}
+	call("Memory.deAlloc", a)
+	if c := call("Memory.alloc", 4); c != a {
+		t.Errorf("freed block is not reused: %d", c)
+	}
+	call("Memory.deAlloc", b)
+	if c := call("Array.new", 12); c != a+4 {
+		t.Errorf("freed blocks are not merged: %d", c)
+	}
+
+	call("Memory.poke", 100, -3)
+	if call("Memory.peek", 100) != -3 {
+		t.Error("Memory.peek does not read Memory.poke")
+	}
+
+	for _, size := range []int16{0, HeapEnd - HeapBase} {
+		var e *SysError
+		if _, err := v.Call("Memory.alloc", size); !errors.As(err, &e) {
+			t.Errorf("Memory.alloc(%d) err = %v", size, err)
+		}
+	}
+}
+
+func TestNativeStrings(t *testing.T) {
+	v := NewVM()
+	s, err := v.Call("String.new", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range "-12x" {
+		if _, err := v.Call("String.appendChar", s, int16(c)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, _ := v.Call("String.length", s); n != 4 {
+		t.Errorf("length = %d", n)
+	}
+	if x, _ := v.Call("String.intValue", s); x != -12 {
+		t.Errorf("intValue = %d", x)
+	}
+	if _, err := v.Call("String.setInt", s, 305); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := v.Call("String.charAt", s, 2); c != '5' {
+		t.Errorf("charAt 2 = %c", c)
+	}
+
+	var e *SysError
+	if _, err := v.Call("String.charAt", s, 3); !errors.As(err, &e) || e.Code != 15 {
+		t.Errorf("charAt past the end err = %v", err)
+	}
+	short, err := v.Call("String.new", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Call("String.setInt", short, 305); !errors.As(err, &e) || e.Code != 19 {
+		t.Errorf("setInt too long err = %v", err)
+	}
+}
+
+func TestNativeOutput(t *testing.T) {
+	v, err := load(t, map[string]string{"Main.vm": `function Main.main 0
+push constant 2
+call String.new 1
+push constant 72
+call String.appendChar 2
+push constant 105
+call String.appendChar 2
+call Output.printString 1
+pop temp 0
+push constant 42
+neg
+call Output.printInt 1
+pop temp 0
+call Output.println 0
+pop temp 0
+push constant 1
+call String.new 1
+push constant 63
+call String.appendChar 2
+call Keyboard.readInt 1
+pop static 0
+push constant 0
+return
+`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	v.SetOutput(&out)
+	v.SetInput(strings.NewReader("13x\b7\n"))
+	if err := v.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Run(1000000); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := out.String(), "Hi-42\n?13x\b7\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if v.Peek(StaticBase) != 137 {
+		t.Errorf("readInt = %d, want 137", v.Peek(StaticBase))
+	}
+	// H is drawn in the low byte of the first word of the second pixel row.
+	if w := v.Peek(ScreenBase + ScreenWidth/16); w&0xff != font['H'][0] {
+		t.Errorf("screen word = %x", w)
+	}
+}
+
+func TestNativeInputExhausted(t *testing.T) {
+	_, err := runMain(t, `function Main.main 0
+call Keyboard.readChar 0
+return
+`)
+	if !errors.Is(err, ErrNoInput) {
+		t.Errorf("err = %v, want %v", err, ErrNoInput)
+	}
+}
+
+func TestNativeScreen(t *testing.T) {
+	v := NewVM()
+	if _, err := v.Call("Screen.drawPixel", 17, 1); err != nil {
+		t.Fatal(err)
+	}
+	if w := v.Peek(ScreenBase + ScreenWidth/16 + 1); w != 2 {
+		t.Errorf("pixel word = %d, want 2", w)
+	}
+	if _, err := v.Call("Screen.drawRectangle", 0, 10, 15, 11); err != nil {
+		t.Fatal(err)
+	}
+	for y := 10; y <= 11; y++ {
+		if w := v.Peek(ScreenBase + y*ScreenWidth/16); w != -1 {
+			t.Errorf("row %d word = %d, want -1", y, w)
+		}
+	}
+	v.Call("Screen.setColor", 0)
+	v.Call("Screen.drawLine", 0, 10, 7, 10)
+	if w := v.Peek(ScreenBase + 10*ScreenWidth/16); w != -256 {
+		t.Errorf("erased word = %d, want -256", w)
+	}
+
+	var e *SysError
+	if _, err := v.Call("Screen.drawPixel", 512, 0); !errors.As(err, &e) || e.Code != 7 {
+		t.Errorf("off screen err = %v", err)
+	}
+}
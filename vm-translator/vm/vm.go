@@ -0,0 +1,467 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/terashin777/vm-translator/models"
+	"github.com/terashin777/vm-translator/modules"
+)
+
+// RAM is laid out as on the Hack platform, so the VM sees the same memory
+// as the translated code does.
+const (
+	RAMSize    = 32768
+	SP         = 0
+	LCL        = 1
+	ARG        = 2
+	THIS       = 3
+	THAT       = 4
+	TempBase   = 5
+	StaticBase = 16
+	StackBase  = 256
+	ScreenBase = 16384
+	KBD        = 24576
+)
+
+// The screen is 512x256 pixels, 32 words a row.
+const (
+	ScreenWidth  = 512
+	ScreenHeight = 256
+)
+
+var ErrStepLimit = errors.New("step limit exceeded")
+
+// pointerOf are the registers holding the base addresses of the segments
+// that move.
+var pointerOf = map[string]int{
+	"local":    LCL,
+	"argument": ARG,
+	"this":     THIS,
+	"that":     THAT,
+}
+
+// instruction is a VM command with where it comes from. target is the index
+// a goto or a call jumps to and addr the RAM address of a static. Calls of
+// functions the program does not define have the native instead.
+type instruction struct {
+	ct      models.CommandType
+	arg1    string
+	arg2    int
+	fn      string
+	file    string
+	line    int
+	command string
+	target  int
+	addr    int
+	native  Native
+}
+
+// VM runs programs written in the VM language on a stack machine. Labels
+// are scoped to the function they are in, as the translator does. Functions
+// of the Jack OS the program does not define are run natively.
+type VM struct {
+	ram   [RAMSize]uint16
+	prog  []*instruction
+	funcs map[string]int
+	pc    int
+	steps uint64
+	limit uint64
+	os    *jackOS
+
+	halted bool
+}
+
+func NewVM() *VM {
+	return &VM{
+		funcs: map[string]int{},
+		os:    newJackOS(),
+	}
+}
+
+// Load parses the .vm files and resets the VM to run from the first
+// command. Problems of the commands are returned as modules.ErrorList.
+// A program with Main.main but no Sys.init gets the Sys.init of the OS.
+func (v *VM) Load(fns []string) error {
+	p, err := modules.NewParser(fns)
+	if err != nil {
+		return err
+	}
+
+	v.prog = []*instruction{}
+	v.funcs = map[string]int{}
+	labels := map[string]int{}
+	statics := map[string]int{}
+	errs := modules.ErrorList{}
+	for {
+		file, err := p.NextFile()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		scope := file
+		for {
+			err := p.Advance()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+
+			in := &instruction{
+				ct:      p.CommandType(),
+				arg1:    p.Arg1(),
+				fn:      scope,
+				file:    p.File(),
+				line:    p.Line(),
+				command: p.Command(),
+			}
+			if err := v.parse(p, in, file, labels, statics); err != nil {
+				errs = append(errs, v.errorOf(in, err))
+				continue
+			}
+			if in.ct == models.C_FUNCTION {
+				scope = in.arg1
+				in.fn = scope
+			}
+			if in.ct != models.C_LABEL {
+				v.prog = append(v.prog, in)
+			}
+		}
+	}
+	if !v.HasFunction("Sys.init") && v.HasFunction("Main.main") {
+		v.funcs["Sys.init"] = len(v.prog)
+		v.prog = append(v.prog, nativeInit()...)
+	}
+
+	for _, in := range v.prog {
+		var err error
+		switch in.ct {
+		case models.C_GOTO, models.C_IF:
+			t, ok := labels[in.fn+"$"+in.arg1]
+			if !ok {
+				err = fmt.Errorf("undefined label %s", in.arg1)
+			}
+			in.target = t
+		case models.C_CALL:
+			t, ok := v.funcs[in.arg1]
+			in.target = t
+			if ok {
+				break
+			}
+			if in.native, ok = natives[in.arg1]; !ok {
+				err = fmt.Errorf("undefined function %s", in.arg1)
+			}
+		}
+		if err != nil {
+			errs = append(errs, v.errorOf(in, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	v.Reset()
+	return nil
+}
+
+// parse checks the arguments of in and resolves what can be resolved
+// before all commands are known.
+func (v *VM) parse(p *modules.Parser, in *instruction, file string, labels, statics map[string]int) error {
+	switch in.ct {
+	case models.C_NONE:
+		return modules.ErrUnknownCommand
+	case models.C_LABEL, models.C_GOTO, models.C_IF:
+		if err := modules.ValidateLabel(in.arg1); err != nil {
+			return err
+		}
+		if in.ct != models.C_LABEL {
+			return nil
+		}
+		l := in.fn + "$" + in.arg1
+		if _, ok := labels[l]; ok {
+			return fmt.Errorf("duplicate label %s", in.arg1)
+		}
+		labels[l] = len(v.prog)
+		return nil
+	case models.C_ARITHMETIC, models.C_RETURN:
+		return nil
+	}
+
+	i, err := p.Arg2()
+	if err != nil {
+		return err
+	}
+	in.arg2 = i
+	switch in.ct {
+	case models.C_PUSH, models.C_POP:
+		if err := modules.ValidateSegment(in.ct, in.arg1, i); err != nil {
+			return err
+		}
+		if in.arg1 != "static" {
+			return nil
+		}
+		s := fmt.Sprintf("%s.%d", file, i)
+		if _, ok := statics[s]; !ok {
+			if len(statics) == modules.MaxStatics {
+				return fmt.Errorf("%w: RAM has room for %d", modules.ErrTooManyStatics, modules.MaxStatics)
+			}
+			statics[s] = StaticBase + len(statics)
+		}
+		in.addr = statics[s]
+	case models.C_FUNCTION:
+		if err := modules.ValidateLabel(in.arg1); err != nil {
+			return err
+		}
+		if _, ok := v.funcs[in.arg1]; ok {
+			return fmt.Errorf("duplicate function %s", in.arg1)
+		}
+		v.funcs[in.arg1] = len(v.prog)
+	case models.C_CALL:
+		return modules.ValidateLabel(in.arg1)
+	}
+
+	return nil
+}
+
+func (v *VM) errorOf(in *instruction, err error) *modules.Error {
+	return &modules.Error{
+		File:    in.file,
+		Line:    in.line,
+		Command: in.command,
+		Err:     err,
+	}
+}
+
+// Reset starts the program again from its first command. RAM is kept as is.
+func (v *VM) Reset() {
+	v.pc = 0
+	v.steps = 0
+	v.halted = false
+}
+
+// Bootstrap sets up the stack and calls Sys.init as the bootstrap code of
+// the translator does. Returning from Sys.init halts the VM.
+func (v *VM) Bootstrap() error {
+	t, ok := v.funcs["Sys.init"]
+	if !ok {
+		return fmt.Errorf("undefined function Sys.init")
+	}
+
+	v.Reset()
+	v.Poke(SP, StackBase)
+	v.call(len(v.prog), t, 0)
+	return nil
+}
+
+// HasFunction tells whether the program defines the function. Natives are
+// not counted.
+func (v *VM) HasFunction(fn string) bool {
+	_, ok := v.funcs[fn]
+	return ok
+}
+
+// Step executes one command.
+func (v *VM) Step() error {
+	if v.Halted() {
+		return nil
+	}
+
+	in := v.prog[v.pc]
+	v.steps++
+	v.pc++
+	switch in.ct {
+	case models.C_ARITHMETIC:
+		v.arithmetic(in.arg1)
+	case models.C_PUSH:
+		v.push(v.read(in))
+	case models.C_POP:
+		x := v.pop()
+		v.Poke(v.address(in), x)
+	case models.C_GOTO:
+		v.jump(in.target)
+	case models.C_IF:
+		if v.pop() != 0 {
+			v.jump(in.target)
+		}
+	case models.C_FUNCTION:
+		for i := 0; i < in.arg2; i++ {
+			v.push(0)
+		}
+	case models.C_CALL:
+		if in.native != nil {
+			return v.callNative(in)
+		}
+		v.call(v.pc, in.target, in.arg2)
+	case models.C_RETURN:
+		v.ret()
+	default:
+		return v.errorOf(in, modules.ErrUnknownCommand)
+	}
+
+	return nil
+}
+
+// Run steps until the program halts. It returns ErrStepLimit when limit
+// commands are executed before that. A limit of 0 means no limit.
+func (v *VM) Run(limit uint64) error {
+	v.limit = 0
+	if limit != 0 {
+		v.limit = v.steps + limit
+	}
+	for !v.Halted() {
+		if v.limit != 0 && v.steps >= v.limit {
+			return ErrStepLimit
+		}
+		if err := v.Step(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Halted reports whether the program ran past its last command or spins in
+// a goto to itself, like Sys.halt does.
+func (v *VM) Halted() bool {
+	return v.halted || v.pc >= len(v.prog)
+}
+
+// Steps returns the number of commands executed since the last reset.
+func (v *VM) Steps() uint64 {
+	return v.steps
+}
+
+// Function returns the function the next command is in.
+func (v *VM) Function() string {
+	if v.pc >= len(v.prog) {
+		return ""
+	}
+
+	return v.prog[v.pc].fn
+}
+
+// Pos returns the file and the line of the next command.
+func (v *VM) Pos() (string, int) {
+	if v.pc >= len(v.prog) {
+		return "", 0
+	}
+
+	return v.prog[v.pc].file, v.prog[v.pc].line
+}
+
+func (v *VM) Peek(addr int) int16 {
+	return int16(v.ram[uint16(addr)%RAMSize])
+}
+
+func (v *VM) Poke(addr int, x int16) {
+	v.ram[uint16(addr)%RAMSize] = uint16(x)
+}
+
+func (v *VM) push(x int16) {
+	sp := int(v.Peek(SP))
+	v.Poke(sp, x)
+	v.Poke(SP, int16(sp+1))
+}
+
+func (v *VM) pop() int16 {
+	sp := int(v.Peek(SP)) - 1
+	v.Poke(SP, int16(sp))
+	return v.Peek(sp)
+}
+
+func (v *VM) read(in *instruction) int16 {
+	if in.arg1 == "constant" {
+		return int16(in.arg2)
+	}
+
+	return v.Peek(v.address(in))
+}
+
+// address returns the RAM address of a segment entry.
+func (v *VM) address(in *instruction) int {
+	switch in.arg1 {
+	case "static":
+		return in.addr
+	case "temp":
+		return TempBase + in.arg2
+	case "pointer":
+		return THIS + in.arg2
+	default:
+		return int(uint16(v.Peek(pointerOf[in.arg1]))) + in.arg2
+	}
+}
+
+func (v *VM) arithmetic(c string) {
+	switch c {
+	case "neg":
+		v.push(-v.pop())
+		return
+	case "not":
+		v.push(^v.pop())
+		return
+	}
+
+	y := v.pop()
+	x := v.pop()
+	switch c {
+	case "add":
+		v.push(x + y)
+	case "sub":
+		v.push(x - y)
+	case "and":
+		v.push(x & y)
+	case "or":
+		v.push(x | y)
+	case "eq":
+		v.push(boolValue(x == y))
+	case "gt":
+		v.push(boolValue(x > y))
+	case "lt":
+		v.push(boolValue(x < y))
+	}
+}
+
+func (v *VM) jump(t int) {
+	if t == v.pc-1 {
+		v.halted = true
+	}
+	v.pc = t
+}
+
+// call pushes the frame of the caller and jumps to the function at t. ret is
+// the index of the command to return to.
+func (v *VM) call(ret, t, n int) {
+	v.push(int16(ret))
+	for _, r := range []int{LCL, ARG, THIS, THAT} {
+		v.push(v.Peek(r))
+	}
+	sp := v.Peek(SP)
+	v.Poke(ARG, sp-int16(n)-5)
+	v.Poke(LCL, sp)
+	v.pc = t
+}
+
+func (v *VM) ret() {
+	frame := int(uint16(v.Peek(LCL)))
+	ret := int(uint16(v.Peek(frame - 5)))
+	v.Poke(int(uint16(v.Peek(ARG))), v.pop())
+	v.Poke(SP, v.Peek(ARG)+1)
+	for i, r := range []int{THAT, THIS, ARG, LCL} {
+		v.Poke(r, v.Peek(frame-1-i))
+	}
+	v.pc = ret
+}
+
+func boolValue(b bool) int16 {
+	if b {
+		return -1
+	}
+
+	return 0
+}
@@ -0,0 +1,196 @@
+package vm
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/terashin777/vm-translator/modules"
+)
+
+// load writes the files into a directory and loads them.
+func load(t *testing.T, files map[string]string) (*VM, error) {
+	t.Helper()
+	dir := t.TempDir()
+	fns := []string{}
+	for fn, src := range files {
+		p := filepath.Join(dir, fn)
+		if err := os.WriteFile(p, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fns = append(fns, p)
+	}
+	v := NewVM()
+
+	return v, v.Load(fns)
+}
+
+// loadDir loads the .vm files of a project directory.
+func loadDir(t *testing.T, dir string) *VM {
+	t.Helper()
+	fns, err := filepath.Glob(filepath.Join("../../projects", dir, "*.vm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := NewVM()
+	if err := v.Load(fns); err != nil {
+		t.Fatal(err)
+	}
+
+	return v
+}
+
+func TestArithmetic(t *testing.T) {
+	tests := []struct {
+		src  string
+		want int16
+	}{
+		{"push constant 7\npush constant 8\nadd", 15},
+		{"push constant 7\npush constant 8\nsub", -1},
+		{"push constant 7\nneg", -7},
+		{"push constant 7\npush constant 7\neq", -1},
+		{"push constant 7\npush constant 8\neq", 0},
+		{"push constant 8\npush constant 7\ngt", -1},
+		{"push constant 7\nneg\npush constant 8\ngt", 0},
+		{"push constant 7\nneg\npush constant 8\nlt", -1},
+		{"push constant 12\npush constant 10\nand", 8},
+		{"push constant 12\npush constant 10\nor", 14},
+		{"push constant 0\nnot", -1},
+	}
+	for _, tt := range tests {
+		v, err := load(t, map[string]string{"T.vm": tt.src})
+		if err != nil {
+			t.Fatal(err)
+		}
+		v.Poke(SP, StackBase)
+		if err := v.Run(0); err != nil {
+			t.Fatal(err)
+		}
+		if v.Peek(SP) != StackBase+1 || v.Peek(StackBase) != tt.want {
+			t.Errorf("%q: SP=%d top=%d, want %d", tt.src, v.Peek(SP), v.Peek(StackBase), tt.want)
+		}
+	}
+}
+
+func TestSegments(t *testing.T) {
+	src := `push constant 3030
+pop pointer 0
+push constant 3040
+pop pointer 1
+push constant 32
+pop this 2
+push constant 46
+pop that 6
+push constant 5
+pop temp 6
+push constant 9
+pop static 1
+push this 2
+push that 6
+add
+push temp 6
+add
+push static 1
+add
+`
+	v, err := load(t, map[string]string{"T.vm": src})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Poke(SP, StackBase)
+	if err := v.Run(0); err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]int16{THIS: 3030, THAT: 3040, 3032: 32, 3046: 46, 11: 5, StaticBase: 9, StackBase: 92}
+	for a, x := range want {
+		if got := v.Peek(a); got != x {
+			t.Errorf("RAM[%d] = %d, want %d", a, got, x)
+		}
+	}
+}
+
+func TestProjectPrograms(t *testing.T) {
+	tests := []struct {
+		dir  string
+		want map[int]int16
+	}{
+		{"08/FunctionCalls/FibonacciElement", map[int]int16{0: 262, 261: 3}},
+		{"08/FunctionCalls/StaticsTest", map[int]int16{0: 263, 261: -2, 262: 8}},
+	}
+	for _, tt := range tests {
+		v := loadDir(t, tt.dir)
+		if err := v.Bootstrap(); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.Run(100000); err != nil {
+			t.Fatalf("%s: %v", tt.dir, err)
+		}
+		for a, x := range tt.want {
+			if got := v.Peek(a); got != x {
+				t.Errorf("%s: RAM[%d] = %d, want %d", tt.dir, a, got, x)
+			}
+		}
+	}
+}
+
+func TestFunctionsAndPositions(t *testing.T) {
+	v := loadDir(t, "08/FunctionCalls/FibonacciElement")
+	if err := v.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	if fn := v.Function(); fn != "Sys.init" {
+		t.Errorf("starts in %s", fn)
+	}
+	for v.Function() != "Main.fibonacci" {
+		if err := v.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if file, line := v.Pos(); filepath.Base(file) != "Main.vm" || line == 0 {
+		t.Errorf("Main.fibonacci is at %s:%d", file, line)
+	}
+}
+
+func TestStepLimit(t *testing.T) {
+	v, err := load(t, map[string]string{"T.vm": "label L\npush constant 1\npop temp 0\ngoto L\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Run(100); err != ErrStepLimit {
+		t.Errorf("err = %v, want %v", err, ErrStepLimit)
+	}
+	if v.Steps() != 100 {
+		t.Errorf("ran %d steps", v.Steps())
+	}
+
+	v, err = load(t, map[string]string{"T.vm": "push constant 1\nlabel END\ngoto END\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Run(100); err != nil || !v.Halted() {
+		t.Errorf("a goto to itself does not halt: err=%v halted=%v", err, v.Halted())
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"goto NOWHERE\n", "undefined label NOWHERE"},
+		{"call Nothing.f 0\n", "undefined function Nothing.f"},
+		{"function F.f 0\nfunction F.f 0\n", "duplicate function F.f"},
+		{"label L\nlabel L\n", "duplicate label L"},
+		{"pop constant 1\n", modules.ErrPopConstant.Error()},
+		{"frob\n", modules.ErrUnknownCommand.Error()},
+	}
+	for _, tt := range tests {
+		_, err := load(t, map[string]string{"T.vm": tt.src})
+		var errs modules.ErrorList
+		if !errors.As(err, &errs) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: err = %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}